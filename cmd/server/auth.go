@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/internal/config"
+	"github.com/brandon/mcp-email/internal/email"
+	"github.com/brandon/mcp-email/internal/oauth"
+)
+
+const authUsage = `Usage: mcp-email-server auth [flags] <account>
+
+Signs in to an account's OAuth2 provider and saves the refresh token in the
+secrets file if one is configured, or in the account's token file. Then
+logs in to IMAP and SMTP with the new token, as whoami does.
+
+The browser flow opens the provider's sign-in page and receives the result
+on a local port, so it must run on a machine with a browser. The device
+flow prints a code to enter on any device, for servers without one.
+
+Flags:
+`
+
+const whoamiUsage = `Usage: mcp-email-server whoami <account>
+
+Logs in to the account's IMAP and SMTP servers with the configured password
+or OAuth2 token and prints the authenticated identity.
+`
+
+// runAuth implements the auth subcommand
+func runAuth(args []string, configPath string, out io.Writer) error {
+	fs := flag.NewFlagSet("auth", flag.ContinueOnError)
+	flow := fs.String("flow", "auto", "Sign-in flow: browser, device, or auto for browser when the provider supports it")
+	port := fs.Int("port", 0, "Local port for the browser flow's redirect; 0 picks a free port")
+	noBrowser := fs.Bool("no-browser", false, "Print the sign-in URL without opening a browser")
+	timeout := fs.Duration("timeout", 10*time.Minute, "How long to wait for the sign-in to complete")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), authUsage)
+		fs.PrintDefaults()
+	}
+	name, err := parseAccountArg(fs, args)
+	if err != nil || name == "" {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	account, err := cfg.GetAccountByName(name)
+	if err != nil {
+		return err
+	}
+	if account.OAuth2 == nil {
+		return fmt.Errorf("account %s does not use OAuth2: add an oauth2 block or ACCOUNT_*_OAUTH2_* variables", name)
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	source := oauth.TokenSourceFor(account)
+	var token *oauth.Token
+	switch *flow {
+	case "auto":
+		if account.OAuth2.AuthURL != "" {
+			token, err = source.BrowserFlow(ctx, *port, account.IMAPUsername, !*noBrowser, out)
+		} else {
+			token, err = source.DeviceFlow(ctx, out)
+		}
+	case "browser":
+		token, err = source.BrowserFlow(ctx, *port, account.IMAPUsername, !*noBrowser, out)
+	case "device":
+		token, err = source.DeviceFlow(ctx, out)
+	default:
+		return fmt.Errorf("unknown flow %q: use browser, device or auto", *flow)
+	}
+	if err != nil {
+		return err
+	}
+	if token.RefreshToken == "" {
+		return fmt.Errorf("the provider issued no refresh token; check that offline access is allowed for the client")
+	}
+	if err := source.Store(token); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\nSaved the refresh token for %s in %s\n\n", name, source.Location())
+
+	return whoami(account, out)
+}
+
+// runWhoami implements the whoami subcommand
+func runWhoami(args []string, configPath string, out io.Writer) error {
+	fs := flag.NewFlagSet("whoami", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), whoamiUsage)
+	}
+	name, err := parseAccountArg(fs, args)
+	if err != nil || name == "" {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	account, err := cfg.GetAccountByName(name)
+	if err != nil {
+		return err
+	}
+	return whoami(account, out)
+}
+
+// parseAccountArg parses the flags and the account name, which may come
+// before or after them. An empty name with a nil error means help was shown.
+func parseAccountArg(fs *flag.FlagSet, args []string) (string, error) {
+	name := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return "", nil
+		}
+		return "", err
+	}
+	if fs.NArg() > 0 && name == "" {
+		name = fs.Arg(0)
+	}
+	if name == "" {
+		fs.Usage()
+		return "", fmt.Errorf("missing account name")
+	}
+	return name, nil
+}
+
+// whoami logs in to the account's IMAP and SMTP servers and prints who they
+// were logged in as
+func whoami(account *config.AccountConfig, out io.Writer) error {
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	logger.SetLevel(logrus.WarnLevel)
+
+	fmt.Fprintf(out, "Account: %s\n", account.Name)
+	if account.OAuth2 != nil {
+		source := oauth.TokenSourceFor(account)
+		token, err := source.Token(context.Background())
+		if err != nil {
+			return fmt.Errorf("OAuth2: %w", err)
+		}
+		identity := token.Email()
+		if identity == "" {
+			identity = "unknown (no openid scope)"
+		}
+		fmt.Fprintf(out, "OAuth2:  signed in as %s, tokens saved in %s\n", identity, source.Location())
+		if address := token.Email(); address != "" && !strings.EqualFold(address, account.IMAPUsername) {
+			fmt.Fprintf(out, "Warning: the token belongs to %s but the IMAP username is %s\n", address, account.IMAPUsername)
+		}
+	}
+
+	var failed error
+
+	imapClient, err := email.NewIMAPClient(account)
+	if err == nil {
+		imapClient.SetLogger(logger)
+		err = imapClient.Connect()
+	}
+	if err != nil {
+		fmt.Fprintf(out, "IMAP:    %s:%d: %v\n", account.IMAPHost, account.IMAPPort, err)
+		failed = errors.New("IMAP login failed")
+	} else {
+		fmt.Fprintf(out, "IMAP:    logged in to %s:%d (%s) as %s with %s\n", account.IMAPHost, account.IMAPPort, account.IMAPSecurity, account.IMAPUsername, imapClient.AuthMechanism())
+		imapClient.Close() //nolint:errcheck
+	}
+
+	smtpClient, err := email.NewSMTPClient(account)
+	var mechanism string
+	if err == nil {
+		smtpClient.SetLogger(logger)
+		mechanism, err = smtpClient.Verify()
+	}
+	switch {
+	case err != nil:
+		fmt.Fprintf(out, "SMTP:    %s:%d: %v\n", account.SMTPHost, account.SMTPPort, err)
+		failed = errors.Join(failed, errors.New("SMTP login failed"))
+	case mechanism == "":
+		fmt.Fprintf(out, "SMTP:    connected to %s:%d without authenticating (no password set)\n", account.SMTPHost, account.SMTPPort)
+	default:
+		fmt.Fprintf(out, "SMTP:    logged in to %s:%d (%s) as %s with %s\n", account.SMTPHost, account.SMTPPort, account.SMTPSecurity, account.SMTPUsername, mechanism)
+	}
+	return failed
+}
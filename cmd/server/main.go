@@ -19,6 +19,7 @@ import (
 var (
 	version     = "dev"
 	showVersion = flag.Bool("version", false, "Show version information")
+	configFile  = flag.String("config", os.Getenv("CONFIG_FILE"), "Path to a YAML or TOML config file; defaults to $CONFIG_FILE")
 )
 
 func main() {
@@ -28,13 +29,53 @@ func main() {
 		fmt.Printf("mcp-email-server version %s\n", version)
 		os.Exit(0)
 	}
+
+	// Subcommands
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "migrate":
+			if err := runMigrate(flag.Args()[1:], *configFile, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "rekey":
+			if err := runRekey(flag.Args()[1:], *configFile, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "rekey: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "secrets":
+			if err := runSecrets(flag.Args()[1:], *configFile, os.Stdin, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "secrets: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "auth":
+			if err := runAuth(flag.Args()[1:], *configFile, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "auth: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case "whoami":
+			if err := runWhoami(flag.Args()[1:], *configFile, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "whoami: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command: %s\n", flag.Arg(0))
+			os.Exit(2)
+		}
+	}
+
 	// Set up logging
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.JSONFormatter{})
 	logger.SetOutput(os.Stdout)
 
 	// Load configuration
-	cfg, err := config.LoadConfig()
+	cfg, err := config.LoadConfig(*configFile)
 	if err != nil {
 		logger.WithError(err).Fatal("Failed to load configuration")
 	}
@@ -54,7 +95,7 @@ func main() {
 	logger.Info("Starting MCP Email Server")
 
 	// Initialize cache
-	emailCache, err := cache.NewCache(cfg.CachePath, logger)
+	emailCache, err := cache.NewCache(cfg.CachePath, &cfg.Encryption, logger)
 	if err != nil {
 		logger.WithError(err).Fatal("Failed to initialize cache")
 	}
@@ -87,6 +128,9 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Enforce cache retention and compact the database in the background
+	go cache.NewRetentionJob(cacheStore, cfg, logger).Run(ctx)
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
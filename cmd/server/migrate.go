@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/internal/cache"
+	"github.com/brandon/mcp-email/internal/config"
+)
+
+const migrateUsage = `Usage: mcp-email-server migrate [status|up] [flags]
+
+Commands:
+  status    Show the cache schema version and pending migrations (default)
+  up        Apply pending migrations
+
+Flags:
+`
+
+// runMigrate implements the migrate subcommand
+func runMigrate(args []string, configPath string, out io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	cachePath := fs.String("cache-path", "", "Path to the SQLite cache; defaults to the configured cache path")
+	dryRun := fs.Bool("dry-run", false, "With up: list the migrations that would run without applying them")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), migrateUsage)
+		fs.PrintDefaults()
+	}
+
+	// Accept the command before or after the flags
+	command := "status"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() > 0 {
+		command = fs.Arg(0)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	logger.SetLevel(logrus.WarnLevel)
+
+	// Migrations open the cache with the server's keys so the search index
+	// is maintained the same way
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if *cachePath == "" {
+		*cachePath = cfg.CachePath
+	}
+
+	// A status check or dry run leaves the cache as it is: it is opened
+	// read-only, and not created if missing
+	readOnly := false
+	switch command {
+	case "status":
+		readOnly = true
+	case "up":
+		readOnly = *dryRun
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown migrate command: %s", command)
+	}
+
+	var c *cache.Cache
+	if readOnly {
+		if _, err := os.Stat(*cachePath); errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(out, "Cache: %s\nNo cache yet; the server creates it at schema version %d.\n", *cachePath, cache.LatestSchemaVersion())
+			return nil
+		}
+		c, err = cache.OpenCacheReadOnly(*cachePath, logger)
+	} else {
+		c, err = cache.OpenCache(*cachePath, &cfg.Encryption, logger)
+	}
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if command == "status" {
+		return migrateStatus(c, *cachePath, out)
+	}
+	return migrateUp(c, *dryRun, out)
+}
+
+// migrateStatus prints the schema version and every migration's state
+func migrateStatus(c *cache.Cache, path string, out io.Writer) error {
+	version, err := c.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Cache: %s\n", path)
+	fmt.Fprintf(out, "Schema version: %d (latest: %d)\n\n", version, cache.LatestSchemaVersion())
+	pending := 0
+	for _, m := range cache.Migrations() {
+		state := "applied"
+		if m.Version > version {
+			state = "pending"
+			pending++
+		}
+		fmt.Fprintf(out, "  %3d  %-8s %s%s\n", m.Version, state, m.Description, destructiveNote(m))
+	}
+
+	if version > cache.LatestSchemaVersion() {
+		fmt.Fprintf(out, "\nThe cache was written by a newer release; this build cannot use it.\n")
+	} else if pending > 0 {
+		fmt.Fprintf(out, "\n%d pending; run \"mcp-email-server migrate up\" or start the server to apply them.\n", pending)
+	}
+	return nil
+}
+
+// migrateUp applies pending migrations, or lists them for a dry run
+func migrateUp(c *cache.Cache, dryRun bool, out io.Writer) error {
+	applied, err := c.Migrate(dryRun)
+
+	verb := "Applied"
+	if dryRun {
+		verb = "Would apply"
+	}
+	for _, m := range applied {
+		fmt.Fprintf(out, "%s migration %d: %s%s\n", verb, m.Version, m.Description, destructiveNote(m))
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 {
+		fmt.Fprintf(out, "Schema is up to date (version %d)\n", cache.LatestSchemaVersion())
+	}
+	return nil
+}
+
+// destructiveNote flags migrations that back up the cache first
+func destructiveNote(m cache.Migration) string {
+	if m.Destructive {
+		return " (destructive, backs up the cache first)"
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/internal/cache"
+	"github.com/brandon/mcp-email/internal/config"
+)
+
+const rekeyUsage = `Usage: mcp-email-server rekey [flags]
+
+Re-encrypts cached email content with the first key in CACHE_ENCRYPTION_KEY
+(or CACHE_ENCRYPTION_KEY_FILE, or the config file), including content cached before encryption
+was enabled. To rotate keys, put the new key first and keep the old one after
+it, run rekey, then remove the old key. Stop the server first.
+
+Flags:
+`
+
+// runRekey implements the rekey subcommand
+func runRekey(args []string, configPath string, out io.Writer) error {
+	fs := flag.NewFlagSet("rekey", flag.ContinueOnError)
+	cachePath := fs.String("cache-path", "", "Path to the SQLite cache; defaults to the configured cache path")
+	decrypt := fs.Bool("decrypt", false, "Decrypt all cached content, turning encryption off")
+	generate := fs.Bool("generate-key", false, "Print a new random key and exit")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), rekeyUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if *generate {
+		key, err := cache.GenerateKey()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, key)
+		return nil
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if *cachePath == "" {
+		*cachePath = cfg.CachePath
+	}
+	encryption := &cfg.Encryption
+	if !encryption.Enabled() {
+		return fmt.Errorf("set CACHE_ENCRYPTION_KEY or CACHE_ENCRYPTION_KEY_FILE")
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	logger.SetLevel(logrus.WarnLevel)
+
+	c, err := cache.NewCache(*cachePath, encryption, logger)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	result, err := c.Rekey(*decrypt)
+	if result != nil {
+		action := "Re-encrypted"
+		if *decrypt {
+			action = "Decrypted"
+		}
+		fmt.Fprintf(out, "%s %d fields in %d emails\n", action, result.Fields, result.Emails)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *decrypt {
+		fmt.Fprintln(out, "The cache is no longer encrypted; remove the encryption keys from the configuration.")
+	} else {
+		fmt.Fprintf(out, "All cached content is encrypted with key %s; older keys can be removed.\n", c.Cipher().KeyID())
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/brandon/mcp-email/internal/config"
+)
+
+const secretsUsage = `Usage: mcp-email-server secrets <command> [name]
+
+Manages the encrypted secrets file named by SECRETS_FILE (or secrets.file in
+the config file), encrypted with SECRETS_KEY or SECRETS_KEY_FILE. Accounts
+refer to its entries with *_PASSWORD_SECRET or password_secret. Generate a
+key with: mcp-email-server rekey --generate-key
+
+Commands:
+  list           List the names of stored secrets
+  set <name>     Store a secret read from standard input, e.g.
+                 echo "$PASSWORD" | mcp-email-server secrets set work
+  delete <name>  Remove a secret
+  rekey          Re-encrypt the file with the first key, after a key rotation
+`
+
+// runSecrets implements the secrets subcommand
+func runSecrets(args []string, configPath string, in io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("secrets", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), secretsUsage)
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("missing secrets command")
+	}
+	command, rest := fs.Arg(0), fs.Args()[1:]
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if cfg.Secrets == nil {
+		return fmt.Errorf("set SECRETS_FILE and SECRETS_KEY or SECRETS_KEY_FILE")
+	}
+	file := cfg.Secrets
+
+	name := ""
+	switch command {
+	case "set", "delete":
+		if len(rest) != 1 || rest[0] == "" {
+			fs.Usage()
+			return fmt.Errorf("%s needs a secret name", command)
+		}
+		name = rest[0]
+	case "list", "rekey":
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown secrets command: %s", command)
+	}
+
+	secrets, err := file.Load()
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "list":
+		names := make([]string, 0, len(secrets))
+		for name := range secrets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(out, name)
+		}
+		return nil
+
+	case "set":
+		if f, ok := in.(*os.File); ok {
+			if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+				fmt.Fprintln(os.Stderr, "Enter the secret, then press Ctrl-D:")
+			}
+		}
+		data, err := io.ReadAll(in)
+		if err != nil {
+			return fmt.Errorf("failed to read secret: %w", err)
+		}
+		value := strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+		if value == "" {
+			return fmt.Errorf("empty secret")
+		}
+		secrets[name] = value
+		if err := file.Save(secrets); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Stored secret %s in %s\n", name, file.Path)
+
+	case "delete":
+		if _, ok := secrets[name]; !ok {
+			return fmt.Errorf("%w: %s", config.ErrSecretNotFound, name)
+		}
+		delete(secrets, name)
+		if err := file.Save(secrets); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Deleted secret %s from %s\n", name, file.Path)
+
+	case "rekey":
+		if err := file.Save(secrets); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Re-encrypted %d secrets in %s with the first key; older keys can be removed.\n", len(secrets), file.Path)
+	}
+	return nil
+}
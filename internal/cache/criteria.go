@@ -0,0 +1,233 @@
+package cache
+
+import (
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// IMAPCriteria translates the search options into IMAP SEARCH criteria.
+// Terms IMAP cannot evaluate (attachments, folders, accounts) are left out,
+// so the server may match more than the cache would; callers are expected to
+// apply the search options to the fetched messages as well. Dates refer to
+// the Date header, as they do in the cache.
+func (opts *SearchOptions) IMAPCriteria() *imap.SearchCriteria {
+	criteria := imap.NewSearchCriteria()
+
+	if opts.Sender != nil {
+		criteria.Header.Add("From", *opts.Sender)
+	}
+
+	// Cached recipients cover To, Cc and Bcc
+	if opts.Recipient != nil {
+		criteria.Or = append(criteria.Or, recipientCriteria(*opts.Recipient))
+	}
+
+	if opts.Subject != nil {
+		criteria.Header.Add("Subject", *opts.Subject)
+	}
+
+	if opts.Body != nil {
+		criteria.Body = append(criteria.Body, strings.Fields(*opts.Body)...)
+	}
+
+	if opts.DateFrom != nil {
+		criteria.SentSince = *opts.DateFrom
+	}
+
+	if opts.DateTo != nil {
+		criteria.SentBefore = sentBefore(opts.DateTo.Add(time.Nanosecond))
+	}
+
+	if opts.Query != nil && opts.Query.root != nil {
+		t := &criteriaTranslator{compiler: &queryCompiler{now: opts.Query.now}}
+		if c, _ := t.translate(opts.Query.root); c != nil {
+			mergeCriteria(criteria, c)
+		}
+	}
+
+	return criteria
+}
+
+// criteriaTranslator turns a query syntax tree into IMAP SEARCH criteria
+type criteriaTranslator struct {
+	compiler *queryCompiler
+}
+
+// translate returns criteria matching at least the messages node matches;
+// nil means "everything". exact reports whether the criteria match exactly
+// those messages, which is required below a negation.
+func (t *criteriaTranslator) translate(node queryNode) (*imap.SearchCriteria, bool) {
+	switch n := node.(type) {
+	case *andNode:
+		criteria := imap.NewSearchCriteria()
+		exact := true
+		for _, child := range n.children {
+			c, childExact := t.translate(child)
+			exact = exact && childExact
+			if c != nil {
+				mergeCriteria(criteria, c)
+			}
+		}
+		return criteria, exact
+
+	case *orNode:
+		var result *imap.SearchCriteria
+		exact := true
+		for _, child := range n.children {
+			c, childExact := t.translate(child)
+			if c == nil {
+				// One side matches everything, so the whole OR does
+				return nil, false
+			}
+			exact = exact && childExact
+			if result == nil {
+				result = c
+				continue
+			}
+			combined := imap.NewSearchCriteria()
+			combined.Or = append(combined.Or, [2]*imap.SearchCriteria{result, c})
+			result = combined
+		}
+		return result, exact
+
+	case *notNode:
+		c, exact := t.translate(n.child)
+		if c == nil || !exact {
+			return nil, false
+		}
+		criteria := imap.NewSearchCriteria()
+		criteria.Not = append(criteria.Not, c)
+		return criteria, true
+
+	case *termNode:
+		return t.term(n)
+	}
+
+	return nil, false
+}
+
+// term translates a single operator term
+func (t *criteriaTranslator) term(n *termNode) (*imap.SearchCriteria, bool) {
+	criteria := imap.NewSearchCriteria()
+	value := n.value
+
+	// IMAP matches header substrings, which may be looser than the cache
+	if h, ok := headerColumns[queryFields[n.field]]; ok {
+		criteria.Header.Add(h.header, value)
+		return criteria, false
+	}
+
+	switch queryFields[n.field] {
+	case "":
+		criteria.Text = append(criteria.Text, value)
+		// IMAP matches substrings, full-text search matches words
+		return criteria, false
+
+	case "from":
+		criteria.Header.Add("From", value)
+
+	case "to":
+		// The cache does not tell To, Cc and Bcc apart, so neither do
+		// to:, cc: and bcc:
+		criteria.Or = append(criteria.Or, recipientCriteria(value))
+
+	case "subject":
+		criteria.Header.Add("Subject", value)
+		return criteria, false
+
+	case "body":
+		criteria.Body = append(criteria.Body, value)
+		return criteria, false
+
+	case "is":
+		cond, ok := flagConditions[strings.ToLower(value)]
+		if !ok {
+			return nil, false
+		}
+		if cond.present {
+			criteria.WithFlags = append(criteria.WithFlags, cond.flag)
+		} else {
+			criteria.WithoutFlags = append(criteria.WithoutFlags, cond.flag)
+		}
+
+	case "after", "newer_than":
+		date, err := t.date(n)
+		if err != nil {
+			return nil, false
+		}
+		criteria.SentSince = date
+		return criteria, false
+
+	case "before", "older_than":
+		date, err := t.date(n)
+		if err != nil {
+			return nil, false
+		}
+		criteria.SentBefore = sentBefore(date)
+		return criteria, false
+
+	default:
+		// has:, in: and account: have no IMAP equivalent
+		return nil, false
+	}
+
+	return criteria, true
+}
+
+// date resolves the date of a date operator term
+func (t *criteriaTranslator) date(n *termNode) (time.Time, error) {
+	switch queryFields[n.field] {
+	case "older_than", "newer_than":
+		return t.compiler.relativeDate(n.value)
+	}
+	return parseQueryDate(n.value)
+}
+
+// recipientCriteria matches value in any of To, Cc or Bcc
+func recipientCriteria(value string) [2]*imap.SearchCriteria {
+	to := imap.NewSearchCriteria()
+	to.Header.Add("To", value)
+	cc := imap.NewSearchCriteria()
+	cc.Header.Add("Cc", value)
+	bcc := imap.NewSearchCriteria()
+	bcc.Header.Add("Bcc", value)
+
+	ccOrBcc := imap.NewSearchCriteria()
+	ccOrBcc.Or = append(ccOrBcc.Or, [2]*imap.SearchCriteria{cc, bcc})
+	return [2]*imap.SearchCriteria{to, ccOrBcc}
+}
+
+// mergeCriteria ANDs src into dst. IMAP dates have day granularity, so the
+// later SENTSINCE and the earlier SENTBEFORE win.
+func mergeCriteria(dst, src *imap.SearchCriteria) {
+	for key, values := range src.Header {
+		for _, value := range values {
+			dst.Header.Add(key, value)
+		}
+	}
+	dst.Body = append(dst.Body, src.Body...)
+	dst.Text = append(dst.Text, src.Text...)
+	dst.WithFlags = append(dst.WithFlags, src.WithFlags...)
+	dst.WithoutFlags = append(dst.WithoutFlags, src.WithoutFlags...)
+	dst.Not = append(dst.Not, src.Not...)
+	dst.Or = append(dst.Or, src.Or...)
+
+	if !src.SentSince.IsZero() && src.SentSince.After(dst.SentSince) {
+		dst.SentSince = src.SentSince
+	}
+	if !src.SentBefore.IsZero() && (dst.SentBefore.IsZero() || src.SentBefore.Before(dst.SentBefore)) {
+		dst.SentBefore = src.SentBefore
+	}
+}
+
+// sentBefore returns the SENTBEFORE date excluding t but nothing earlier.
+// BEFORE compares whole days, so any time past midnight needs the next day.
+func sentBefore(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if day.Equal(t) {
+		return day
+	}
+	return day.AddDate(0, 0, 1)
+}
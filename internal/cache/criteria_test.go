@@ -0,0 +1,34 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIMAPCriteria(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`from:alice`, `[FROM alice]`},
+		{`to:bob`, `[OR [TO bob] [OR [CC bob] [BCC bob]]]`},
+		{`cc:bob`, `[OR [TO bob] [OR [CC bob] [BCC bob]]]`},
+		{`-bcc:bob`, `[NOT [OR [TO bob] [OR [CC bob] [BCC bob]]]]`},
+		{`body:invoice is:unread`, `[BODY invoice UNSEEN]`},
+		// Inexact terms are left out below a negation
+		{`-subject:invoice`, `[ALL]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseQuery() error = %v", err)
+			}
+			opts := &SearchOptions{Query: q}
+			if got := fmt.Sprint(opts.IMAPCriteria().Format()); got != tt.want {
+				t.Errorf("IMAPCriteria() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
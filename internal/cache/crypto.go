@@ -0,0 +1,469 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"modernc.org/sqlite"
+
+	"github.com/brandon/mcp-email/internal/config"
+)
+
+// encryptedPrefix starts every encrypted field value, followed by the key ID
+// and the base64 nonce and ciphertext: enc:v1:<key id>:<data>
+const encryptedPrefix = "enc:v1:"
+
+// Encrypted columns of the emails table. Each is also the additional data
+// sealed with its values, so a value cannot be moved to another column.
+const (
+	columnBodyText = "body_text"
+	columnBodyHTML = "body_html"
+	columnHeaders  = "headers"
+)
+
+// encryptedColumns lists the columns holding message content
+var encryptedColumns = []string{columnBodyText, columnBodyHTML, columnHeaders}
+
+// Cache settings recording how the stored data was written
+const (
+	settingKeyIDs    = "encryption_key_ids"
+	settingIndexText = "fts_index_text"
+)
+
+// ErrNoKey is returned when encrypted data is read without its key
+var ErrNoKey = errors.New("cache is encrypted with a key that is not configured")
+
+// Cipher encrypts cache fields with AES-256-GCM. The first key encrypts;
+// every key decrypts, so keys can be rotated without rewriting the cache
+// at once.
+type Cipher struct {
+	keys []cipherKey
+}
+
+// cipherKey is a key and the ID stored with the values it encrypts
+type cipherKey struct {
+	id       string
+	aead     cipher.AEAD
+	nonceKey []byte
+}
+
+// NewCipher creates a cipher from 256-bit keys, newest first
+func NewCipher(keys [][]byte) (*Cipher, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no encryption keys")
+	}
+
+	c := &Cipher{}
+	seen := make(map[string]bool)
+	for i, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %d must be 32 bytes", i+1)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %d: %w", i+1, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %d: %w", i+1, err)
+		}
+		id := KeyID(key)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte("nonce"))
+		c.keys = append(c.keys, cipherKey{id: id, aead: aead, nonceKey: mac.Sum(nil)})
+	}
+	return c, nil
+}
+
+// KeyID identifies a key without revealing it
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// GenerateKey returns a new random key, base64 encoded
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// KeyID returns the ID of the key new data is encrypted with
+func (c *Cipher) KeyID() string {
+	return c.keys[0].id
+}
+
+// hasKey reports whether the cipher holds the key with the given ID
+func (c *Cipher) hasKey(id string) bool {
+	return c.key(id) != nil
+}
+
+// key returns the key with the given ID, or nil
+func (c *Cipher) key(id string) *cipherKey {
+	for i := range c.keys {
+		if c.keys[i].id == id {
+			return &c.keys[i]
+		}
+	}
+	return nil
+}
+
+// Encrypt seals a column value with the current key. Empty values are
+// stored as they are. The nonce is derived from the value, so re-syncing an
+// unchanged message writes identical ciphertext and the row is skipped by
+// the full-text index; equal values are the only thing this reveals.
+func (c *Cipher) Encrypt(column, plaintext string) string {
+	if plaintext == "" {
+		return ""
+	}
+
+	key := &c.keys[0]
+	mac := hmac.New(sha256.New, key.nonceKey)
+	mac.Write([]byte(column))
+	mac.Write([]byte{0})
+	mac.Write([]byte(plaintext))
+	nonce := mac.Sum(nil)[:key.aead.NonceSize()]
+	sealed := key.aead.Seal(append([]byte(nil), nonce...), nonce, []byte(plaintext), []byte(column))
+	return encryptedPrefix + key.id + ":" + base64.StdEncoding.EncodeToString(sealed)
+}
+
+// Decrypt opens a column value sealed by Encrypt. Values that are not
+// encrypted are returned as they are.
+func (c *Cipher) Decrypt(column, value string) (string, error) {
+	id, data, ok := splitEncrypted(value)
+	if !ok {
+		return value, nil
+	}
+
+	var key *cipherKey
+	if c != nil {
+		key = c.key(id)
+	}
+	if key == nil {
+		return "", fmt.Errorf("%w (key ID %s)", ErrNoKey, id)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < key.aead.NonceSize() {
+		return "", fmt.Errorf("corrupt encrypted %s", column)
+	}
+	nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+	plaintext, err := key.aead.Open(nil, nonce, ciphertext, []byte(column))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %w", column, err)
+	}
+	return string(plaintext), nil
+}
+
+// seal encrypts a column value when c is not nil
+func (c *Cipher) seal(column, value string) string {
+	if c == nil {
+		return value
+	}
+	return c.Encrypt(column, value)
+}
+
+// splitEncrypted splits an encrypted value into its key ID and data
+func splitEncrypted(value string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return "", "", false
+	}
+	id, data, ok := strings.Cut(rest, ":")
+	return id, data, ok
+}
+
+// searchCipher decrypts body text for the full-text index when indexing
+// decrypted text is enabled; nil otherwise. SQL functions are registered
+// process-wide, so this is shared by every cache the process opens.
+var searchCipher atomic.Pointer[Cipher]
+
+func init() {
+	// The full-text index reads body text through cache_search_text, so
+	// encrypted bodies are indexed only when the operator opted in
+	sqlite.MustRegisterScalarFunction("cache_search_text", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		value, ok := args[0].(string)
+		if !ok {
+			return args[0], nil
+		}
+		if _, _, encrypted := splitEncrypted(value); !encrypted {
+			return value, nil
+		}
+		c := searchCipher.Load()
+		if c == nil {
+			return "", nil
+		}
+		return c.Decrypt(columnBodyText, value)
+	})
+}
+
+// configureEncryption sets up the cipher for the cache and checks that the
+// stored data can be read with it
+func (c *Cache) configureEncryption(cfg *config.EncryptionConfig) error {
+	searchCipher.Store(nil)
+	if cfg != nil && cfg.Enabled() {
+		var err error
+		if c.cipher, err = NewCipher(cfg.Keys); err != nil {
+			return err
+		}
+		if cfg.IndexText {
+			searchCipher.Store(c.cipher)
+		}
+	}
+	return c.checkEncryption()
+}
+
+// checkEncryption checks that the keys of the stored data are configured
+// and records the current key, before anything is encrypted with it. The
+// full-text index is rebuilt when the choice to index decrypted text
+// changed since it was built, before anything writes to it. It runs when
+// the cache is opened and again after migrations, as caches older than the
+// settings table get it from a migration.
+func (c *Cache) checkEncryption() error {
+	// Caches older than the settings table hold no encrypted data, and
+	// the migration creating it records how the index is built
+	exists, err := c.hasTable("cache_settings")
+	if err != nil || !exists {
+		return err
+	}
+
+	keyIDs, err := c.encryptionKeyIDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range keyIDs {
+		if c.cipher == nil {
+			return fmt.Errorf("the cache is encrypted; set CACHE_ENCRYPTION_KEY or CACHE_ENCRYPTION_KEY_FILE")
+		}
+		if !c.cipher.hasKey(id) {
+			return fmt.Errorf("%w (key ID %s); keep it after the current key until \"mcp-email-server rekey\" has run", ErrNoKey, id)
+		}
+	}
+	if c.cipher != nil {
+		if err := c.setEncryptionKeyIDs(append(keyIDs, c.cipher.KeyID())); err != nil {
+			return err
+		}
+	}
+
+	indexed, err := c.setting(settingIndexText)
+	if err != nil {
+		return err
+	}
+	if want := indexTextSetting(); indexed != want {
+		c.logger.WithField("index_text", want).Info("Rebuilding full-text index")
+		if _, err := c.db.Exec("INSERT INTO emails_fts(emails_fts) VALUES ('rebuild')"); err != nil {
+			return fmt.Errorf("failed to rebuild full-text index: %w", err)
+		}
+		return c.setSetting(settingIndexText, want)
+	}
+	return nil
+}
+
+// indexTextSetting returns the settingIndexText value for the current
+// index mode
+func indexTextSetting() string {
+	return fmt.Sprint(searchCipher.Load() != nil)
+}
+
+// Cipher returns the cipher encrypting cached content, or nil when the
+// cache is not encrypted
+func (c *Cache) Cipher() *Cipher {
+	return c.cipher
+}
+
+// hasTable reports whether the database has the named table
+func (c *Cache) hasTable(name string) (bool, error) {
+	var count int
+	err := c.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect schema: %w", err)
+	}
+	return count > 0, nil
+}
+
+// setting returns a cache setting, or "" when unset
+func (c *Cache) setting(key string) (string, error) {
+	var value string
+	err := c.db.QueryRow("SELECT value FROM cache_settings WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read cache setting %s: %w", key, err)
+	}
+	return value, nil
+}
+
+// setSetting stores a cache setting
+func (c *Cache) setSetting(key, value string) error {
+	_, err := c.db.Exec(`INSERT INTO cache_settings (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value`, key, value)
+	if err != nil {
+		return fmt.Errorf("failed to store cache setting %s: %w", key, err)
+	}
+	return nil
+}
+
+// encryptionKeyIDs returns the IDs of the keys that may have encrypted
+// stored data
+func (c *Cache) encryptionKeyIDs() ([]string, error) {
+	value, err := c.setting(settingKeyIDs)
+	if err != nil || value == "" {
+		return nil, err
+	}
+	return strings.Split(value, ","), nil
+}
+
+// setEncryptionKeyIDs records the keys that may have encrypted stored data
+func (c *Cache) setEncryptionKeyIDs(ids []string) error {
+	unique := make(map[string]bool)
+	var sorted []string
+	for _, id := range ids {
+		if id != "" && !unique[id] {
+			unique[id] = true
+			sorted = append(sorted, id)
+		}
+	}
+	sort.Strings(sorted)
+	return c.setSetting(settingKeyIDs, strings.Join(sorted, ","))
+}
+
+// rekeyBatchSize is the number of emails rewritten per transaction by Rekey
+const rekeyBatchSize = 500
+
+// RekeyResult reports what Rekey rewrote
+type RekeyResult struct {
+	Emails int
+	Fields int
+}
+
+// Rekey rewrites every encrypted column with the current key, encrypting
+// plaintext left from before encryption was enabled, or decrypts everything
+// when decrypt is set. The database is vacuumed afterwards so the old
+// values do not linger in free pages.
+func (c *Cache) Rekey(decrypt bool) (*RekeyResult, error) {
+	if c.cipher == nil {
+		return nil, fmt.Errorf("no encryption key configured")
+	}
+
+	result := &RekeyResult{}
+	var lastID int64
+	for {
+		n, next, err := c.rekeyBatch(lastID, decrypt, result)
+		if err != nil {
+			return result, err
+		}
+		if n == 0 {
+			break
+		}
+		lastID = next
+	}
+
+	ids := []string{c.cipher.KeyID()}
+	if decrypt {
+		ids = nil
+	}
+	if err := c.setEncryptionKeyIDs(ids); err != nil {
+		return result, err
+	}
+
+	if _, err := c.db.Exec("VACUUM"); err != nil {
+		return result, fmt.Errorf("failed to vacuum cache: %w", err)
+	}
+	if _, err := c.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return result, fmt.Errorf("failed to checkpoint cache: %w", err)
+	}
+	return result, nil
+}
+
+// rekeyBatch rewrites the emails after lastID, up to rekeyBatchSize, in one
+// transaction. It returns the number of emails read and the last ID.
+func (c *Cache) rekeyBatch(lastID int64, decrypt bool, result *RekeyResult) (int, int64, error) {
+	type row struct {
+		id     int64
+		values []string
+	}
+
+	rows, err := c.db.Query(`SELECT id, COALESCE(body_text, ''), COALESCE(body_html, ''), COALESCE(headers, '')
+		FROM emails WHERE id > ? ORDER BY id LIMIT ?`, lastID, rekeyBatchSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read emails: %w", err)
+	}
+	var batch []row
+	for rows.Next() {
+		r := row{values: make([]string, len(encryptedColumns))}
+		if err := rows.Scan(&r.id, &r.values[0], &r.values[1], &r.values[2]); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("failed to read email: %w", err)
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("failed to read emails: %w", err)
+	}
+	if len(batch) == 0 {
+		return 0, lastID, nil
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, r := range batch {
+		changed := false
+		for i, column := range encryptedColumns {
+			value := r.values[i]
+			id, _, encrypted := splitEncrypted(value)
+			if value == "" || (decrypt && !encrypted) || (!decrypt && encrypted && id == c.cipher.KeyID()) {
+				continue
+			}
+
+			plaintext, err := c.cipher.Decrypt(column, value)
+			if err != nil {
+				return 0, 0, fmt.Errorf("email %d: %w", r.id, err)
+			}
+			if !decrypt {
+				plaintext = c.cipher.Encrypt(column, plaintext)
+			}
+			r.values[i] = plaintext
+			changed = true
+			result.Fields++
+		}
+		if !changed {
+			continue
+		}
+
+		_, err := tx.Exec("UPDATE emails SET body_text = ?, body_html = ?, headers = ? WHERE id = ?",
+			r.values[0], r.values[1], r.values[2], r.id)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to rewrite email %d: %w", r.id, err)
+		}
+		result.Emails++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit rewritten emails: %w", err)
+	}
+	return len(batch), batch[len(batch)-1].id, nil
+}
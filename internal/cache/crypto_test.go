@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/internal/config"
+)
+
+func TestEncryptionKeyChecked(t *testing.T) {
+	keyA := bytes.Repeat([]byte{'a'}, 32)
+	keyB := bytes.Repeat([]byte{'b'}, 32)
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	tests := []struct {
+		name  string
+		keys  [][]byte
+		noKey bool
+		want  string
+	}{
+		{name: "same key", keys: [][]byte{keyA}},
+		{name: "rotated key", keys: [][]byte{keyB, keyA}},
+		{name: "wrong key", keys: [][]byte{keyB}, noKey: true, want: "key ID " + KeyID(keyA)},
+		{name: "no key", want: "the cache is encrypted; set CACHE_ENCRYPTION_KEY"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A fresh cache records its key when it is created
+			path := filepath.Join(t.TempDir(), "cache.db")
+			c, err := NewCache(path, &config.EncryptionConfig{Keys: [][]byte{keyA}}, logger)
+			if err != nil {
+				t.Fatalf("NewCache() error = %v", err)
+			}
+			c.Close()
+
+			c, err = NewCache(path, &config.EncryptionConfig{Keys: tt.keys}, logger)
+			if err == nil {
+				c.Close()
+			}
+			switch {
+			case tt.want == "":
+				if err != nil {
+					t.Errorf("reopening error = %v", err)
+				}
+			case err == nil || !strings.Contains(err.Error(), tt.want):
+				t.Errorf("reopening error = %v, want %q", err, tt.want)
+			case errors.Is(err, ErrNoKey) != tt.noKey:
+				t.Errorf("reopening error = %v, ErrNoKey %v", err, tt.noKey)
+			}
+		})
+	}
+}
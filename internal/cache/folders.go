@@ -0,0 +1,391 @@
+package cache
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brandon/mcp-email/pkg/types"
+)
+
+// folderColumns is the column list scanned by scanFolder
+const folderColumns = `f.id, f.account_id, a.name, f.name, f.path, f.parent_path, f.delimiter, f.attributes,
+	f.special_use, f.message_count, f.unseen_count, f.recent_count, f.last_synced`
+
+// UpsertFolder upserts a folder and its LIST/STATUS metadata in the cache
+func (s *Store) UpsertFolder(folder *types.Folder) (int, error) {
+	attributesJSON, err := json.Marshal(folder.Attributes)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal attributes: %w", err)
+	}
+	if folder.Attributes == nil {
+		attributesJSON = []byte("[]")
+	}
+
+	query := `
+		INSERT INTO folders (account_id, name, path, parent_path, delimiter, attributes, special_use,
+			message_count, unseen_count, recent_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(account_id, path) DO UPDATE SET
+			name = excluded.name,
+			parent_path = excluded.parent_path,
+			delimiter = excluded.delimiter,
+			attributes = excluded.attributes,
+			special_use = excluded.special_use,
+			message_count = excluded.message_count,
+			unseen_count = excluded.unseen_count,
+			recent_count = excluded.recent_count
+	`
+	_, err = s.cache.DB().Exec(query,
+		folder.AccountID,
+		folder.Name,
+		folder.Path,
+		folder.ParentPath,
+		folder.Delimiter,
+		string(attributesJSON),
+		folder.SpecialUse,
+		folder.MessageCount,
+		folder.UnseenCount,
+		folder.RecentCount,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to upsert folder: %w", err)
+	}
+
+	// LastInsertId is not reliable for the update path of an upsert
+	return s.GetFolderID(folder.AccountID, folder.Path)
+}
+
+// MarkFolderSynced records the time a folder's messages were last synced
+func (s *Store) MarkFolderSynced(folderID int) error {
+	if _, err := s.cache.DB().Exec("UPDATE folders SET last_synced = CURRENT_TIMESTAMP WHERE id = ?", folderID); err != nil {
+		return fmt.Errorf("failed to mark folder synced: %w", err)
+	}
+	return nil
+}
+
+// GetFolderID returns the folder ID by account and path
+func (s *Store) GetFolderID(accountID int, path string) (int, error) {
+	var id int
+	err := s.cache.Reader().QueryRow("SELECT id FROM folders WHERE account_id = ? AND path = ?", accountID, path).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("folder not found: %s", path)
+	}
+	return id, nil
+}
+
+// GetFolderByRole returns the cached folder with the given special-use role
+// (see types.FolderRoleInbox etc.), or nil if the account has none
+func (s *Store) GetFolderByRole(accountID int, role string) (*types.Folder, error) {
+	query := `
+		SELECT ` + folderColumns + `
+		FROM folders f
+		JOIN accounts a ON f.account_id = a.id
+		WHERE f.account_id = ? AND f.special_use = ?
+		ORDER BY f.path
+		LIMIT 1
+	`
+	folder, err := scanFolder(s.cache.Reader().QueryRow(query, accountID, role))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folder by role: %w", err)
+	}
+	return folder, nil
+}
+
+// PruneFolders removes cached folders of an account that are not in keep,
+// together with their emails
+func (s *Store) PruneFolders(accountID int, keep []string) error {
+	keepSet := make(map[string]bool, len(keep))
+	for _, path := range keep {
+		keepSet[path] = true
+	}
+
+	folders, err := s.ListFolders(&accountID)
+	if err != nil {
+		return err
+	}
+
+	for i := range folders {
+		if keepSet[folders[i].Path] {
+			continue
+		}
+		if err := s.DeleteFolder(accountID, folders[i].Path); err != nil {
+			return err
+		}
+		s.logger.WithField("folder", folders[i].Path).Debug("Pruned folder no longer on server")
+	}
+
+	return nil
+}
+
+// EnsureFolder records a folder without marking it as synced and returns its ID
+func (s *Store) EnsureFolder(accountID int, path, delimiter string) (int, error) {
+	parent, name := types.SplitFolderPath(path, delimiter)
+	query := `
+		INSERT INTO folders (account_id, name, path, parent_path, delimiter)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(account_id, path) DO NOTHING
+	`
+	if _, err := s.cache.DB().Exec(query, accountID, name, path, parent, delimiter); err != nil {
+		return 0, fmt.Errorf("failed to insert folder: %w", err)
+	}
+	return s.GetFolderID(accountID, path)
+}
+
+// RenameFolder renames a cached folder and, when the server has a hierarchy
+// delimiter, every cached folder below it
+func (s *Store) RenameFolder(accountID int, oldPath, newPath, delimiter string) error {
+	tx, err := s.cache.DB().Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	// The server accepted the rename, so anything cached under the new name is stale
+	if err := deleteFolderTree(tx, accountID, newPath, delimiter); err != nil {
+		return err
+	}
+
+	parent, name := types.SplitFolderPath(newPath, delimiter)
+	_, err = tx.Exec("UPDATE folders SET path = ?, name = ?, parent_path = ? WHERE account_id = ? AND path = ?",
+		newPath, name, parent, accountID, oldPath)
+	if err != nil {
+		return fmt.Errorf("failed to rename folder: %w", err)
+	}
+
+	if delimiter != "" {
+		oldPrefix := oldPath + delimiter
+		newPrefix := newPath + delimiter
+		_, err = tx.Exec(`
+			UPDATE folders SET
+				path = ? || substr(path, length(?) + 1),
+				parent_path = CASE
+					WHEN parent_path = ? THEN ?
+					ELSE ? || substr(parent_path, length(?) + 1)
+				END
+			WHERE account_id = ? AND substr(path, 1, length(?)) = ?
+		`, newPrefix, oldPrefix, oldPath, newPath, newPrefix, oldPrefix, accountID, oldPrefix, oldPrefix)
+		if err != nil {
+			return fmt.Errorf("failed to rename child folders: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteFolder removes a cached folder and its emails
+func (s *Store) DeleteFolder(accountID int, path string) error {
+	tx, err := s.cache.DB().Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if err := deleteFolderTree(tx, accountID, path, ""); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteFolderTree deletes a folder, its emails and, if delimiter is set, all
+// of its descendants. Email locations are deleted explicitly so emails left
+// in no folder are deleted, and the FTS triggers fire, even on connections
+// where foreign keys are not enforced.
+func deleteFolderTree(tx *sql.Tx, accountID int, path, delimiter string) error {
+	match := "path = ?"
+	args := []interface{}{accountID, path}
+	if delimiter != "" {
+		match = "(path = ? OR substr(path, 1, length(?)) = ?)"
+		args = append(args, path+delimiter, path+delimiter)
+	}
+
+	_, err := tx.Exec(`
+		DELETE FROM email_locations WHERE folder_id IN (
+			SELECT id FROM folders WHERE account_id = ? AND `+match+`
+		)`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to delete folder emails: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM folders WHERE account_id = ? AND "+match, args...); err != nil {
+		return fmt.Errorf("failed to delete folder: %w", err)
+	}
+
+	return nil
+}
+
+// ListFolders lists folders for an account
+func (s *Store) ListFolders(accountID *int) ([]types.Folder, error) {
+	var conditions []string
+	var args []interface{}
+
+	if accountID != nil {
+		conditions = append(conditions, "f.account_id = ?")
+		args = append(args, *accountID)
+	}
+
+	whereClause := ""
+	if len(conditions) > 0 {
+		whereClause = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := `
+		SELECT ` + folderColumns + `
+		FROM folders f
+		JOIN accounts a ON f.account_id = a.id
+		` + whereClause + `
+		ORDER BY a.name, f.path
+	`
+
+	rows, err := s.cache.Reader().Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query folders: %w", err)
+	}
+	defer rows.Close()
+
+	var folders []types.Folder
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan folder: %w", err)
+		}
+		folders = append(folders, *folder)
+	}
+
+	return folders, rows.Err()
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanFolder scans a row selected with folderColumns
+func scanFolder(row rowScanner) (*types.Folder, error) {
+	var folder types.Folder
+	var attributesJSON string
+	var lastSynced sql.NullString
+
+	err := row.Scan(
+		&folder.ID,
+		&folder.AccountID,
+		&folder.AccountName,
+		&folder.Name,
+		&folder.Path,
+		&folder.ParentPath,
+		&folder.Delimiter,
+		&attributesJSON,
+		&folder.SpecialUse,
+		&folder.MessageCount,
+		&folder.UnseenCount,
+		&folder.RecentCount,
+		&lastSynced,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(attributesJSON), &folder.Attributes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal attributes: %w", err)
+	}
+
+	// CURRENT_TIMESTAMP is stored as "2006-01-02 15:04:05" in UTC
+	if lastSynced.Valid {
+		t, err := time.Parse("2006-01-02 15:04:05", lastSynced.String)
+		if err != nil {
+			t, err = time.Parse(time.RFC3339, lastSynced.String)
+		}
+		if err == nil {
+			folder.LastSynced = &t
+		}
+	}
+
+	return &folder, nil
+}
+
+// folderRoleAliases maps alternative role names accepted in folder filters
+// to the roles stored in folders.special_use
+var folderRoleAliases = map[string]string{
+	types.FolderRoleInbox:   types.FolderRoleInbox,
+	types.FolderRoleSent:    types.FolderRoleSent,
+	types.FolderRoleDrafts:  types.FolderRoleDrafts,
+	types.FolderRoleTrash:   types.FolderRoleTrash,
+	types.FolderRoleJunk:    types.FolderRoleJunk,
+	types.FolderRoleArchive: types.FolderRoleArchive,
+	types.FolderRoleAll:     types.FolderRoleAll,
+	"spam":                  types.FolderRoleJunk,
+	"deleted":               types.FolderRoleTrash,
+	"bin":                   types.FolderRoleTrash,
+	"draft":                 types.FolderRoleDrafts,
+	"all_mail":              types.FolderRoleAll,
+}
+
+// ResolveFolderIDs maps folder paths or special-use roles (e.g. "sent",
+// "spam") to cached folder IDs. With a nil accountID every account is
+// searched. An error is returned for any name that matches no folder.
+func (s *Store) ResolveFolderIDs(accountID *int, names []string) ([]int, error) {
+	var ids []int
+	seen := make(map[int]bool)
+
+	for _, name := range names {
+		conditions := []string{"path = ?"}
+		args := []interface{}{name}
+
+		if strings.EqualFold(name, "INBOX") {
+			conditions = append(conditions, "upper(path) = 'INBOX'")
+		}
+		if role, ok := folderRoleAliases[strings.ToLower(name)]; ok {
+			conditions = append(conditions, "special_use = ?")
+			args = append(args, role)
+		}
+
+		query := "SELECT id FROM folders WHERE (" + strings.Join(conditions, " OR ") + ")"
+		if accountID != nil {
+			query += " AND account_id = ?"
+			args = append(args, *accountID)
+		}
+
+		matched, err := s.queryIDs(query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve folder %s: %w", name, err)
+		}
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("folder not found: %s", name)
+		}
+
+		for _, id := range matched {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+// queryIDs runs a query returning a single integer column
+func (s *Store) queryIDs(query string, args ...interface{}) ([]int, error) {
+	rows, err := s.cache.Reader().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
@@ -0,0 +1,526 @@
+package cache
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/brandon/mcp-email/pkg/types"
+)
+
+// Migration is a versioned change to the cache schema. Each migration runs
+// in its own transaction together with the PRAGMA user_version update that
+// records it, so a failed migration leaves the database untouched.
+type Migration struct {
+	Version     int
+	Description string
+	// Destructive migrations drop or rewrite cached data; the database file
+	// is backed up before they run
+	Destructive bool
+	Up          func(tx *sql.Tx) error
+}
+
+// migrations lists every schema change in order. Versions must be
+// consecutive and released migrations must never change.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "Create accounts, folders, emails and full-text search tables",
+		Up:          execStatements(baseSchema),
+	},
+	{
+		Version:     2,
+		Description: "Add folder hierarchy, attributes, special-use roles and counts",
+		Up: func(tx *sql.Tx) error {
+			return addColumns(tx, "folders", []column{
+				{"parent_path", "TEXT NOT NULL DEFAULT ''"},
+				{"delimiter", "TEXT NOT NULL DEFAULT ''"},
+				{"attributes", "TEXT NOT NULL DEFAULT '[]'"},
+				{"special_use", "TEXT NOT NULL DEFAULT ''"},
+				{"unseen_count", "INTEGER DEFAULT 0"},
+				{"recent_count", "INTEGER DEFAULT 0"},
+			}, "CREATE INDEX IF NOT EXISTS idx_folders_special_use ON folders(account_id, special_use)")
+		},
+	},
+	{
+		Version:     3,
+		Description: "Add attachment counts to emails",
+		Up: func(tx *sql.Tx) error {
+			return addColumns(tx, "emails", []column{
+				{"attachment_count", "INTEGER NOT NULL DEFAULT 0"},
+			})
+		},
+	},
+	{
+		Version:     4,
+		Description: "Add conversation threads",
+		Up: func(tx *sql.Tx) error {
+			return addColumns(tx, "emails", []column{
+				{"in_reply_to", "TEXT NOT NULL DEFAULT ''"},
+				{"reference_ids", "TEXT NOT NULL DEFAULT '[]'"},
+				{"thread_id", "INTEGER"},
+			}, `CREATE TABLE IF NOT EXISTS threads (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				account_id INTEGER NOT NULL,
+				root_key TEXT NOT NULL,
+				subject TEXT,
+				message_count INTEGER NOT NULL DEFAULT 0,
+				first_date DATETIME,
+				last_date DATETIME,
+				FOREIGN KEY (account_id) REFERENCES accounts(id) ON DELETE CASCADE,
+				UNIQUE(account_id, root_key)
+			)`,
+				"CREATE INDEX IF NOT EXISTS idx_emails_thread_id ON emails(thread_id)")
+		},
+	},
+	{
+		Version:     5,
+		Description: "Add indexed List-Id, Reply-To, Return-Path and X-Mailer headers",
+		Up: func(tx *sql.Tx) error {
+			return addColumns(tx, "emails", []column{
+				{"list_id", "TEXT NOT NULL DEFAULT ''"},
+				{"reply_to", "TEXT NOT NULL DEFAULT ''"},
+				{"return_path", "TEXT NOT NULL DEFAULT ''"},
+				{"x_mailer", "TEXT NOT NULL DEFAULT ''"},
+			},
+				"CREATE INDEX IF NOT EXISTS idx_emails_in_reply_to ON emails(in_reply_to)",
+				"CREATE INDEX IF NOT EXISTS idx_emails_list_id ON emails(list_id)")
+		},
+	},
+	{
+		Version:     6,
+		Description: "Keep the full-text index in step with edits and skip unchanged rows",
+		// External-content FTS5 tables must be told the old values to remove;
+		// the original triggers left stale terms behind on update and delete
+		Up: execStatements(
+			"DROP TRIGGER IF EXISTS emails_fts_update",
+			"DROP TRIGGER IF EXISTS emails_fts_delete",
+			`CREATE TRIGGER emails_fts_update AFTER UPDATE OF subject, sender_email, sender_name, body_text ON emails
+			WHEN old.subject IS NOT new.subject OR old.sender_email IS NOT new.sender_email
+				OR old.sender_name IS NOT new.sender_name OR old.body_text IS NOT new.body_text
+			BEGIN
+				INSERT INTO emails_fts(emails_fts, rowid, subject, sender_email, sender_name, body_text)
+				VALUES ('delete', old.id, old.subject, old.sender_email, old.sender_name, old.body_text);
+				INSERT INTO emails_fts(rowid, subject, sender_email, sender_name, body_text)
+				VALUES (new.id, new.subject, new.sender_email, new.sender_name, new.body_text);
+			END`,
+			`CREATE TRIGGER emails_fts_delete AFTER DELETE ON emails BEGIN
+				INSERT INTO emails_fts(emails_fts, rowid, subject, sender_email, sender_name, body_text)
+				VALUES ('delete', old.id, old.subject, old.sender_email, old.sender_name, old.body_text);
+			END`,
+			"INSERT INTO emails_fts(emails_fts) VALUES ('rebuild')",
+		),
+	},
+	{
+		Version:     7,
+		Description: "Index body text through cache_search_text for encrypted caches",
+		// Encrypted bodies are indexed only when indexing decrypted text is
+		// enabled. The index reads bodies through a view calling the
+		// function, so snippets come from the same text that was indexed.
+		Up: func(tx *sql.Tx) error {
+			err := execStatements(
+				`CREATE TABLE IF NOT EXISTS cache_settings (
+					key TEXT PRIMARY KEY,
+					value TEXT NOT NULL
+				)`,
+				"DROP TRIGGER IF EXISTS emails_fts_insert",
+				"DROP TRIGGER IF EXISTS emails_fts_update",
+				"DROP TRIGGER IF EXISTS emails_fts_delete",
+				"DROP TABLE IF EXISTS emails_fts",
+				`CREATE VIRTUAL TABLE emails_fts USING fts5(
+					subject,
+					sender_email,
+					sender_name,
+					body_text,
+					content='emails_search',
+					content_rowid='id'
+				)`,
+			)(tx)
+			if err == nil {
+				err = execStatements(emailsSearchStatements...)(tx)
+			}
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("INSERT OR REPLACE INTO cache_settings (key, value) VALUES (?, ?)", settingIndexText, indexTextSetting())
+			return err
+		},
+	},
+	{
+		Version:     8,
+		Description: "Track cached sizes and evicted bodies for retention limits",
+		Up: func(tx *sql.Tx) error {
+			return addColumns(tx, "emails", []column{
+				{"content_bytes", "INTEGER NOT NULL DEFAULT 0"},
+				{"body_bytes", "INTEGER NOT NULL DEFAULT 0"},
+				{"body_evicted_at", "DATETIME"},
+			},
+				`UPDATE emails SET body_bytes = length(CAST(COALESCE(body_text, '') AS BLOB))
+					+ length(CAST(COALESCE(body_html, '') AS BLOB)) + length(CAST(COALESCE(headers, '') AS BLOB))`,
+				`UPDATE emails SET content_bytes = body_bytes + length(CAST(COALESCE(subject, '') AS BLOB))
+					+ length(CAST(COALESCE(recipients, '') AS BLOB)) + length(CAST(COALESCE(reference_ids, '') AS BLOB))`,
+				"CREATE INDEX IF NOT EXISTS idx_emails_folder_date ON emails(folder_id, date)")
+		},
+	},
+	{
+		Version: 9,
+		Description: "Cache each message once per account by Message-ID and record the folders holding it " +
+			"in email_locations; IDs of merged duplicates stop resolving",
+		Destructive: true,
+		Up:          mergeDuplicateEmails,
+	},
+}
+
+// emailsSearchStatements create the view the full-text index reads bodies
+// through and the triggers keeping the index in step with emails. Shared by
+// migrations that rebuild the emails table or the index; must not change.
+var emailsSearchStatements = []string{
+	`CREATE VIEW IF NOT EXISTS emails_search AS
+	SELECT id, subject, sender_email, sender_name, cache_search_text(body_text) AS body_text FROM emails`,
+	`CREATE TRIGGER emails_fts_insert AFTER INSERT ON emails BEGIN
+		INSERT INTO emails_fts(rowid, subject, sender_email, sender_name, body_text)
+		VALUES (new.id, new.subject, new.sender_email, new.sender_name, cache_search_text(new.body_text));
+	END`,
+	`CREATE TRIGGER emails_fts_update AFTER UPDATE OF subject, sender_email, sender_name, body_text ON emails
+	WHEN old.subject IS NOT new.subject OR old.sender_email IS NOT new.sender_email
+		OR old.sender_name IS NOT new.sender_name OR old.body_text IS NOT new.body_text
+	BEGIN
+		INSERT INTO emails_fts(emails_fts, rowid, subject, sender_email, sender_name, body_text)
+		VALUES ('delete', old.id, old.subject, old.sender_email, old.sender_name, cache_search_text(old.body_text));
+		INSERT INTO emails_fts(rowid, subject, sender_email, sender_name, body_text)
+		VALUES (new.id, new.subject, new.sender_email, new.sender_name, cache_search_text(new.body_text));
+	END`,
+	`CREATE TRIGGER emails_fts_delete AFTER DELETE ON emails BEGIN
+		INSERT INTO emails_fts(emails_fts, rowid, subject, sender_email, sender_name, body_text)
+		VALUES ('delete', old.id, old.subject, old.sender_email, old.sender_name, cache_search_text(old.body_text));
+	END`,
+	"INSERT INTO emails_fts(emails_fts) VALUES ('rebuild')",
+}
+
+// emailContentColumns are the emails columns describing a message, as
+// opposed to its identity and location
+const emailContentColumns = `message_id, subject, sender_name, sender_email, recipients, date, body_text, body_html,
+	headers, flags, cached_at, attachment_count, in_reply_to, reference_ids, thread_id, list_id, reply_to,
+	return_path, x_mailer, content_bytes, body_bytes, body_evicted_at`
+
+// mergeDuplicateEmails rebuilds emails with one row per account and message
+// key (see MessageKey) and moves folders and UIDs to email_locations. Of the
+// copies of a message the first with its body cached is kept.
+func mergeDuplicateEmails(tx *sql.Tx) error {
+	type message struct {
+		accountID int
+		key       string
+	}
+	type emailCopy struct {
+		message  message
+		folderID int
+		uid      uint32
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, account_id, folder_id, uid, message_id, date, COALESCE(sender_email, ''),
+			COALESCE(recipients, 'null'), COALESCE(subject, ''), body_evicted_at IS NULL
+		FROM emails ORDER BY id
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to read emails: %w", err)
+	}
+
+	kept := make(map[message]int64)
+	hasBody := make(map[message]bool)
+	var copies []emailCopy
+	for rows.Next() {
+		var email types.Email
+		var recipientsJSON string
+		var body bool
+		err := rows.Scan(&email.ID, &email.AccountID, &email.FolderID, &email.UID, &email.MessageID, &email.Date,
+			&email.SenderEmail, &recipientsJSON, &email.Subject, &body)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read emails: %w", err)
+		}
+		_ = json.Unmarshal([]byte(recipientsJSON), &email.Recipients)
+
+		m := message{email.AccountID, MessageKey(&email)}
+		if _, seen := kept[m]; !seen || (body && !hasBody[m]) {
+			kept[m], hasBody[m] = email.ID, body
+		}
+		copies = append(copies, emailCopy{m, email.FolderID, email.UID})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read emails: %w", err)
+	}
+
+	var sequence int64
+	if err := tx.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM sqlite_sequence WHERE name = 'emails'").Scan(&sequence); err != nil {
+		return fmt.Errorf("failed to read email sequence: %w", err)
+	}
+
+	err = execStatements(
+		"DROP VIEW IF EXISTS emails_search",
+		`CREATE TABLE emails_merged (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			account_id INTEGER NOT NULL,
+			message_key TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			subject TEXT,
+			sender_name TEXT,
+			sender_email TEXT,
+			recipients TEXT,
+			date DATETIME NOT NULL,
+			body_text TEXT,
+			body_html TEXT,
+			headers TEXT,
+			flags TEXT,
+			cached_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			attachment_count INTEGER NOT NULL DEFAULT 0,
+			in_reply_to TEXT NOT NULL DEFAULT '',
+			reference_ids TEXT NOT NULL DEFAULT '[]',
+			thread_id INTEGER,
+			list_id TEXT NOT NULL DEFAULT '',
+			reply_to TEXT NOT NULL DEFAULT '',
+			return_path TEXT NOT NULL DEFAULT '',
+			x_mailer TEXT NOT NULL DEFAULT '',
+			content_bytes INTEGER NOT NULL DEFAULT 0,
+			body_bytes INTEGER NOT NULL DEFAULT 0,
+			body_evicted_at DATETIME,
+			FOREIGN KEY (account_id) REFERENCES accounts(id) ON DELETE CASCADE,
+			UNIQUE(account_id, message_key)
+		)`,
+	)(tx)
+	if err != nil {
+		return err
+	}
+
+	insert, err := tx.Prepare(`INSERT INTO emails_merged (id, account_id, message_key, ` + emailContentColumns + `)
+		SELECT id, account_id, ?, ` + emailContentColumns + ` FROM emails WHERE id = ?`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare email copy: %w", err)
+	}
+	defer insert.Close()
+	for m, id := range kept {
+		if _, err := insert.Exec(m.key, id); err != nil {
+			return fmt.Errorf("failed to copy email %d: %w", id, err)
+		}
+	}
+
+	err = execStatements(
+		"DROP TABLE emails",
+		"ALTER TABLE emails_merged RENAME TO emails",
+		"CREATE INDEX idx_emails_account_date ON emails(account_id, date)",
+		"CREATE INDEX idx_emails_date ON emails(date)",
+		"CREATE INDEX idx_emails_sender_email ON emails(sender_email)",
+		"CREATE INDEX idx_emails_message_id ON emails(message_id)",
+		"CREATE INDEX idx_emails_thread_id ON emails(thread_id)",
+		"CREATE INDEX idx_emails_in_reply_to ON emails(in_reply_to)",
+		"CREATE INDEX idx_emails_list_id ON emails(list_id)",
+		`CREATE TABLE email_locations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			email_id INTEGER NOT NULL,
+			folder_id INTEGER NOT NULL,
+			uid INTEGER NOT NULL,
+			FOREIGN KEY (email_id) REFERENCES emails(id) ON DELETE CASCADE,
+			FOREIGN KEY (folder_id) REFERENCES folders(id) ON DELETE CASCADE,
+			UNIQUE(folder_id, uid)
+		)`,
+		"CREATE INDEX idx_email_locations_email_id ON email_locations(email_id)",
+		// A message is deleted with the last folder holding it
+		`CREATE TRIGGER email_locations_delete AFTER DELETE ON email_locations BEGIN
+			DELETE FROM emails WHERE id = old.email_id
+				AND NOT EXISTS (SELECT 1 FROM email_locations WHERE email_id = old.email_id);
+		END`,
+		`CREATE TRIGGER email_locations_update AFTER UPDATE OF email_id ON email_locations
+		WHEN old.email_id != new.email_id
+		BEGIN
+			DELETE FROM emails WHERE id = old.email_id
+				AND NOT EXISTS (SELECT 1 FROM email_locations WHERE email_id = old.email_id);
+		END`,
+	)(tx)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE sqlite_sequence SET seq = MAX(seq, ?) WHERE name = 'emails'", sequence); err != nil {
+		return fmt.Errorf("failed to restore email sequence: %w", err)
+	}
+
+	// Every copy becomes a location of the row kept for its message
+	locate, err := tx.Prepare("INSERT OR IGNORE INTO email_locations (email_id, folder_id, uid) VALUES (?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare location insert: %w", err)
+	}
+	defer locate.Close()
+	for _, c := range copies {
+		if _, err := locate.Exec(kept[c.message], c.folderID, c.uid); err != nil {
+			return fmt.Errorf("failed to record email location: %w", err)
+		}
+	}
+
+	// Threads made up only of merged copies are left empty
+	err = execStatements(emailsSearchStatements...)(tx)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM threads WHERE NOT EXISTS (SELECT 1 FROM emails WHERE thread_id = threads.id)")
+	}
+	return err
+}
+
+// column is a column added by a migration
+type column struct {
+	name       string
+	definition string
+}
+
+// execStatements returns a migration step running SQL statements
+func execStatements(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumns adds the columns a table lacks, then runs the statements.
+// Caches created by development builds may already have some of the columns,
+// so existing ones are skipped rather than failing the migration.
+func addColumns(tx *sql.Tx, table string, columns []column, statements ...string) error {
+	for _, col := range columns {
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, col.name).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to inspect %s: %w", table, err)
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, col.name, col.definition)); err != nil {
+			return fmt.Errorf("failed to add %s.%s: %w", table, col.name, err)
+		}
+	}
+	return execStatements(statements...)(tx)
+}
+
+// Migrations returns every known migration in order
+func Migrations() []Migration {
+	return append([]Migration(nil), migrations...)
+}
+
+// LatestSchemaVersion returns the schema version this build migrates to
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version recorded in the database
+func (c *Cache) SchemaVersion() (int, error) {
+	var version int
+	if err := c.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// PendingMigrations returns the migrations not yet applied to the database
+func (c *Cache) PendingMigrations() ([]Migration, error) {
+	version, err := c.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version > LatestSchemaVersion() {
+		return nil, fmt.Errorf("cache schema version %d is newer than this build supports (%d); "+
+			"upgrade mcp-email-server or use another CACHE_PATH", version, LatestSchemaVersion())
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies pending migrations in order and returns them. With dryRun
+// set it only reports what would be applied. The database is backed up
+// once before the first destructive migration.
+func (c *Cache) Migrate(dryRun bool) ([]Migration, error) {
+	pending, err := c.PendingMigrations()
+	if err != nil || dryRun {
+		return pending, err
+	}
+
+	backedUp := false
+	for i, m := range pending {
+		if m.Destructive && !backedUp {
+			path, err := c.Backup()
+			if err != nil {
+				return pending[:i], fmt.Errorf("failed to back up cache before migration %d: %w", m.Version, err)
+			}
+			if path != "" {
+				c.logger.WithField("backup", path).Info("Backed up cache before destructive migration")
+			}
+			backedUp = true
+		}
+
+		if err := c.apply(m); err != nil {
+			return pending[:i], err
+		}
+		c.logger.WithField("version", m.Version).WithField("description", m.Description).Info("Applied cache migration")
+	}
+
+	// A new or old cache only now has the settings recording its keys
+	if len(pending) > 0 {
+		if err := c.checkEncryption(); err != nil {
+			return pending, err
+		}
+	}
+	return pending, nil
+}
+
+// apply runs a migration and records its version in one transaction
+func (c *Cache) apply(m Migration) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %d: %w", m.Version, err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if err := m.Up(tx); err != nil {
+		return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.Version)); err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %w", m.Version, err)
+	}
+	return nil
+}
+
+// Backup writes a consistent copy of the database next to it, named after
+// the current schema version and time, and returns its path. In-memory
+// databases are not backed up.
+func (c *Cache) Backup() (string, error) {
+	if c.path == "" || isMemoryPath(c.path) {
+		return "", nil
+	}
+
+	version, err := c.SchemaVersion()
+	if err != nil {
+		return "", err
+	}
+
+	path := fmt.Sprintf("%s.v%d-%s.bak", c.path, version, time.Now().UTC().Format("20060102T150405Z"))
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("backup already exists: %s", path)
+	}
+
+	if _, err := c.db.Exec("VACUUM INTO ?", path); err != nil {
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+	return path, nil
+}
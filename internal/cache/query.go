@@ -0,0 +1,712 @@
+package cache
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is a compiled search query, ready to be added to the Search WHERE clause
+type Query struct {
+	// Where is a SQL boolean expression over the emails table aliased as e,
+	// or "" if the query only has full-text terms
+	Where string
+	// Args holds the parameters referenced by Where
+	Args []interface{}
+	// Match is the FTS5 expression for the full-text terms every result must
+	// satisfy, or "" if the query has none. It is applied (and ranked) by
+	// Search in addition to Where.
+	Match string
+
+	// root and now are kept to translate the query for IMAP SEARCH
+	root queryNode
+	now  time.Time
+}
+
+// QueryError reports a syntax error in a search query. Pos is the 1-based
+// character position the error refers to.
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("invalid query at position %d: %s", e.Pos, e.Msg)
+}
+
+// ParseQuery compiles a Gmail-style search query such as
+//
+//	from:alice subject:"Q3 plan" has:attachment after:2025-01-01 is:unread -label:spam
+//
+// Terms are ANDed together; OR, NOT/-, parentheses and quoted phrases are
+// supported. As in Gmail, negation binds tightest, then OR, then AND, so
+// alpha OR gamma delta means (alpha OR gamma) AND delta and -a OR b means
+// (NOT a) OR b. Bare words and phrases are matched against the full-text
+// index.
+func ParseQuery(input string) (*Query, error) {
+	tokens, err := lexQuery(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &queryParser{tokens: tokens}
+	node, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &queryCompiler{now: time.Now()}
+	return c.compile(node)
+}
+
+// Query syntax tree
+
+type queryNode interface{}
+
+type andNode struct {
+	children []queryNode
+}
+
+type orNode struct {
+	children []queryNode
+}
+
+type notNode struct {
+	child queryNode
+}
+
+type termNode struct {
+	field  string
+	value  string
+	phrase bool
+	prefix bool
+	pos    int
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokPhrase
+	tokField
+	tokLParen
+	tokRParen
+	tokMinus
+	tokOr
+	tokAnd
+	tokNot
+)
+
+type queryToken struct {
+	kind  tokenKind
+	text  string
+	pos   int
+	glued bool // no whitespace between this token and the previous one
+}
+
+// lexQuery splits a query into tokens, tracking 1-based rune positions
+func lexQuery(input string) ([]queryToken, error) {
+	runes := []rune(input)
+	var tokens []queryToken
+	glued := false
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		pos := i + 1
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			glued = false
+			continue
+		case r == '(':
+			tokens = append(tokens, queryToken{kind: tokLParen, text: "(", pos: pos, glued: glued})
+			i++
+		case r == ')':
+			tokens = append(tokens, queryToken{kind: tokRParen, text: ")", pos: pos, glued: glued})
+			i++
+		case r == '-' && (i == 0 || unicode.IsSpace(runes[i-1]) || runes[i-1] == '('):
+			tokens = append(tokens, queryToken{kind: tokMinus, text: "-", pos: pos, glued: glued})
+			i++
+		case r == '"':
+			j := i + 1
+			var sb strings.Builder
+			for j < len(runes) && runes[j] != '"' {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				sb.WriteRune(runes[j])
+				j++
+			}
+			if j >= len(runes) {
+				return nil, &QueryError{Pos: pos, Msg: "unterminated quoted phrase"}
+			}
+			tokens = append(tokens, queryToken{kind: tokPhrase, text: sb.String(), pos: pos, glued: glued})
+			i = j + 1
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune(`()"`, runes[j]) {
+				if runes[j] == ':' {
+					break
+				}
+				j++
+			}
+
+			if j < len(runes) && runes[j] == ':' && j > i {
+				field := strings.ToLower(string(runes[i:j]))
+				tokens = append(tokens, queryToken{kind: tokField, text: field, pos: pos, glued: glued})
+				i = j + 1
+				glued = true
+				continue
+			}
+
+			// A colon that does not follow a field name is part of the word
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune(`()"`, runes[j]) {
+				j++
+			}
+
+			word := string(runes[i:j])
+			kind := tokWord
+			switch word {
+			case "OR", "|":
+				kind = tokOr
+			case "AND":
+				kind = tokAnd
+			case "NOT":
+				kind = tokNot
+			}
+			tokens = append(tokens, queryToken{kind: kind, text: word, pos: pos, glued: glued})
+			i = j
+		}
+		glued = true
+	}
+
+	tokens = append(tokens, queryToken{kind: tokEOF, pos: len(runes) + 1})
+	return tokens, nil
+}
+
+// Parser
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *queryParser) parse() (queryNode, error) {
+	if p.peek().kind == tokEOF {
+		return nil, &QueryError{Pos: 1, Msg: "query is empty"}
+	}
+
+	node, err := p.parseAnd("")
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokEOF {
+		if tok.kind == tokRParen {
+			return nil, &QueryError{Pos: tok.pos, Msg: "unexpected ')'"}
+		}
+		return nil, &QueryError{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %q", tok.text)}
+	}
+
+	return node, nil
+}
+
+// parseAnd parses a run of implicitly (or explicitly) ANDed OR-groups.
+// field is applied to bare values inside a field:( ... ) group.
+func (p *queryParser) parseAnd(field string) (queryNode, error) {
+	var children []queryNode
+
+	for {
+		tok := p.peek()
+		if tok.kind == tokAnd {
+			p.next()
+			if len(children) == 0 || !p.startsTerm() {
+				return nil, &QueryError{Pos: tok.pos, Msg: "AND must appear between two search terms"}
+			}
+			continue
+		}
+		if !p.startsTerm() {
+			break
+		}
+
+		child, err := p.parseOr(field)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+
+	if len(children) == 0 {
+		tok := p.peek()
+		switch tok.kind {
+		case tokOr:
+			return nil, &QueryError{Pos: tok.pos, Msg: "OR must appear between two search terms"}
+		case tokRParen:
+			return nil, &QueryError{Pos: tok.pos, Msg: "unexpected ')'"}
+		default:
+			return nil, &QueryError{Pos: tok.pos, Msg: "expected a search term"}
+		}
+	}
+
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &andNode{children: children}, nil
+}
+
+// parseOr parses single terms separated by OR, which binds tighter than
+// AND: a OR b c is (a OR b) AND c
+func (p *queryParser) parseOr(field string) (queryNode, error) {
+	first, err := p.parseUnary(field)
+	if err != nil {
+		return nil, err
+	}
+
+	children := []queryNode{first}
+	for p.peek().kind == tokOr {
+		orTok := p.next()
+		if !p.startsTerm() {
+			return nil, &QueryError{Pos: orTok.pos, Msg: "OR must be followed by a search term"}
+		}
+		child, err := p.parseUnary(field)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+
+	if len(children) == 1 {
+		return first, nil
+	}
+	return &orNode{children: children}, nil
+}
+
+// startsTerm reports whether the next token can begin a term
+func (p *queryParser) startsTerm() bool {
+	switch p.peek().kind {
+	case tokWord, tokPhrase, tokField, tokLParen, tokMinus, tokNot:
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) parseUnary(field string) (queryNode, error) {
+	tok := p.peek()
+	if tok.kind == tokMinus || tok.kind == tokNot {
+		p.next()
+		if !p.startsTerm() {
+			return nil, &QueryError{Pos: tok.pos, Msg: "negation must be followed by a search term"}
+		}
+		child, err := p.parseUnary(field)
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{child: child}, nil
+	}
+	return p.parsePrimary(field)
+}
+
+func (p *queryParser) parsePrimary(field string) (queryNode, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokLParen:
+		if p.peek().kind == tokRParen {
+			return nil, &QueryError{Pos: tok.pos, Msg: "empty group"}
+		}
+		node, err := p.parseAnd(field)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, &QueryError{Pos: tok.pos, Msg: "unclosed '('"}
+		}
+		return node, nil
+
+	case tokField:
+		if _, ok := queryFields[tok.text]; !ok {
+			return nil, &QueryError{Pos: tok.pos, Msg: fmt.Sprintf(
+				"unknown operator %s: (supported: %s); quote the term to search for it as text", tok.text, supportedFields)}
+		}
+		value := p.peek()
+		if !value.glued || (value.kind != tokWord && value.kind != tokPhrase && value.kind != tokLParen) {
+			return nil, &QueryError{Pos: tok.pos, Msg: fmt.Sprintf("missing value for %s:", tok.text)}
+		}
+		if value.kind == tokLParen {
+			return p.parsePrimary(tok.text)
+		}
+		return p.term(tok.text, p.next(), tok.pos), nil
+
+	case tokWord, tokPhrase:
+		return p.term(field, tok, tok.pos), nil
+	}
+
+	return nil, &QueryError{Pos: tok.pos, Msg: "expected a search term"}
+}
+
+// term builds a term node from a word or phrase token
+func (p *queryParser) term(field string, tok queryToken, pos int) *termNode {
+	node := &termNode{field: field, value: tok.text, phrase: tok.kind == tokPhrase, pos: pos}
+	if !node.phrase && len(node.value) > 1 && strings.HasSuffix(node.value, "*") {
+		node.value = strings.TrimSuffix(node.value, "*")
+		node.prefix = true
+	}
+	return node
+}
+
+// Compiler
+
+// queryFields lists supported operators. Aliases share a canonical name.
+var queryFields = map[string]string{
+	"from":       "from",
+	"to":         "to",
+	"cc":         "to",
+	"bcc":        "to",
+	"subject":    "subject",
+	"body":       "body",
+	"has":        "has",
+	"is":         "is",
+	"in":         "in",
+	"label":      "in",
+	"folder":     "in",
+	"account":    "account",
+	"after":      "after",
+	"since":      "after",
+	"before":     "before",
+	"older":      "before",
+	"newer":      "after",
+	"older_than": "older_than",
+	"newer_than": "newer_than",
+
+	"list":        "list",
+	"reply_to":    "reply_to",
+	"replyto":     "reply_to",
+	"return_path": "return_path",
+	"mailer":      "mailer",
+	"x_mailer":    "mailer",
+	"in_reply_to": "in_reply_to",
+	"references":  "references",
+	"msgid":       "msgid",
+	"rfc822msgid": "msgid",
+}
+
+// headerColumn is a header promoted to its own column of the emails table
+type headerColumn struct {
+	column string
+	header string
+	// messageID columns hold message IDs and match them whole
+	messageID bool
+}
+
+// headerColumns maps the operators on promoted headers to their columns
+var headerColumns = map[string]headerColumn{
+	"list":        {column: "e.list_id", header: "List-Id"},
+	"reply_to":    {column: "e.reply_to", header: "Reply-To"},
+	"return_path": {column: "e.return_path", header: "Return-Path"},
+	"mailer":      {column: "e.x_mailer", header: "X-Mailer"},
+	"in_reply_to": {column: "e.in_reply_to", header: "In-Reply-To", messageID: true},
+	"references":  {column: "e.reference_ids", header: "References", messageID: true},
+	"msgid":       {column: "e.message_id", header: "Message-Id", messageID: true},
+}
+
+// ftsColumns maps full-text operators to emails_fts columns
+var ftsColumns = map[string]string{
+	"":        "",
+	"subject": "subject",
+	"body":    "body_text",
+}
+
+// flagConditions maps is: values to a flag and whether it must be present
+var flagConditions = map[string]struct {
+	flag    string
+	present bool
+}{
+	"unread":   {`\Seen`, false},
+	"unseen":   {`\Seen`, false},
+	"read":     {`\Seen`, true},
+	"seen":     {`\Seen`, true},
+	"starred":  {`\Flagged`, true},
+	"flagged":  {`\Flagged`, true},
+	"answered": {`\Answered`, true},
+	"replied":  {`\Answered`, true},
+	"draft":    {`\Draft`, true},
+	"deleted":  {`\Deleted`, true},
+}
+
+var relativeAge = regexp.MustCompile(`^(\d+)([dwmy])$`)
+
+const supportedFields = "from: to: cc: bcc: subject: body: has: is: in: label: folder: account: " +
+	"after: before: older_than: newer_than: list: reply_to: return_path: mailer: in_reply_to: references: msgid:"
+
+type queryCompiler struct {
+	now time.Time
+}
+
+// compile turns the syntax tree into SQL. Top-level full-text terms are
+// merged into a single MATCH so callers can rank and highlight with it.
+func (c *queryCompiler) compile(node queryNode) (*Query, error) {
+	children := []queryNode{node}
+	if and, ok := node.(*andNode); ok {
+		children = and.children
+	}
+
+	var matches []string
+	var rest []queryNode
+	for _, child := range children {
+		if expr, ok := c.fts(child); ok {
+			matches = append(matches, expr)
+			continue
+		}
+		rest = append(rest, child)
+	}
+
+	q := &Query{root: node, now: c.now}
+	var conditions []string
+
+	q.Match = strings.Join(matches, " AND ")
+
+	for _, child := range rest {
+		cond, args, err := c.sql(child)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, cond)
+		q.Args = append(q.Args, args...)
+	}
+
+	q.Where = strings.Join(conditions, " AND ")
+	return q, nil
+}
+
+const ftsCondition = "e.id IN (SELECT rowid FROM emails_fts WHERE emails_fts MATCH ?)"
+
+// fts returns the FTS5 expression for a node made only of positive
+// full-text terms; ok is false for anything else
+func (c *queryCompiler) fts(node queryNode) (string, bool) {
+	switch n := node.(type) {
+	case *termNode:
+		column, ok := ftsColumns[queryFields[n.field]]
+		if !ok {
+			return "", false
+		}
+		expr := `"` + strings.ReplaceAll(n.value, `"`, `""`) + `"`
+		if n.prefix {
+			expr += " *"
+		}
+		if column != "" {
+			expr = column + " : " + expr
+		}
+		return expr, true
+
+	case *andNode, *orNode:
+		var children []queryNode
+		op := " AND "
+		if and, ok := n.(*andNode); ok {
+			children = and.children
+		} else {
+			children = n.(*orNode).children
+			op = " OR "
+		}
+
+		exprs := make([]string, 0, len(children))
+		for _, child := range children {
+			expr, ok := c.fts(child)
+			if !ok {
+				return "", false
+			}
+			exprs = append(exprs, expr)
+		}
+		return "(" + strings.Join(exprs, op) + ")", true
+	}
+
+	return "", false
+}
+
+// sql compiles a node into a SQL condition
+func (c *queryCompiler) sql(node queryNode) (string, []interface{}, error) {
+	if expr, ok := c.fts(node); ok {
+		return ftsCondition, []interface{}{expr}, nil
+	}
+
+	switch n := node.(type) {
+	case *andNode, *orNode:
+		var children []queryNode
+		op := " AND "
+		if and, ok := n.(*andNode); ok {
+			children = and.children
+		} else {
+			children = n.(*orNode).children
+			op = " OR "
+		}
+
+		var conditions []string
+		var args []interface{}
+		for _, child := range children {
+			cond, childArgs, err := c.sql(child)
+			if err != nil {
+				return "", nil, err
+			}
+			conditions = append(conditions, cond)
+			args = append(args, childArgs...)
+		}
+		return "(" + strings.Join(conditions, op) + ")", args, nil
+
+	case *notNode:
+		cond, args, err := c.sql(n.child)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT (" + cond + ")", args, nil
+
+	case *termNode:
+		return c.term(n)
+	}
+
+	return "", nil, fmt.Errorf("unexpected query node %T", node)
+}
+
+// term compiles a single operator term into a SQL condition
+func (c *queryCompiler) term(n *termNode) (string, []interface{}, error) {
+	value := n.value
+	if n.prefix {
+		value += "*"
+	}
+
+	if h, ok := headerColumns[queryFields[n.field]]; ok {
+		return h.condition(n.value)
+	}
+
+	switch queryFields[n.field] {
+	case "from":
+		like := "%" + value + "%"
+		return "(e.sender_email LIKE ? OR e.sender_name LIKE ?)", []interface{}{like, like}, nil
+
+	case "to":
+		return "e.recipients LIKE ?", []interface{}{"%" + value + "%"}, nil
+
+	case "has":
+		switch strings.ToLower(value) {
+		case "attachment", "attachments":
+			return "e.attachment_count > 0", nil, nil
+		}
+		return "", nil, &QueryError{Pos: n.pos, Msg: fmt.Sprintf("unsupported value for has: %q (supported: attachment)", value)}
+
+	case "is":
+		cond, ok := flagConditions[strings.ToLower(value)]
+		if !ok {
+			return "", nil, &QueryError{Pos: n.pos, Msg: fmt.Sprintf(
+				"unsupported value for is: %q (supported: unread, read, starred, flagged, answered, draft, deleted)", value)}
+		}
+		sql := "EXISTS (SELECT 1 FROM json_each(e.flags) WHERE json_each.value = ?)"
+		if !cond.present {
+			sql = "NOT " + sql
+		}
+		return sql, []interface{}{cond.flag}, nil
+
+	case "in":
+		args := []interface{}{value}
+		sql := "path = ?"
+		if strings.EqualFold(value, "INBOX") {
+			sql += " OR upper(path) = 'INBOX'"
+		}
+		if role, ok := folderRoleAliases[strings.ToLower(value)]; ok {
+			sql += " OR special_use = ?"
+			args = append(args, role)
+		}
+		return "e.id IN (SELECT email_id FROM email_locations WHERE folder_id IN (SELECT id FROM folders WHERE " + sql + "))", args, nil
+
+	case "account":
+		return "e.account_id IN (SELECT id FROM accounts WHERE name = ?)", []interface{}{value}, nil
+
+	case "after", "before":
+		date, err := parseQueryDate(value)
+		if err != nil {
+			return "", nil, &QueryError{Pos: n.pos, Msg: fmt.Sprintf("%s: expects a date like 2025-01-31, got %q", n.field, value)}
+		}
+		if queryFields[n.field] == "after" {
+			return "e.date >= ?", []interface{}{date}, nil
+		}
+		return "e.date < ?", []interface{}{date}, nil
+
+	case "older_than", "newer_than":
+		date, err := c.relativeDate(value)
+		if err != nil {
+			return "", nil, &QueryError{Pos: n.pos, Msg: fmt.Sprintf("%s: expects an age like 7d, 2w, 3m or 1y, got %q", n.field, value)}
+		}
+		if queryFields[n.field] == "newer_than" {
+			return "e.date >= ?", []interface{}{date}, nil
+		}
+		return "e.date < ?", []interface{}{date}, nil
+	}
+
+	return "", nil, &QueryError{Pos: n.pos, Msg: fmt.Sprintf("unsupported operator %s:", n.field)}
+}
+
+// condition matches value against the column: message IDs exactly, with or
+// without angle brackets, other headers as substrings
+func (h headerColumn) condition(value string) (string, []interface{}, error) {
+	if !h.messageID {
+		return h.column + " LIKE ?", []interface{}{"%" + value + "%"}, nil
+	}
+
+	if ids := ParseMessageIDs(value); len(ids) > 0 {
+		value = ids[0]
+	}
+	if h.column == "e.reference_ids" {
+		return "EXISTS (SELECT 1 FROM json_each(e.reference_ids) WHERE json_each.value = ?)", []interface{}{value}, nil
+	}
+	return h.column + " = ?", []interface{}{value}, nil
+}
+
+// parseQueryDate accepts YYYY-MM-DD, YYYY/MM/DD or RFC 3339 timestamps
+func parseQueryDate(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006/01/02", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date: %s", value)
+}
+
+// relativeDate resolves an age such as 7d, 2w, 3m or 1y against now
+func (c *queryCompiler) relativeDate(value string) (time.Time, error) {
+	m := relativeAge.FindStringSubmatch(strings.ToLower(value))
+	if m == nil {
+		return time.Time{}, fmt.Errorf("invalid age: %s", value)
+	}
+
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid age: %s", value)
+	}
+
+	switch m[2] {
+	case "d":
+		return c.now.AddDate(0, 0, -n), nil
+	case "w":
+		return c.now.AddDate(0, 0, -7*n), nil
+	case "m":
+		return c.now.AddDate(0, -n, 0), nil
+	default:
+		return c.now.AddDate(-n, 0, 0), nil
+	}
+}
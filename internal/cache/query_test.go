@@ -0,0 +1,315 @@
+package cache
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// render prints a syntax tree compactly, for comparing parses
+func render(node queryNode) string {
+	switch n := node.(type) {
+	case *andNode:
+		return "AND(" + renderAll(n.children) + ")"
+	case *orNode:
+		return "OR(" + renderAll(n.children) + ")"
+	case *notNode:
+		return "NOT(" + render(n.child) + ")"
+	case *termNode:
+		value := n.value
+		if n.phrase {
+			value = `"` + value + `"`
+		}
+		if n.prefix {
+			value += "*"
+		}
+		if n.field != "" {
+			return n.field + ":" + value
+		}
+		return value
+	}
+	return "?"
+}
+
+func renderAll(nodes []queryNode) string {
+	parts := make([]string, len(nodes))
+	for i, node := range nodes {
+		parts[i] = render(node)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestLexQuery(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []queryToken
+	}{
+		{
+			input: `from:alice "q3 plan"`,
+			want: []queryToken{
+				{kind: tokField, text: "from", pos: 1},
+				{kind: tokWord, text: "alice", pos: 6, glued: true},
+				{kind: tokPhrase, text: "q3 plan", pos: 12},
+			},
+		},
+		{
+			input: `-spam (a OR b)`,
+			want: []queryToken{
+				{kind: tokMinus, text: "-", pos: 1},
+				{kind: tokWord, text: "spam", pos: 2, glued: true},
+				{kind: tokLParen, text: "(", pos: 7},
+				{kind: tokWord, text: "a", pos: 8, glued: true},
+				{kind: tokOr, text: "OR", pos: 10},
+				{kind: tokWord, text: "b", pos: 13},
+				{kind: tokRParen, text: ")", pos: 14, glued: true},
+			},
+		},
+		{
+			// Positions count characters, not bytes
+			input: `café NOT x-ray`,
+			want: []queryToken{
+				{kind: tokWord, text: "café", pos: 1},
+				{kind: tokNot, text: "NOT", pos: 6},
+				{kind: tokWord, text: "x-ray", pos: 10},
+			},
+		},
+		{
+			input: `"say \"hi\"" a | b AND c`,
+			want: []queryToken{
+				{kind: tokPhrase, text: `say "hi"`, pos: 1},
+				{kind: tokWord, text: "a", pos: 14},
+				{kind: tokOr, text: "|", pos: 16},
+				{kind: tokWord, text: "b", pos: 18},
+				{kind: tokAnd, text: "AND", pos: 20},
+				{kind: tokWord, text: "c", pos: 24},
+			},
+		},
+		{
+			input: `SUBJECT:(x)`,
+			want: []queryToken{
+				{kind: tokField, text: "subject", pos: 1},
+				{kind: tokLParen, text: "(", pos: 9, glued: true},
+				{kind: tokWord, text: "x", pos: 10, glued: true},
+				{kind: tokRParen, text: ")", pos: 11, glued: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := lexQuery(tt.input)
+			if err != nil {
+				t.Fatalf("lexQuery() error = %v", err)
+			}
+			want := append(tt.want, queryToken{kind: tokEOF, pos: len([]rune(tt.input)) + 1})
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("lexQuery() =\n%+v\nwant\n%+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseQueryTree(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		// Precedence: negation, then OR, then AND
+		{`alpha OR gamma delta`, `AND(OR(alpha gamma) delta)`},
+		{`a b OR c`, `AND(a OR(b c))`},
+		{`a OR b OR c`, `OR(a b c)`},
+		{`a | b`, `OR(a b)`},
+		{`-a OR b`, `OR(NOT(a) b)`},
+		{`NOT a b`, `AND(NOT(a) b)`},
+		{`a AND b OR c`, `AND(a OR(b c))`},
+
+		// Grouping
+		{`(a b) OR c`, `OR(AND(a b) c)`},
+		{`NOT (a OR b) c`, `AND(NOT(OR(a b)) c)`},
+		{`((a))`, `a`},
+		{`- -a`, `NOT(NOT(a))`},
+
+		// Phrases, prefixes and fields
+		{`"q3 plan"`, `"q3 plan"`},
+		{`subject:"q3 plan"`, `subject:"q3 plan"`},
+		{`inv*`, `inv*`},
+		{`"inv*"`, `"inv*"`},
+		{`*`, `*`},
+		{`from:(alice OR bob)`, `OR(from:alice from:bob)`},
+		{`from:(alice bob) is:unread`, `AND(AND(from:alice from:bob) is:unread)`},
+		{`-label:spam`, `NOT(label:spam)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := lexQuery(tt.input)
+			if err != nil {
+				t.Fatalf("lexQuery() error = %v", err)
+			}
+			node, err := (&queryParser{tokens: tokens}).parse()
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			if got := render(node); got != tt.want {
+				t.Errorf("parse() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+		msg   string
+	}{
+		{``, 1, "query is empty"},
+		{`   `, 1, "query is empty"},
+		{`a "open`, 3, "unterminated quoted phrase"},
+		{`a OR`, 3, "OR must be followed by a search term"},
+		{`OR a`, 1, "OR must appear between two search terms"},
+		{`a (OR b)`, 4, "OR must appear between two search terms"},
+		{`a AND`, 3, "AND must appear between two search terms"},
+		{`AND a`, 1, "AND must appear between two search terms"},
+		{`(a b`, 1, "unclosed '('"},
+		{`a)`, 2, "unexpected ')'"},
+		{`a ()`, 3, "empty group"},
+		{`-`, 1, "negation must be followed by a search term"},
+		{`a NOT`, 3, "negation must be followed by a search term"},
+		{`foo:bar`, 1, "unknown operator foo:"},
+		{`from: alice`, 1, "missing value for from:"},
+		{`x subject:`, 3, "missing value for subject:"},
+		{`has:pdf`, 1, `unsupported value for has: "pdf"`},
+		{`is:important`, 1, `unsupported value for is: "important"`},
+		{`a after:yesterday`, 3, `after: expects a date like 2025-01-31, got "yesterday"`},
+		{`older_than:3h`, 1, `older_than: expects an age like 7d`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := ParseQuery(tt.input)
+			var qerr *QueryError
+			if !errors.As(err, &qerr) {
+				t.Fatalf("ParseQuery() error = %v, want a *QueryError", err)
+			}
+			if qerr.Pos != tt.pos || !strings.Contains(qerr.Msg, tt.msg) {
+				t.Errorf("ParseQuery() error at %d %q, want at %d containing %q", qerr.Pos, qerr.Msg, tt.pos, tt.msg)
+			}
+		})
+	}
+}
+
+func TestCompileQuery(t *testing.T) {
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	const fromCondition = "(e.sender_email LIKE ? OR e.sender_name LIKE ?)"
+	const unreadCondition = "NOT EXISTS (SELECT 1 FROM json_each(e.flags) WHERE json_each.value = ?)"
+
+	tests := []struct {
+		input string
+		match string
+		where string
+		args  []interface{}
+	}{
+		// Top-level full-text terms merge into one MATCH
+		{input: `hello world`, match: `"hello" AND "world"`},
+		{input: `subject:plan body:"q3 x"`, match: `subject : "plan" AND body_text : "q3 x"`},
+		{input: `inv*`, match: `"inv" *`},
+		{input: `"say \"hi\""`, match: `"say ""hi"""`},
+		{input: `alpha OR gamma delta`, match: `("alpha" OR "gamma") AND "delta"`},
+		{input: `(a b) OR c`, match: `(("a" AND "b") OR "c")`},
+
+		// Other terms become SQL conditions
+		{
+			input: `from:alice is:unread`,
+			where: fromCondition + " AND " + unreadCondition,
+			args:  []interface{}{"%alice%", "%alice%", `\Seen`},
+		},
+		{
+			input: `report from:alice`,
+			match: `"report"`,
+			where: fromCondition,
+			args:  []interface{}{"%alice%", "%alice%"},
+		},
+		{
+			input: `-hello`,
+			where: "NOT (" + ftsCondition + ")",
+			args:  []interface{}{`"hello"`},
+		},
+		{
+			input: `alpha OR from:bob`,
+			where: "(" + ftsCondition + " OR " + fromCondition + ")",
+			args:  []interface{}{`"alpha"`, "%bob%", "%bob%"},
+		},
+		{
+			input: `has:attachment -is:starred`,
+			where: "e.attachment_count > 0 AND NOT (EXISTS (SELECT 1 FROM json_each(e.flags) WHERE json_each.value = ?))",
+			args:  []interface{}{`\Flagged`},
+		},
+		{
+			input: `to:bob@example.com`,
+			where: "e.recipients LIKE ?",
+			args:  []interface{}{"%bob@example.com%"},
+		},
+		{
+			input: `account:work`,
+			where: "e.account_id IN (SELECT id FROM accounts WHERE name = ?)",
+			args:  []interface{}{"work"},
+		},
+		{
+			input: `after:2025-01-31 before:2025/03/01`,
+			where: "e.date >= ? AND e.date < ?",
+			args: []interface{}{
+				time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			input: `newer_than:2w older_than:1m`,
+			where: "e.date >= ? AND e.date < ?",
+			args:  []interface{}{now.AddDate(0, 0, -14), now.AddDate(0, -1, 0)},
+		},
+		{
+			input: `list:announce`,
+			where: "e.list_id LIKE ?",
+			args:  []interface{}{"%announce%"},
+		},
+		{
+			input: `msgid:abc@example.com`,
+			where: "e.message_id = ?",
+			args:  []interface{}{"<abc@example.com>"},
+		},
+		{
+			input: `references:<abc@example.com>`,
+			where: "EXISTS (SELECT 1 FROM json_each(e.reference_ids) WHERE json_each.value = ?)",
+			args:  []interface{}{"<abc@example.com>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := lexQuery(tt.input)
+			if err != nil {
+				t.Fatalf("lexQuery() error = %v", err)
+			}
+			node, err := (&queryParser{tokens: tokens}).parse()
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			q, err := (&queryCompiler{now: now}).compile(node)
+			if err != nil {
+				t.Fatalf("compile() error = %v", err)
+			}
+			if q.Match != tt.match {
+				t.Errorf("Match = %q, want %q", q.Match, tt.match)
+			}
+			if q.Where != tt.where {
+				t.Errorf("Where = %q, want %q", q.Where, tt.where)
+			}
+			if !reflect.DeepEqual(q.Args, tt.args) {
+				t.Errorf("Args = %#v, want %#v", q.Args, tt.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,483 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/internal/config"
+)
+
+// evictBatchSize is the number of emails evicted per transaction, so
+// eviction never holds the write lock for long while a sync is waiting
+const evictBatchSize = 500
+
+// vacuumFreeRatio is the share of free pages above which a scheduled full
+// VACUUM compacts the database file
+const vacuumFreeRatio = 0.1
+
+// Cache settings recording retention runs
+const (
+	settingLastEviction = "last_eviction"
+	settingLastVacuum   = "last_vacuum"
+)
+
+// EvictionResult reports what an eviction pass removed. MessagesDeleted
+// counts messages removed from the accounts or folders a rule limits; a
+// message still held in another folder stays cached, and only the bytes of
+// messages no folder holds any more are freed.
+type EvictionResult struct {
+	BodiesDropped   int   `json:"bodies_dropped"`
+	MessagesDeleted int   `json:"messages_deleted"`
+	BytesFreed      int64 `json:"bytes_freed"`
+}
+
+// add accumulates another result
+func (r *EvictionResult) add(other EvictionResult) {
+	r.BodiesDropped += other.BodiesDropped
+	r.MessagesDeleted += other.MessagesDeleted
+	r.BytesFreed += other.BytesFreed
+}
+
+// retentionScope is the set of emails a rule limits: one account, or the
+// emails held in one folder of an account
+type retentionScope struct {
+	accountID int
+	folderID  int
+	name      string
+}
+
+// condition returns the SQL condition selecting the scope's emails
+func (sc retentionScope) condition() (string, []interface{}) {
+	if sc.folderID != 0 {
+		return "id IN (SELECT email_id FROM email_locations WHERE folder_id = ?)", []interface{}{sc.folderID}
+	}
+	return "account_id = ?", []interface{}{sc.accountID}
+}
+
+// Evict enforces retention rules: messages past MaxAge or beyond
+// MaxMessages are deleted, bodies past BodyMaxAge are dropped, and scopes
+// over MaxBytes lose the bodies of their oldest messages first and then
+// the oldest messages themselves. Threads of affected accounts are rebuilt.
+func (s *Store) Evict(rules config.RetentionRules, now time.Time) (*EvictionResult, error) {
+	result := &EvictionResult{}
+	touched := make(map[int]bool)
+
+	for _, rule := range rules {
+		scopes, err := s.retentionScopes(rule)
+		if err != nil {
+			return result, err
+		}
+		for _, scope := range scopes {
+			r, err := s.evictScope(rule, scope, now)
+			result.add(r)
+			if r.MessagesDeleted > 0 {
+				touched[scope.accountID] = true
+			}
+			if err != nil {
+				return result, fmt.Errorf("failed to apply retention rule %q to %s: %w", rule.String(), scope.name, err)
+			}
+		}
+	}
+
+	for accountID := range touched {
+		if err := s.RebuildThreads(accountID); err != nil {
+			return result, err
+		}
+	}
+
+	data := fmt.Sprintf("%s %d %d %d", now.UTC().Format(time.RFC3339), result.BodiesDropped, result.MessagesDeleted, result.BytesFreed)
+	if err := s.cache.setSetting(settingLastEviction, data); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// retentionScopes lists the accounts or folders a rule applies to
+func (s *Store) retentionScopes(rule config.RetentionRule) ([]retentionScope, error) {
+	folders, err := s.ListFolders(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var scopes []retentionScope
+	seen := make(map[int]bool)
+	for _, f := range folders {
+		switch {
+		case rule.Folder != "":
+			if rule.MatchesFolder(f.AccountName, f.Path) {
+				scopes = append(scopes, retentionScope{accountID: f.AccountID, folderID: f.ID, name: f.AccountName + ":" + f.Path})
+			}
+		case rule.MatchesAccount(f.AccountName) && !seen[f.AccountID]:
+			seen[f.AccountID] = true
+			scopes = append(scopes, retentionScope{accountID: f.AccountID, name: f.AccountName})
+		}
+	}
+	return scopes, nil
+}
+
+// evictScope applies one rule's limits to one scope
+func (s *Store) evictScope(rule config.RetentionRule, scope retentionScope, now time.Time) (EvictionResult, error) {
+	var result EvictionResult
+	where, args := scope.condition()
+
+	if rule.MaxAge > 0 {
+		r, err := s.evictWhere(scope, false, where+" AND date < ?", append(args, now.Add(-rule.MaxAge))...)
+		result.add(r)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if rule.BodyMaxAge > 0 {
+		r, err := s.evictWhere(scope, true, where+" AND body_evicted_at IS NULL AND date < ?", append(args, now.Add(-rule.BodyMaxAge))...)
+		result.add(r)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if rule.MaxMessages > 0 {
+		// Everything after the newest MaxMessages
+		var cutoff struct {
+			date string
+			id   int64
+		}
+		err := s.cache.DB().QueryRow("SELECT CAST(date AS TEXT), id FROM emails WHERE "+where+
+			" ORDER BY date DESC, id DESC LIMIT 1 OFFSET ?", append(args, rule.MaxMessages-1)...).Scan(&cutoff.date, &cutoff.id)
+		if err != nil && err != sql.ErrNoRows {
+			return result, fmt.Errorf("failed to count cached emails: %w", err)
+		}
+		if err == nil {
+			r, err := s.evictWhere(scope, false, where+" AND (date < ? OR (date = ? AND id < ?))",
+				append(args, cutoff.date, cutoff.date, cutoff.id)...)
+			result.add(r)
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+
+	if rule.MaxBytes > 0 {
+		r, err := s.evictBytes(scope, rule.MaxBytes)
+		result.add(r)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// evictBytes brings a scope under maxBytes, dropping the bodies of the
+// oldest messages first and then deleting the oldest messages
+func (s *Store) evictBytes(scope retentionScope, maxBytes int64) (EvictionResult, error) {
+	var result EvictionResult
+	where, args := scope.condition()
+
+	var total int64
+	if err := s.cache.DB().QueryRow("SELECT COALESCE(SUM(content_bytes), 0) FROM emails WHERE "+where, args...).Scan(&total); err != nil {
+		return result, fmt.Errorf("failed to measure cached bytes: %w", err)
+	}
+
+	for _, bodies := range []bool{true, false} {
+		if total <= maxBytes {
+			break
+		}
+
+		column, filter := "content_bytes", ""
+		if bodies {
+			column, filter = "body_bytes", " AND body_evicted_at IS NULL"
+		}
+		ids, err := s.oldestCovering(total-maxBytes, column, where+filter, args)
+		if err != nil {
+			return result, err
+		}
+
+		r, err := s.evictIDs(scope, bodies, ids)
+		result.add(r)
+		total -= r.BytesFreed
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// oldestCovering returns the oldest emails in a scope whose column sizes
+// add up to at least excess
+func (s *Store) oldestCovering(excess int64, column, where string, args []interface{}) ([]int64, error) {
+	rows, err := s.cache.DB().Query("SELECT id, "+column+" FROM emails WHERE "+where+" ORDER BY date, id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list emails to evict: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	var freed int64
+	for freed < excess && rows.Next() {
+		var id, size int64
+		if err := rows.Scan(&id, &size); err != nil {
+			return nil, fmt.Errorf("failed to list emails to evict: %w", err)
+		}
+		ids = append(ids, id)
+		freed += size
+	}
+	return ids, rows.Err()
+}
+
+// evictWhere evicts the emails of a scope matching a condition
+func (s *Store) evictWhere(scope retentionScope, bodies bool, where string, args ...interface{}) (EvictionResult, error) {
+	ids, err := s.queryInt64s("SELECT id FROM emails WHERE "+where, args...)
+	if err != nil {
+		return EvictionResult{}, fmt.Errorf("failed to list emails to evict: %w", err)
+	}
+	return s.evictIDs(scope, bodies, ids)
+}
+
+// evictIDs drops the bodies of emails, or removes them from a scope, in
+// batches
+func (s *Store) evictIDs(scope retentionScope, bodies bool, ids []int64) (EvictionResult, error) {
+	var result EvictionResult
+	for start := 0; start < len(ids); start += evictBatchSize {
+		batch := ids[start:min(start+evictBatchSize, len(ids))]
+		r, err := s.evictBatch(scope, bodies, batch)
+		result.add(r)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// evictBatch drops the bodies of emails, or removes them from a scope, in
+// one transaction. Removing emails from a folder deletes their location
+// there; emails left in no folder are deleted by a trigger.
+func (s *Store) evictBatch(scope retentionScope, bodies bool, ids []int64) (EvictionResult, error) {
+	var result EvictionResult
+
+	tx, err := s.cache.DB().Begin()
+	if err != nil {
+		return result, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	in := "id IN (" + placeholders(len(ids)) + ")"
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	measure := "SELECT COALESCE(SUM(content_bytes), 0) FROM emails WHERE " + in
+	measureArgs := args
+	switch {
+	case bodies:
+		measure = "SELECT COALESCE(SUM(body_bytes), 0) FROM emails WHERE " + in
+	case scope.folderID != 0:
+		// Only messages held in no other folder are freed
+		measure += " AND NOT EXISTS (SELECT 1 FROM email_locations l WHERE l.email_id = emails.id AND l.folder_id != ?)"
+		measureArgs = append(append([]interface{}{}, args...), scope.folderID)
+	}
+	if err := tx.QueryRow(measure, measureArgs...).Scan(&result.BytesFreed); err != nil {
+		return EvictionResult{}, fmt.Errorf("failed to measure evicted bytes: %w", err)
+	}
+
+	var res sql.Result
+	switch {
+	case bodies:
+		res, err = tx.Exec(`UPDATE emails SET body_text = '', body_html = '', headers = '{}',
+			content_bytes = content_bytes - body_bytes, body_bytes = 0, body_evicted_at = CURRENT_TIMESTAMP
+			WHERE body_evicted_at IS NULL AND `+in, args...)
+	case scope.folderID != 0:
+		res, err = tx.Exec("DELETE FROM email_locations WHERE folder_id = ? AND email_"+in,
+			append([]interface{}{scope.folderID}, args...)...)
+	default:
+		res, err = tx.Exec("DELETE FROM emails WHERE "+in, args...)
+	}
+	if err != nil {
+		return EvictionResult{}, fmt.Errorf("failed to evict emails: %w", err)
+	}
+	n, _ := res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return EvictionResult{}, fmt.Errorf("failed to commit eviction: %w", err)
+	}
+
+	if bodies {
+		result.BodiesDropped = int(n)
+	} else {
+		result.MessagesDeleted = int(n)
+	}
+	return result, nil
+}
+
+// queryInt64s runs a query returning one integer column
+func (s *Store) queryInt64s(query string, args ...interface{}) ([]int64, error) {
+	rows, err := s.cache.DB().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []int64
+	for rows.Next() {
+		var v int64
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// Compact returns free pages to the file system. Databases in incremental
+// auto-vacuum mode are trimmed every time. A full VACUUM runs at most every
+// vacuumInterval: once to switch databases created before incremental mode
+// to it, and afterwards only when free pages exceed vacuumFreeRatio. It
+// reports whether a full VACUUM ran.
+func (c *Cache) Compact(vacuumInterval time.Duration, now time.Time) (bool, error) {
+	var autoVacuum, pages, free int64
+	if err := c.db.QueryRow("PRAGMA auto_vacuum").Scan(&autoVacuum); err != nil {
+		return false, fmt.Errorf("failed to read auto_vacuum: %w", err)
+	}
+
+	// 2 is incremental mode
+	incremental := autoVacuum == 2
+	if incremental {
+		if _, err := c.db.Exec("PRAGMA incremental_vacuum"); err != nil {
+			return false, fmt.Errorf("failed to run incremental vacuum: %w", err)
+		}
+	}
+
+	if err := c.db.QueryRow("PRAGMA page_count").Scan(&pages); err != nil {
+		return false, fmt.Errorf("failed to read page count: %w", err)
+	}
+	if err := c.db.QueryRow("PRAGMA freelist_count").Scan(&free); err != nil {
+		return false, fmt.Errorf("failed to read free pages: %w", err)
+	}
+
+	last, err := c.setting(settingLastVacuum)
+	if err != nil {
+		return false, err
+	}
+	due := true
+	if t, err := time.Parse(time.RFC3339, last); err == nil {
+		due = now.Sub(t) >= vacuumInterval
+	}
+	fragmented := pages > 0 && float64(free)/float64(pages) > vacuumFreeRatio
+	if !due || (incremental && !fragmented) {
+		return false, c.checkpoint()
+	}
+
+	c.logger.WithField("free_pages", free).Info("Vacuuming cache")
+	if !incremental {
+		if _, err := c.db.Exec("PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+			return false, fmt.Errorf("failed to enable incremental vacuum: %w", err)
+		}
+	}
+	if _, err := c.db.Exec("VACUUM"); err != nil {
+		return false, fmt.Errorf("failed to vacuum cache: %w", err)
+	}
+	if err := c.setSetting(settingLastVacuum, now.UTC().Format(time.RFC3339)); err != nil {
+		return true, err
+	}
+	return true, c.checkpoint()
+}
+
+// checkpoint copies the write-ahead log into the database and truncates it
+func (c *Cache) checkpoint() error {
+	if _, err := c.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return fmt.Errorf("failed to checkpoint cache: %w", err)
+	}
+	return nil
+}
+
+// RetentionJob periodically evicts cached emails according to the
+// retention rules and compacts the database
+type RetentionJob struct {
+	store          *Store
+	rules          config.RetentionRules
+	interval       time.Duration
+	vacuumInterval time.Duration
+	logger         *logrus.Logger
+}
+
+// NewRetentionJob creates a retention job from the cache configuration
+func NewRetentionJob(store *Store, cfg *config.Config, logger *logrus.Logger) *RetentionJob {
+	return &RetentionJob{
+		store:          store,
+		rules:          cfg.Retention,
+		interval:       cfg.EvictionInterval,
+		vacuumInterval: cfg.VacuumInterval,
+		logger:         logger,
+	}
+}
+
+// Run enforces retention now and then every interval until ctx is done
+func (j *RetentionJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		j.RunOnce()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce runs one eviction pass and compaction
+func (j *RetentionJob) RunOnce() {
+	now := time.Now()
+
+	if len(j.rules) > 0 {
+		result, err := j.store.Evict(j.rules, now)
+		if err != nil {
+			j.logger.WithError(err).Warn("Cache eviction failed")
+		}
+		if result != nil && (result.BodiesDropped > 0 || result.MessagesDeleted > 0) {
+			j.logger.WithFields(logrus.Fields{
+				"bodies_dropped":   result.BodiesDropped,
+				"messages_deleted": result.MessagesDeleted,
+				"bytes_freed":      result.BytesFreed,
+			}).Info("Evicted cached emails")
+		}
+	}
+
+	vacuumed, err := j.store.cache.Compact(j.vacuumInterval, now)
+	if err != nil {
+		j.logger.WithError(err).Warn("Cache compaction failed")
+	} else if vacuumed {
+		j.logger.Info("Vacuumed cache")
+	}
+}
+
+// lastEviction parses the settingLastEviction value
+func (s *Store) lastEviction() (*time.Time, *EvictionResult, error) {
+	value, err := s.cache.setting(settingLastEviction)
+	if err != nil || value == "" {
+		return nil, nil, err
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) != 4 {
+		return nil, nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, fields[0])
+	if err != nil {
+		return nil, nil, nil
+	}
+	result := &EvictionResult{}
+	result.BodiesDropped, _ = strconv.Atoi(fields[1])
+	result.MessagesDeleted, _ = strconv.Atoi(fields[2])
+	result.BytesFreed, _ = strconv.ParseInt(fields[3], 10, 64)
+	return &t, result, nil
+}
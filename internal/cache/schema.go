@@ -1,7 +1,8 @@
 package cache
 
-// Schema contains SQL schema definitions for the cache
-const Schema = `
+// baseSchema is the schema of the first release, applied by migration 1.
+// Later changes are migrations; see migrations.go.
+const baseSchema = `
 -- Accounts table
 CREATE TABLE IF NOT EXISTS accounts (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
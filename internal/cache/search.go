@@ -2,174 +2,325 @@ package cache
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/brandon/mcp-email/pkg/types"
 )
 
+// Result orderings accepted by SearchOptions.OrderBy
+const (
+	OrderRelevance = "relevance"
+	OrderDate      = "date"
+)
+
+// snippetLength is the maximum snippet length in characters
+const snippetLength = 200
+
+// Highlight markers wrapped around matched terms in snippets and subjects
+const (
+	highlightOpen  = "**"
+	highlightClose = "**"
+)
+
+// countCap bounds the rows counted for a search's total; larger totals are
+// reported as estimates
+const countCap = 10000
+
+// bm25Weights weights the emails_fts columns (subject, sender_email,
+// sender_name, body_text) so subject and sender matches rank above body matches
+const bm25Weights = "10.0, 5.0, 5.0, 1.0"
+
 // SearchOptions contains search parameters
 type SearchOptions struct {
 	AccountID *int
 	FolderID  *int
-	Sender    *string
-	Recipient *string
-	Subject   *string
-	Body      *string
-	DateFrom  *time.Time
-	DateTo    *time.Time
-	Limit     int
+	// FolderIDs restricts results to any of the given folders
+	FolderIDs []int
+	// ExcludeFolderIDs drops results from the given folders
+	ExcludeFolderIDs []int
+	Sender           *string
+	Recipient        *string
+	Subject          *string
+	Body             *string
+	DateFrom         *time.Time
+	DateTo           *time.Time
+	// Query is a compiled Gmail-style query (see ParseQuery)
+	Query *Query
+	// EmailIDs, when not nil, restricts results to the given emails
+	EmailIDs []int64
+	// OrderBy is OrderRelevance or OrderDate. Relevance is the default when
+	// the search has full-text terms, date otherwise. Only date order pages
+	// exactly; see searchCursor.
+	OrderBy string
+	// Cursor continues a previous search from its NextCursor
+	Cursor string
+	Limit  int
+}
+
+// SearchResult is one page of search results
+type SearchResult struct {
+	Emails []types.EmailSummary
+	// NextCursor fetches the following page, or "" on the last page
+	NextCursor string
+	// Total is the number of matching emails, counted up to a cap;
+	// TotalExact is false when the cap was reached
+	Total      int
+	TotalExact bool
 }
 
-// Search performs a search on cached emails
-func (s *Store) Search(opts SearchOptions) ([]types.EmailSummary, error) {
-	var conditions []string
-	var args []interface{}
+// searchCursor is the position after the last row of a page. It is keyed on
+// date and id (plus the bm25 score for relevance order), and MaxID freezes
+// the result set at the emails cached when the first page was fetched, so
+// rows synced later neither shift pages nor change the total. Date order
+// pages are then exact. Relevance order is best effort: bm25 scores depend
+// on the whole index, so a sync between pages can move a row across the
+// cursor, repeating or skipping it.
+type searchCursor struct {
+	Order string   `json:"o"`
+	Date  string   `json:"d"`
+	ID    int64    `json:"i"`
+	Score *float64 `json:"s,omitempty"`
+	MaxID int64    `json:"m,omitempty"`
+}
+
+// encode returns the cursor as an opaque URL-safe token
+func (c *searchCursor) encode() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a token produced by searchCursor.encode
+func decodeCursor(token string) (*searchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var c searchCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Date == "" || c.ID == 0 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &c, nil
+}
+
+// searchFilter holds the FROM and WHERE parts shared by searches and aggregates
+type searchFilter struct {
+	conditions []string
+	args       []interface{}
+	matches    []string
+}
+
+// filter builds the conditions selecting emails that match opts
+func (opts *SearchOptions) filter() *searchFilter {
+	f := &searchFilter{}
 
 	// Build WHERE clause
 	if opts.AccountID != nil {
-		conditions = append(conditions, "e.account_id = ?")
-		args = append(args, *opts.AccountID)
+		f.conditions = append(f.conditions, "e.account_id = ?")
+		f.args = append(f.args, *opts.AccountID)
 	}
 
+	// Folder filters match a message held in any of the folders; excluded
+	// folders drop a message only when it is in no other folder
 	if opts.FolderID != nil {
-		conditions = append(conditions, "e.folder_id = ?")
-		args = append(args, *opts.FolderID)
+		f.conditions = append(f.conditions, "e.id IN (SELECT email_id FROM email_locations WHERE folder_id = ?)")
+		f.args = append(f.args, *opts.FolderID)
+	}
+
+	if len(opts.FolderIDs) > 0 {
+		f.conditions = append(f.conditions, "e.id IN (SELECT email_id FROM email_locations WHERE folder_id IN ("+
+			placeholders(len(opts.FolderIDs))+"))")
+		for _, id := range opts.FolderIDs {
+			f.args = append(f.args, id)
+		}
+	}
+
+	if len(opts.ExcludeFolderIDs) > 0 {
+		f.conditions = append(f.conditions, "e.id IN (SELECT email_id FROM email_locations WHERE folder_id NOT IN ("+
+			placeholders(len(opts.ExcludeFolderIDs))+"))")
+		for _, id := range opts.ExcludeFolderIDs {
+			f.args = append(f.args, id)
+		}
+	}
+
+	if opts.EmailIDs != nil {
+		if len(opts.EmailIDs) == 0 {
+			f.conditions = append(f.conditions, "0")
+		} else {
+			f.conditions = append(f.conditions, "e.id IN ("+placeholders(len(opts.EmailIDs))+")")
+			for _, id := range opts.EmailIDs {
+				f.args = append(f.args, id)
+			}
+		}
 	}
 
 	if opts.Sender != nil {
-		conditions = append(conditions, "(e.sender_email LIKE ? OR e.sender_name LIKE ?)")
+		f.conditions = append(f.conditions, "(e.sender_email LIKE ? OR e.sender_name LIKE ?)")
 		searchTerm := "%" + *opts.Sender + "%"
-		args = append(args, searchTerm, searchTerm)
+		f.args = append(f.args, searchTerm, searchTerm)
 	}
 
 	if opts.Recipient != nil {
-		conditions = append(conditions, "e.recipients LIKE ?")
-		args = append(args, "%"+*opts.Recipient+"%")
+		f.conditions = append(f.conditions, "e.recipients LIKE ?")
+		f.args = append(f.args, "%"+*opts.Recipient+"%")
 	}
 
 	if opts.Subject != nil {
-		conditions = append(conditions, "e.subject LIKE ?")
-		args = append(args, "%"+*opts.Subject+"%")
+		f.conditions = append(f.conditions, "e.subject LIKE ?")
+		f.args = append(f.args, "%"+*opts.Subject+"%")
 	}
 
 	if opts.DateFrom != nil {
-		conditions = append(conditions, "e.date >= ?")
-		args = append(args, opts.DateFrom)
+		f.conditions = append(f.conditions, "e.date >= ?")
+		f.args = append(f.args, opts.DateFrom)
 	}
 
 	if opts.DateTo != nil {
-		conditions = append(conditions, "e.date <= ?")
-		args = append(args, opts.DateTo)
+		f.conditions = append(f.conditions, "e.date <= ?")
+		f.args = append(f.args, opts.DateTo)
 	}
 
 	// Full-text search on body
 	if opts.Body != nil {
-		// Use FTS5 for body search
-		conditions = append(conditions, "e.id IN (SELECT rowid FROM emails_fts WHERE emails_fts MATCH ?)")
-		// Escape special characters for FTS5
-		bodyQuery := strings.ReplaceAll(*opts.Body, "\"", "\"\"")
-		bodyQuery = strings.ReplaceAll(bodyQuery, "'", "''")
-		args = append(args, bodyQuery)
+		if match := ftsWords(*opts.Body); match != "" {
+			f.matches = append(f.matches, match)
+		}
 	}
 
-	whereClause := ""
-	if len(conditions) > 0 {
-		whereClause = "WHERE " + strings.Join(conditions, " AND ")
+	if opts.Query != nil {
+		if opts.Query.Where != "" {
+			f.conditions = append(f.conditions, opts.Query.Where)
+			f.args = append(f.args, opts.Query.Args...)
+		}
+		if opts.Query.Match != "" {
+			f.matches = append(f.matches, opts.Query.Match)
+		}
 	}
 
-	// Set default limit
-	limit := opts.Limit
-	if limit <= 0 {
-		limit = 100
+	return f
+}
+
+// match returns the combined FTS5 expression, or "" without full-text terms
+func (f *searchFilter) match() string {
+	return strings.Join(f.matches, " AND ")
+}
+
+// from returns the FROM clause over emails e, joining the full-text index
+// when there are full-text terms
+func (f *searchFilter) from() string {
+	if len(f.matches) == 0 {
+		return "FROM emails e"
 	}
-	if limit > 1000 {
-		limit = 1000
+	return "FROM emails e JOIN emails_fts ON emails_fts.rowid = e.id AND emails_fts MATCH ?"
+}
+
+// where returns the WHERE clause, or "" without conditions
+func (f *searchFilter) where() string {
+	if len(f.conditions) == 0 {
+		return ""
 	}
+	return "WHERE " + strings.Join(f.conditions, " AND ")
+}
 
-	query := fmt.Sprintf(`
-		SELECT e.id, a.name, f.path, e.subject, e.sender_name, e.sender_email, e.date, e.body_text
-		FROM emails e
-		JOIN accounts a ON e.account_id = a.id
-		JOIN folders f ON e.folder_id = f.id
-		%s
-		ORDER BY e.date DESC
-		LIMIT ?
-	`, whereClause)
+// queryArgs returns the arguments for a query built from from() and where()
+func (f *searchFilter) queryArgs() []interface{} {
+	if len(f.matches) == 0 {
+		return append([]interface{}{}, f.args...)
+	}
+	return append([]interface{}{f.match()}, f.args...)
+}
 
-	args = append(args, limit)
+// Search performs a search on cached emails, returning one page of results
+func (s *Store) Search(opts SearchOptions) (*SearchResult, error) {
+	f := opts.filter()
+	matched := len(f.matches) > 0
+
+	// Full-text terms are matched through a join so they can be ranked and
+	// highlighted; everything else filters the joined rows
+	fromClause := f.from()
+	ftsColumns := "NULL, NULL, NULL"
+	if matched {
+		ftsColumns = fmt.Sprintf(
+			"bm25(emails_fts, %s), snippet(emails_fts, 3, '%s', '%s', '...', 24), highlight(emails_fts, 0, '%s', '%s')",
+			bm25Weights, highlightOpen, highlightClose, highlightOpen, highlightClose)
+	}
 
-	rows, err := s.cache.DB().Query(query, args...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to search emails: %w", err)
+	orderBy := opts.OrderBy
+	if orderBy == "" {
+		orderBy = OrderDate
+		if matched {
+			orderBy = OrderRelevance
+		}
+	}
+	if orderBy != OrderRelevance && orderBy != OrderDate {
+		return nil, fmt.Errorf("invalid order: %s (expected %s or %s)", orderBy, OrderRelevance, OrderDate)
 	}
-	defer rows.Close()
 
-	var results []types.EmailSummary
-	for rows.Next() {
-		var summary types.EmailSummary
-		var dateStr string
-		var bodyText sql.NullString
+	if orderBy == OrderRelevance && !matched {
+		orderBy = OrderDate
+	}
 
-		err := rows.Scan(
-			&summary.ID,
-			&summary.AccountName,
-			&summary.FolderPath,
-			&summary.Subject,
-			&summary.SenderName,
-			&summary.SenderEmail,
-			&dateStr,
-			&bodyText,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan email: %w", err)
-		}
+	rank := fmt.Sprintf("bm25(emails_fts, %s)", bm25Weights)
+	orderClause := "ORDER BY e.date DESC, e.id DESC"
+	if orderBy == OrderRelevance {
+		orderClause = "ORDER BY " + rank + ", e.date DESC, e.id DESC"
+	}
 
-		// Parse date
-		summary.Date, err = time.Parse("2006-01-02 15:04:05", dateStr)
-		if err != nil {
-			summary.Date, err = time.Parse(time.RFC3339, dateStr)
-			if err != nil {
-				summary.Date = time.Time{}
-			}
+	var cursor *searchCursor
+	if opts.Cursor != "" {
+		var err error
+		if cursor, err = decodeCursor(opts.Cursor); err != nil {
+			return nil, err
 		}
-
-		// Create snippet from body
-		if bodyText.Valid && len(bodyText.String) > 0 {
-			snippet := bodyText.String
-			if len(snippet) > 200 {
-				snippet = snippet[:200] + "..."
-			}
-			summary.Snippet = snippet
+		if cursor.Order != orderBy {
+			return nil, fmt.Errorf("cursor was created for %s order, not %s", cursor.Order, orderBy)
 		}
-
-		results = append(results, summary)
 	}
 
-	return results, nil
-}
+	// Later pages see the emails cached when the first page was fetched
+	var maxID int64
+	if cursor != nil {
+		maxID = cursor.MaxID
+	} else if err := s.cache.Reader().QueryRow("SELECT COALESCE(MAX(id), 0) FROM emails").Scan(&maxID); err != nil {
+		return nil, fmt.Errorf("failed to search emails: %w", err)
+	}
+	if maxID > 0 {
+		f.conditions = append(f.conditions, "e.id <= ?")
+		f.args = append(f.args, maxID)
+	}
 
-// SearchFTS performs a full-text search using FTS5
-func (s *Store) SearchFTS(query string, accountID *int, limit int) ([]types.EmailSummary, error) {
-	var conditions []string
-	var args []interface{}
+	// The total ignores the cursor so it stays the same on every page
+	total, exact, err := s.countSearch(f)
+	if err != nil {
+		return nil, err
+	}
 
-	// Escape query for FTS5
-	query = strings.ReplaceAll(query, "\"", "\"\"")
-	query = strings.ReplaceAll(query, "'", "''")
+	// Continue after the cursor position
+	if cursor != nil {
 
-	// FTS5 search
-	conditions = append(conditions, "e.id IN (SELECT rowid FROM emails_fts WHERE emails_fts MATCH ?)")
-	args = append(args, query)
+		after := "(e.date < ? OR (e.date = ? AND e.id < ?))"
+		afterArgs := []interface{}{cursor.Date, cursor.Date, cursor.ID}
+		if orderBy == OrderRelevance {
+			if cursor.Score == nil {
+				return nil, fmt.Errorf("invalid cursor")
+			}
+			after = "(" + rank + " > ? OR (" + rank + " = ? AND " + after + "))"
+			afterArgs = append([]interface{}{*cursor.Score, *cursor.Score}, afterArgs...)
+		}
 
-	if accountID != nil {
-		conditions = append(conditions, "e.account_id = ?")
-		args = append(args, *accountID)
+		f.conditions = append(f.conditions, after)
+		f.args = append(f.args, afterArgs...)
 	}
 
-	whereClause := "WHERE " + strings.Join(conditions, " AND ")
-
+	// Set default limit
+	limit := opts.Limit
 	if limit <= 0 {
 		limit = 100
 	}
@@ -177,44 +328,71 @@ func (s *Store) SearchFTS(query string, accountID *int, limit int) ([]types.Emai
 		limit = 1000
 	}
 
-	sqlQuery := fmt.Sprintf(`
-		SELECT e.id, a.name, f.path, e.subject, e.sender_name, e.sender_email, e.date, e.body_text
-		FROM emails e
+	query := fmt.Sprintf(`
+		SELECT e.id, a.name, %s, e.subject, e.sender_name, e.sender_email, e.date, CAST(e.date AS TEXT),
+			e.thread_id, e.body_text, %s
+		%s
 		JOIN accounts a ON e.account_id = a.id
-		JOIN folders f ON e.folder_id = f.id
 		%s
-		ORDER BY e.date DESC
+		%s
 		LIMIT ?
-	`, whereClause)
+	`, emailLocations, ftsColumns, fromClause, f.where(), orderClause)
 
-	args = append(args, limit)
+	// Fetch one extra row to learn whether another page follows
+	args := append(f.queryArgs(), limit+1)
 
-	rows, err := s.cache.DB().Query(sqlQuery, args...)
+	rows, err := s.cache.Reader().Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to perform FTS search: %w", err)
+		return nil, fmt.Errorf("failed to search emails: %w", err)
 	}
 	defer rows.Close()
 
-	var results []types.EmailSummary
+	result := &SearchResult{Total: total, TotalExact: exact}
+	var last *searchCursor
 	for rows.Next() {
+		if len(result.Emails) == limit {
+			result.NextCursor = last.encode()
+			break
+		}
+
 		var summary types.EmailSummary
-		var dateStr string
-		var bodyText sql.NullString
+		var locationsJSON, dateStr, dateKey string
+		var bodyText, snippet, subject sql.NullString
+		var score sql.NullFloat64
+		var threadID sql.NullInt64
 
 		err := rows.Scan(
 			&summary.ID,
 			&summary.AccountName,
-			&summary.FolderPath,
+			&locationsJSON,
 			&summary.Subject,
 			&summary.SenderName,
 			&summary.SenderEmail,
 			&dateStr,
+			&dateKey,
+			&threadID,
 			&bodyText,
+			&score,
+			&snippet,
+			&subject,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan email: %w", err)
 		}
 
+		summary.ThreadID = threadID.Int64
+
+		locations, err := parseLocations(locationsJSON)
+		if err != nil {
+			return nil, err
+		}
+		for _, loc := range locations {
+			summary.Folders = append(summary.Folders, loc.FolderPath)
+		}
+		if len(summary.Folders) > 0 {
+			summary.FolderPath = summary.Folders[0]
+		}
+
 		// Parse date
 		summary.Date, err = time.Parse("2006-01-02 15:04:05", dateStr)
 		if err != nil {
@@ -224,17 +402,103 @@ func (s *Store) SearchFTS(query string, accountID *int, limit int) ([]types.Emai
 			}
 		}
 
-		// Create snippet
-		if bodyText.Valid && len(bodyText.String) > 0 {
-			snippet := bodyText.String
-			if len(snippet) > 200 {
-				snippet = snippet[:200] + "..."
+		// bm25 scores are negative, with lower being better; flip the sign
+		// so callers see higher scores for better matches
+		if score.Valid {
+			summary.Score = -score.Float64
+		}
+
+		// Prefer the FTS snippet showing matched body terms in context
+		if snippet.Valid && strings.Contains(snippet.String, highlightOpen) {
+			summary.Snippet = snippet.String
+		} else if bodyText.Valid {
+			text, err := s.cache.cipher.Decrypt(columnBodyText, bodyText.String)
+			if err != nil {
+				return nil, err
 			}
-			summary.Snippet = snippet
+			summary.Snippet = makeSnippet(text, snippetLength)
+		}
+
+		if subject.Valid && subject.String != summary.Subject {
+			summary.HighlightedSubject = subject.String
+		}
+
+		// The cursor keeps the date as stored so comparisons match exactly
+		last = &searchCursor{Order: orderBy, Date: dateKey, ID: summary.ID, MaxID: maxID}
+		if orderBy == OrderRelevance && score.Valid {
+			value := score.Float64
+			last.Score = &value
 		}
 
-		results = append(results, summary)
+		result.Emails = append(result.Emails, summary)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read search results: %w", err)
+	}
+
+	return result, nil
+}
+
+// countSearch counts matching emails up to countCap
+func (s *Store) countSearch(f *searchFilter) (int, bool, error) {
+	fromClause := f.from()
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) FROM (
+			SELECT 1
+			%s
+			%s
+			LIMIT %d
+		)
+	`, fromClause, f.where(), countCap+1)
+
+	var count int
+	if err := s.cache.Reader().QueryRow(query, f.queryArgs()...).Scan(&count); err != nil {
+		return 0, false, fmt.Errorf("failed to count search results: %w", err)
+	}
+
+	if count > countCap {
+		return countCap, false, nil
+	}
+	return count, true, nil
+}
+
+// SearchFTS performs a full-text search using FTS5, ranked by relevance
+func (s *Store) SearchFTS(query string, accountID *int, limit int) ([]types.EmailSummary, error) {
+	result, err := s.Search(SearchOptions{
+		AccountID: accountID,
+		Body:      &query,
+		OrderBy:   OrderRelevance,
+		Limit:     limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Emails, nil
+}
+
+// ftsWords turns free text into an FTS5 expression matching every word,
+// quoting each one so FTS5 operators and punctuation are taken literally
+func ftsWords(text string) string {
+	words := strings.Fields(text)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// makeSnippet collapses whitespace and truncates text to at most max
+// characters without splitting multi-byte characters
+func makeSnippet(text string, max int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+
+	runes := []rune(text)
+	return strings.TrimRight(string(runes[:max]), " ") + "..."
+}
 
-	return results, nil
+// placeholders returns n comma-separated SQL parameter placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
 }
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/internal/config"
+	"github.com/brandon/mcp-email/pkg/types"
+)
+
+func TestSearchCursorDuringSync(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	c, err := NewCache(filepath.Join(t.TempDir(), "cache.db"), nil, logger)
+	if err != nil {
+		t.Fatalf("NewCache() error = %v", err)
+	}
+	defer c.Close()
+	store := NewStore(c, logger)
+
+	accountID, err := store.UpsertAccount(&config.AccountConfig{Name: "test", IMAPHost: "localhost", SMTPHost: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	folderID, err := store.UpsertFolder(&types.Folder{AccountID: accountID, Name: "INBOX", Path: "INBOX"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	nextUID := uint32(1)
+	sync := func(days ...int) {
+		t.Helper()
+		var emails []*types.Email
+		for _, day := range days {
+			emails = append(emails, &types.Email{
+				AccountID:   accountID,
+				FolderID:    folderID,
+				UID:         nextUID,
+				MessageID:   fmt.Sprintf("<%d@example.com>", nextUID),
+				Subject:     fmt.Sprintf("report %d", nextUID),
+				SenderEmail: "alice@example.com",
+				Recipients:  []string{"me@example.com"},
+				Date:        base.AddDate(0, 0, day),
+				BodyText:    "quarterly report",
+				Headers:     map[string][]string{},
+				Flags:       []string{},
+			})
+			nextUID++
+		}
+		if _, err := store.UpsertEmails(emails); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sync(0, 1, 2, 3, 4)
+
+	for _, order := range []string{OrderDate, OrderRelevance} {
+		t.Run(order, func(t *testing.T) {
+			query, err := ParseQuery("report")
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := SearchOptions{Query: query, OrderBy: order, Limit: 2}
+			seen := make(map[int64]bool)
+			total := 0
+			for page := 0; ; page++ {
+				result, err := store.Search(opts)
+				if err != nil {
+					t.Fatalf("Search() page %d error = %v", page, err)
+				}
+				if page == 0 {
+					total = result.Total
+				} else if result.Total != total {
+					t.Errorf("page %d total = %d, want %d as on the first page", page, result.Total, total)
+				}
+				for _, email := range result.Emails {
+					if seen[email.ID] {
+						t.Errorf("page %d repeats email %d", page, email.ID)
+					}
+					seen[email.ID] = true
+				}
+				if result.NextCursor == "" {
+					break
+				}
+				opts.Cursor = result.NextCursor
+
+				// Mail newer and older than the pages so far arrives
+				sync(10, -10)
+			}
+			if len(seen) != total {
+				t.Errorf("paged through %d emails, want the %d cached at the first page", len(seen), total)
+			}
+		})
+	}
+}
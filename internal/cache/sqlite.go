@@ -3,69 +3,177 @@ package cache
 import (
 	"database/sql"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	_ "modernc.org/sqlite"
+
+	"github.com/brandon/mcp-email/internal/config"
 )
 
-// Cache represents the SQLite cache
+// busyTimeout is how long, in milliseconds, a connection waits for a lock
+// held by another process (such as the migrate command) before failing
+const busyTimeout = 5000
+
+// Cache represents the SQLite cache. SQLite allows a single writer at a
+// time, so writes share one connection; in WAL mode readers do not block
+// the writer or each other and get a pool of their own.
 type Cache struct {
 	db     *sql.DB
+	reader *sql.DB
+	path   string
+	cipher *Cipher
 	logger *logrus.Logger
 }
 
-// NewCache creates a new cache instance
-func NewCache(dbPath string, logger *logrus.Logger) (*Cache, error) {
+// NewCache opens the cache and migrates it to the latest schema version.
+// A nil or disabled encryption config leaves cached content unencrypted.
+func NewCache(dbPath string, encryption *config.EncryptionConfig, logger *logrus.Logger) (*Cache, error) {
+	cache, err := OpenCache(dbPath, encryption, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bring the schema up to date
+	if _, err := cache.Migrate(false); err != nil {
+		cache.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
+	logger.WithField("path", dbPath).Info("Cache initialized")
+	return cache, nil
+}
+
+// OpenCache opens the cache without migrating it
+func OpenCache(dbPath string, encryption *config.EncryptionConfig, logger *logrus.Logger) (*Cache, error) {
 	// Ensure directory exists
 	dir := filepath.Dir(dbPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
-	// Open database
-	db, err := sql.Open("sqlite", dbPath)
+	// Open the writer first so the database is switched to WAL before any
+	// reader connects. Pragmas go in the DSN so every connection gets them.
+	// New databases are created in incremental auto-vacuum mode so space
+	// freed by eviction can be returned without a full VACUUM.
+	db, err := sql.Open("sqlite", sqliteDSN(dbPath,
+		fmt.Sprintf("busy_timeout(%d)", busyTimeout),
+		"auto_vacuum(INCREMENTAL)",
+		"journal_mode(WAL)",
+		"synchronous(NORMAL)",
+		"foreign_keys(1)",
+	))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
-
-	// Enable foreign keys
-	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
-		return nil, fmt.Errorf("failed to enable foreign keys: %w", err)
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	cache := &Cache{
 		db:     db,
+		reader: db,
+		path:   dbPath,
 		logger: logger,
 	}
 
-	// Initialize schema
-	if err := cache.initSchema(); err != nil {
-		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+	if err := cache.configureEncryption(encryption); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	logger.WithField("path", dbPath).Info("Cache initialized")
+	// Each connection to an in-memory database is a separate database
+	if isMemoryPath(dbPath) {
+		return cache, nil
+	}
+
+	cache.reader, err = sql.Open("sqlite", sqliteDSN(dbPath,
+		fmt.Sprintf("busy_timeout(%d)", busyTimeout),
+		"foreign_keys(1)",
+		"query_only(1)",
+	))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database for reading: %w", err)
+	}
+	cache.reader.SetMaxOpenConns(max(4, runtime.NumCPU()))
+
 	return cache, nil
 }
 
-// initSchema initializes the database schema
-func (c *Cache) initSchema() error {
-	if _, err := c.db.Exec(Schema); err != nil {
-		return fmt.Errorf("failed to create schema: %w", err)
+// OpenCacheReadOnly opens an existing cache to inspect its schema without
+// changing it: the file is not created, its journal mode is left alone and
+// encryption is not set up, so encrypted content cannot be read. Like any
+// reader of a WAL database, it may leave empty -wal and -shm files.
+func OpenCacheReadOnly(dbPath string, logger *logrus.Logger) (*Cache, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
-	return nil
+
+	db, err := sql.Open("sqlite", "file:"+sqliteDSN(dbPath,
+		fmt.Sprintf("busy_timeout(%d)", busyTimeout),
+		"query_only(1)",
+	)+"&mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	return &Cache{
+		db:     db,
+		reader: db,
+		path:   dbPath,
+		logger: logger,
+	}, nil
 }
 
-// Close closes the database connection
+// sqliteDSN appends connection pragmas to a database path
+func sqliteDSN(path string, pragmas ...string) string {
+	values := url.Values{}
+	for _, pragma := range pragmas {
+		values.Add("_pragma", pragma)
+	}
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + values.Encode()
+}
+
+// isMemoryPath reports whether path names an in-memory database
+func isMemoryPath(path string) bool {
+	return path == ":memory:" || strings.HasPrefix(path, "file::memory:") || strings.Contains(path, "mode=memory")
+}
+
+// Close closes the database connections
 func (c *Cache) Close() error {
+	if c.reader != nil && c.reader != c.db {
+		c.reader.Close()
+	}
 	if c.db != nil {
 		return c.db.Close()
 	}
 	return nil
 }
 
-// DB returns the underlying database connection (for use in store.go)
+// DB returns the connection used for writes (for use in store.go). It
+// holds a single connection: do not query it while a transaction or an
+// unclosed result set on it is open.
 func (c *Cache) DB() *sql.DB {
 	return c.db
 }
+
+// Reader returns the pool used for reads; it cannot write
+func (c *Cache) Reader() *sql.DB {
+	return c.reader
+}
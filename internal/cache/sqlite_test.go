@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestOpenCacheReadOnly(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	// A missing cache is not created
+	if c, err := OpenCacheReadOnly(path, logger); err == nil {
+		c.Close()
+		t.Fatalf("OpenCacheReadOnly() of a missing cache succeeded")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("OpenCacheReadOnly() created the cache")
+	}
+
+	c, err := NewCache(path, nil, logger)
+	if err != nil {
+		t.Fatalf("NewCache() error = %v", err)
+	}
+	c.Close()
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = OpenCacheReadOnly(path, logger)
+	if err != nil {
+		t.Fatalf("OpenCacheReadOnly() error = %v", err)
+	}
+	version, err := c.SchemaVersion()
+	if err != nil || version != LatestSchemaVersion() {
+		t.Errorf("SchemaVersion() = %d, %v, want %d", version, err, LatestSchemaVersion())
+	}
+	if pending, err := c.Migrate(true); err != nil || len(pending) != 0 {
+		t.Errorf("Migrate(true) = %v, %v, want nothing pending", pending, err)
+	}
+	if _, err := c.DB().Exec("PRAGMA user_version = 1"); err == nil {
+		t.Errorf("writing to a read-only cache succeeded")
+	}
+	c.Close()
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Errorf("the cache changed while opened read-only")
+	}
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Histogram intervals accepted by MailboxStats
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+	IntervalYear  = "year"
+)
+
+// unreadExpr is 1 for emails without the \Seen flag, 0 otherwise
+const unreadExpr = `CASE WHEN EXISTS (SELECT 1 FROM json_each(e.flags) WHERE json_each.value = '\Seen') THEN 0 ELSE 1 END`
+
+// intervalExprs buckets e.date by interval. Dates are stored starting with
+// YYYY-MM-DD in the message's own timezone; weeks start on Monday.
+var intervalExprs = map[string]string{
+	IntervalDay:   "substr(e.date, 1, 10)",
+	IntervalWeek:  "date(substr(e.date, 1, 10), '-6 days', 'weekday 1')",
+	IntervalMonth: "substr(e.date, 1, 7)",
+	IntervalYear:  "substr(e.date, 1, 4)",
+}
+
+// Facet is a group of matching emails
+type Facet struct {
+	Value   string `json:"value"`
+	Name    string `json:"name,omitempty"`
+	Role    string `json:"role,omitempty"`
+	Account string `json:"account,omitempty"`
+	Count   int    `json:"count"`
+	Unread  int    `json:"unread"`
+}
+
+// MailboxStats aggregates the emails matching a search
+type MailboxStats struct {
+	Total           int     `json:"total"`
+	Unread          int     `json:"unread"`
+	WithAttachments int     `json:"with_attachments"`
+	Attachments     int     `json:"attachments"`
+	Senders         []Facet `json:"top_senders"`
+	Domains         []Facet `json:"top_domains"`
+	Folders         []Facet `json:"folders"`
+	Interval        string  `json:"interval"`
+	Histogram       []Facet `json:"histogram"`
+}
+
+// MailboxStats computes facets over the emails matching opts: totals, the
+// top senders and sender domains, per-folder counts and a date histogram.
+// Cursor, ordering and limit options are ignored.
+func (s *Store) MailboxStats(opts SearchOptions, interval string, top int) (*MailboxStats, error) {
+	bucket, ok := intervalExprs[interval]
+	if !ok {
+		return nil, fmt.Errorf("invalid interval: %s (expected day, week, month or year)", interval)
+	}
+	if top <= 0 {
+		top = 10
+	}
+
+	f := opts.filter()
+	stats := &MailboxStats{Interval: interval}
+
+	query := fmt.Sprintf(`
+		SELECT COUNT(*),
+			COALESCE(SUM(%s), 0),
+			COALESCE(SUM(e.attachment_count > 0), 0),
+			COALESCE(SUM(e.attachment_count), 0)
+		%s
+		%s
+	`, unreadExpr, f.from(), f.where())
+	err := s.cache.Reader().QueryRow(query, f.queryArgs()...).Scan(
+		&stats.Total,
+		&stats.Unread,
+		&stats.WithAttachments,
+		&stats.Attachments,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute totals: %w", err)
+	}
+
+	facets := []struct {
+		target  *[]Facet
+		columns string
+		joins   string
+		group   string
+		order   string
+		limit   bool
+	}{
+		{
+			target:  &stats.Senders,
+			columns: "lower(e.sender_email), MAX(e.sender_name), '', ''",
+			group:   "lower(e.sender_email)",
+			order:   "COUNT(*) DESC, 1",
+			limit:   true,
+		},
+		{
+			target:  &stats.Domains,
+			columns: "lower(substr(e.sender_email, instr(e.sender_email, '@') + 1)), '', '', ''",
+			group:   "1",
+			order:   "COUNT(*) DESC, 1",
+			limit:   true,
+		},
+		{
+			target:  &stats.Folders,
+			columns: "f.path, '', f.special_use, a.name",
+			joins:   "JOIN email_locations l ON l.email_id = e.id JOIN folders f ON l.folder_id = f.id JOIN accounts a ON e.account_id = a.id",
+			group:   "l.folder_id",
+			order:   "COUNT(*) DESC, a.name, f.path",
+		},
+		{
+			target:  &stats.Histogram,
+			columns: bucket + ", '', '', ''",
+			group:   "1",
+			order:   "1",
+		},
+	}
+
+	for _, facet := range facets {
+		query := fmt.Sprintf(`
+			SELECT %s, COUNT(*), SUM(%s)
+			%s
+			%s
+			%s
+			GROUP BY %s
+			ORDER BY %s
+		`, facet.columns, unreadExpr, f.from(), facet.joins, f.where(), facet.group, facet.order)
+
+		args := f.queryArgs()
+		if facet.limit {
+			query += " LIMIT ?"
+			args = append(args, top)
+		}
+
+		values, err := s.queryFacets(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		*facet.target = values
+	}
+
+	return stats, nil
+}
+
+// queryFacets runs a facet query returning value, name, role, account,
+// count and unread columns
+func (s *Store) queryFacets(query string, args ...interface{}) ([]Facet, error) {
+	rows, err := s.cache.Reader().Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute facets: %w", err)
+	}
+	defer rows.Close()
+
+	facets := []Facet{}
+	for rows.Next() {
+		var facet Facet
+		var value, name, role, account sql.NullString
+		if err := rows.Scan(&value, &name, &role, &account, &facet.Count, &facet.Unread); err != nil {
+			return nil, fmt.Errorf("failed to scan facet: %w", err)
+		}
+		facet.Value = value.String
+		facet.Name = name.String
+		facet.Role = role.String
+		facet.Account = account.String
+		facets = append(facets, facet)
+	}
+
+	return facets, rows.Err()
+}
@@ -1,9 +1,13 @@
 package cache
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -16,6 +20,15 @@ import (
 type Store struct {
 	cache  *Cache
 	logger *logrus.Logger
+
+	mu    sync.Mutex
+	stmts map[stmtKey]*sql.Stmt
+}
+
+// stmtKey identifies a prepared statement by connection pool and query
+type stmtKey struct {
+	db    *sql.DB
+	query string
 }
 
 // NewStore creates a new store instance
@@ -23,7 +36,27 @@ func NewStore(cache *Cache, logger *logrus.Logger) *Store {
 	return &Store{
 		cache:  cache,
 		logger: logger,
+		stmts:  make(map[stmtKey]*sql.Stmt),
+	}
+}
+
+// prepare returns query prepared on db, preparing it on first use. Hot
+// queries are prepared once and reused for the lifetime of the cache.
+func (s *Store) prepare(db *sql.DB, query string) (*sql.Stmt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := stmtKey{db: db, query: query}
+	if stmt, ok := s.stmts[key]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare statement: %w", err)
 	}
+	s.stmts[key] = stmt
+	return stmt, nil
 }
 
 // UpsertAccount upserts an account in the cache
@@ -61,117 +94,328 @@ func (s *Store) UpsertAccount(acc *config.AccountConfig) (int, error) {
 
 // GetAccountID returns the account ID by name
 func (s *Store) GetAccountID(name string) (int, error) {
-	var id int
-	err := s.cache.DB().QueryRow("SELECT id FROM accounts WHERE name = ?", name).Scan(&id)
+	stmt, err := s.prepare(s.cache.Reader(), "SELECT id FROM accounts WHERE name = ?")
 	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	if err := stmt.QueryRow(name).Scan(&id); err != nil {
 		return 0, fmt.Errorf("account not found: %s", name)
 	}
 	return id, nil
 }
 
-// UpsertFolder upserts a folder in the cache
-func (s *Store) UpsertFolder(accountID int, name, path string, messageCount int) (int, error) {
+// RelocateEmail points the cached email at a folder and UID to its new
+// folder and UID after a move
+func (s *Store) RelocateEmail(folderID int, uid uint32, destFolderID int, destUID uint32) error {
+	tx, err := s.cache.DB().Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	// Drop any stale location already occupying the destination slot
+	if _, err := tx.Exec("DELETE FROM email_locations WHERE folder_id = ? AND uid = ?", destFolderID, destUID); err != nil {
+		return fmt.Errorf("failed to clear destination: %w", err)
+	}
+
+	_, err = tx.Exec("UPDATE email_locations SET folder_id = ?, uid = ? WHERE folder_id = ? AND uid = ?",
+		destFolderID, destUID, folderID, uid)
+	if err != nil {
+		return fmt.Errorf("failed to relocate email: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// CopyEmail records a copy of a cached email in another folder under a new UID
+func (s *Store) CopyEmail(emailID int64, folderID int, uid uint32) error {
 	query := `
-		INSERT INTO folders (account_id, name, path, message_count, last_synced)
-		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
-		ON CONFLICT(account_id, path) DO UPDATE SET
-			name = excluded.name,
-			message_count = excluded.message_count,
-			last_synced = CURRENT_TIMESTAMP
+		INSERT INTO email_locations (email_id, folder_id, uid) VALUES (?, ?, ?)
+		ON CONFLICT(folder_id, uid) DO UPDATE SET email_id = excluded.email_id
 	`
-	result, err := s.cache.DB().Exec(query, accountID, name, path, messageCount)
+	if _, err := s.cache.DB().Exec(query, emailID, folderID, uid); err != nil {
+		return fmt.Errorf("failed to copy email: %w", err)
+	}
+	return nil
+}
+
+// RemoveEmailLocation forgets the cached email at a folder and UID. The
+// email is deleted once no folder holds it.
+func (s *Store) RemoveEmailLocation(folderID int, uid uint32) error {
+	if _, err := s.cache.DB().Exec("DELETE FROM email_locations WHERE folder_id = ? AND uid = ?", folderID, uid); err != nil {
+		return fmt.Errorf("failed to remove email from folder: %w", err)
+	}
+	return nil
+}
+
+// DeleteEmail removes an email from the cache, in every folder
+func (s *Store) DeleteEmail(emailID int64) error {
+	if _, err := s.cache.DB().Exec("DELETE FROM emails WHERE id = ?", emailID); err != nil {
+		return fmt.Errorf("failed to delete email: %w", err)
+	}
+	return nil
+}
+
+// FolderUIDs maps the UIDs cached for a folder to their email IDs
+func (s *Store) FolderUIDs(folderID int) (map[uint32]int64, error) {
+	stmt, err := s.prepare(s.cache.Reader(), "SELECT uid, email_id FROM email_locations WHERE folder_id = ?")
 	if err != nil {
-		return 0, fmt.Errorf("failed to upsert folder: %w", err)
+		return nil, err
 	}
 
-	id, err := result.LastInsertId()
+	rows, err := stmt.Query(folderID)
 	if err != nil {
-		// Get existing ID
-		var folderID int
-		err := s.cache.DB().QueryRow("SELECT id FROM folders WHERE account_id = ? AND path = ?", accountID, path).Scan(&folderID)
-		if err != nil {
-			return 0, fmt.Errorf("failed to get folder ID: %w", err)
+		return nil, fmt.Errorf("failed to list cached UIDs: %w", err)
+	}
+	defer rows.Close()
+
+	uids := make(map[uint32]int64)
+	for rows.Next() {
+		var uid uint32
+		var id int64
+		if err := rows.Scan(&uid, &id); err != nil {
+			return nil, fmt.Errorf("failed to scan cached UID: %w", err)
 		}
-		return folderID, nil
+		uids[uid] = id
 	}
 
-	return int(id), nil
+	return uids, rows.Err()
 }
 
-// UpsertEmail upserts an email in the cache
+// upsertBatchSize is the number of emails UpsertEmails writes per transaction
+const upsertBatchSize = 500
+
+// upsertEmailQuery inserts a message or refreshes the cached copy and
+// returns its ID. A message is cached once per account whatever folders
+// hold it.
+const upsertEmailQuery = `
+	INSERT INTO emails (account_id, message_key, message_id, subject, sender_name, sender_email, recipients, date, body_text, body_html, headers, flags, attachment_count, in_reply_to, reference_ids, list_id, reply_to, return_path, x_mailer, content_bytes, body_bytes, body_evicted_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CASE WHEN ? THEN CURRENT_TIMESTAMP END)
+	ON CONFLICT(account_id, message_key) DO UPDATE SET
+		message_id = excluded.message_id,
+		subject = excluded.subject,
+		sender_name = excluded.sender_name,
+		sender_email = excluded.sender_email,
+		recipients = excluded.recipients,
+		date = excluded.date,
+		body_text = excluded.body_text,
+		body_html = excluded.body_html,
+		headers = excluded.headers,
+		flags = excluded.flags,
+		attachment_count = excluded.attachment_count,
+		in_reply_to = excluded.in_reply_to,
+		reference_ids = excluded.reference_ids,
+		list_id = excluded.list_id,
+		reply_to = excluded.reply_to,
+		return_path = excluded.return_path,
+		x_mailer = excluded.x_mailer,
+		content_bytes = excluded.content_bytes,
+		body_bytes = excluded.body_bytes,
+		body_evicted_at = excluded.body_evicted_at,
+		cached_at = CURRENT_TIMESTAMP
+	RETURNING id
+`
+
+// upsertLocationQuery records the folder and UID of a cached message. A
+// UID reused for another message (after UIDVALIDITY changes) is reassigned.
+const upsertLocationQuery = `
+	INSERT INTO email_locations (email_id, folder_id, uid) VALUES (?, ?, ?)
+	ON CONFLICT(folder_id, uid) DO UPDATE SET email_id = excluded.email_id
+	WHERE email_id != excluded.email_id
+`
+
+// MessageKey identifies a message within an account: its Message-ID, or for
+// messages without one a hash of the date, sender, recipients and subject.
+// Copies of a message in several folders share a key.
+func MessageKey(email *types.Email) string {
+	if ids := ParseMessageIDs(email.MessageID); len(ids) > 0 {
+		return ids[0]
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%s\n%s\n%s", email.Date.Unix(), strings.ToLower(email.SenderEmail),
+		strings.Join(email.Recipients, ","), email.Subject)
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// UpsertEmail upserts an email and its folder location in the cache
 func (s *Store) UpsertEmail(email *types.Email) error {
+	_, err := s.UpsertEmails([]*types.Email{email})
+	return err
+}
+
+// UpsertEmails upserts emails in transactions of upsertBatchSize, which is
+// far faster than one autocommit write per email. Each email's FolderID and
+// UID are recorded as a location of the cached message, and its ID is set.
+// Emails that fail are logged and skipped; the number cached is returned.
+func (s *Store) UpsertEmails(emails []*types.Email) (int, error) {
+	emailStmt, err := s.prepare(s.cache.DB(), upsertEmailQuery)
+	if err != nil {
+		return 0, err
+	}
+	locationStmt, err := s.prepare(s.cache.DB(), upsertLocationQuery)
+	if err != nil {
+		return 0, err
+	}
+
+	cached := 0
+	for start := 0; start < len(emails); start += upsertBatchSize {
+		end := min(start+upsertBatchSize, len(emails))
+		n, err := s.upsertBatch(emailStmt, locationStmt, emails[start:end])
+		if err != nil {
+			return cached, err
+		}
+		cached += n
+	}
+
+	return cached, nil
+}
+
+// upsertBatch upserts emails and their locations in one transaction
+func (s *Store) upsertBatch(emailStmt, locationStmt *sql.Stmt, emails []*types.Email) (int, error) {
+	tx, err := s.cache.DB().Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	txEmail, txLocation := tx.Stmt(emailStmt), tx.Stmt(locationStmt)
+	cached := 0
+	for _, email := range emails {
+		args, err := s.upsertEmailArgs(email)
+		var id int64
+		if err == nil {
+			err = txEmail.QueryRow(args...).Scan(&id)
+		}
+		if err == nil {
+			_, err = txLocation.Exec(id, email.FolderID, email.UID)
+		}
+		if err != nil {
+			s.logger.WithError(err).WithField("uid", email.UID).Warn("Failed to cache email")
+			continue
+		}
+		email.ID = id
+		cached++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit emails: %w", err)
+	}
+	return cached, nil
+}
+
+// upsertEmailArgs serializes an email into the arguments of upsertEmailQuery
+func (s *Store) upsertEmailArgs(email *types.Email) ([]interface{}, error) {
 	// Serialize recipients, headers, and flags
 	recipientsJSON, err := json.Marshal(email.Recipients)
 	if err != nil {
-		return fmt.Errorf("failed to marshal recipients: %w", err)
+		return nil, fmt.Errorf("failed to marshal recipients: %w", err)
 	}
 	headersJSON, err := json.Marshal(email.Headers)
 	if err != nil {
-		return fmt.Errorf("failed to marshal headers: %w", err)
+		return nil, fmt.Errorf("failed to marshal headers: %w", err)
 	}
 	flagsJSON, err := json.Marshal(email.Flags)
 	if err != nil {
-		return fmt.Errorf("failed to marshal flags: %w", err)
+		return nil, fmt.Errorf("failed to marshal flags: %w", err)
+	}
+	references := email.References
+	if references == nil {
+		references = []string{}
+	}
+	referencesJSON, err := json.Marshal(references)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal references: %w", err)
 	}
 
-	query := `
-		INSERT INTO emails (account_id, folder_id, uid, message_id, subject, sender_name, sender_email, recipients, date, body_text, body_html, headers, flags)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(account_id, folder_id, uid) DO UPDATE SET
-			message_id = excluded.message_id,
-			subject = excluded.subject,
-			sender_name = excluded.sender_name,
-			sender_email = excluded.sender_email,
-			recipients = excluded.recipients,
-			date = excluded.date,
-			body_text = excluded.body_text,
-			body_html = excluded.body_html,
-			headers = excluded.headers,
-			flags = excluded.flags,
-			cached_at = CURRENT_TIMESTAMP
-	`
-	_, err = s.cache.DB().Exec(query,
+	// Encrypt message content when the cache is encrypted
+	cipher := s.cache.cipher
+	bodyText := cipher.seal(columnBodyText, email.BodyText)
+	bodyHTML := cipher.seal(columnBodyHTML, email.BodyHTML)
+	headers := cipher.seal(columnHeaders, string(headersJSON))
+
+	// Sizes as stored, for retention limits on cached bytes
+	bodyBytes := len(bodyText) + len(bodyHTML) + len(headers)
+	contentBytes := bodyBytes + len(email.Subject) + len(recipientsJSON) + len(referencesJSON)
+
+	return []interface{}{
 		email.AccountID,
-		email.FolderID,
-		email.UID,
+		MessageKey(email),
 		email.MessageID,
 		email.Subject,
 		email.SenderName,
 		email.SenderEmail,
 		string(recipientsJSON),
 		email.Date,
-		email.BodyText,
-		email.BodyHTML,
-		string(headersJSON),
+		bodyText,
+		bodyHTML,
+		headers,
 		string(flagsJSON),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to upsert email: %w", err)
-	}
-
-	return nil
+		email.AttachmentCount,
+		email.InReplyTo,
+		string(referencesJSON),
+		email.ListID,
+		email.ReplyTo,
+		email.ReturnPath,
+		email.XMailer,
+		contentBytes,
+		bodyBytes,
+		email.BodyEvicted,
+	}, nil
 }
 
+// emailLocations selects a JSON array of the folders holding email e and
+// its UIDs there. The primary location comes first: the folder the message
+// was first cached in, preferring any folder over All Mail, which holds
+// every message.
+const emailLocations = `(SELECT json_group_array(json_object('folder_id', folder_id, 'folder_path', path, 'uid', uid))
+	FROM (SELECT l.folder_id, f.path, l.uid FROM email_locations l JOIN folders f ON l.folder_id = f.id
+		WHERE l.email_id = e.id ORDER BY f.special_use = 'all', l.id))`
+
+// emailColumns lists the columns scanEmail expects, in order
+const emailColumns = `e.id, e.account_id, a.name, ` + emailLocations + `, e.message_id, e.subject,
+	e.sender_name, e.sender_email, e.recipients, e.date, e.body_text, e.body_html, e.headers, e.flags,
+	e.attachment_count, e.in_reply_to, e.reference_ids, e.list_id, e.reply_to, e.return_path, e.x_mailer,
+	e.thread_id, e.body_evicted_at IS NOT NULL, e.cached_at`
+
 // GetEmail retrieves an email by ID
 func (s *Store) GetEmail(emailID int64) (*types.Email, error) {
 	query := `
-		SELECT e.id, e.account_id, a.name, e.folder_id, f.path, e.uid, e.message_id, e.subject, e.sender_name, e.sender_email, e.recipients, e.date, e.body_text, e.body_html, e.headers, e.flags, e.cached_at
+		SELECT ` + emailColumns + `
 		FROM emails e
 		JOIN accounts a ON e.account_id = a.id
-		JOIN folders f ON e.folder_id = f.id
 		WHERE e.id = ?
 	`
+	stmt, err := s.prepare(s.cache.Reader(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	email, err := s.scanEmail(stmt.QueryRow(emailID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("email not found: %d", emailID)
+		}
+		return nil, fmt.Errorf("failed to get email: %w", err)
+	}
+
+	return email, nil
+}
+
+// scanEmail scans a row selected with emailColumns, decrypting its content
+func (s *Store) scanEmail(row rowScanner) (*types.Email, error) {
 	var email types.Email
-	var recipientsJSON, headersJSON, flagsJSON string
+	var locationsJSON, recipientsJSON, headersJSON, flagsJSON, referencesJSON string
 	var dateStr string
+	var threadID sql.NullInt64
 
-	err := s.cache.DB().QueryRow(query, emailID).Scan(
+	err := row.Scan(
 		&email.ID,
 		&email.AccountID,
 		&email.AccountName,
-		&email.FolderID,
-		&email.FolderPath,
-		&email.UID,
+		&locationsJSON,
 		&email.MessageID,
 		&email.Subject,
 		&email.SenderName,
@@ -182,14 +426,21 @@ func (s *Store) GetEmail(emailID int64) (*types.Email, error) {
 		&email.BodyHTML,
 		&headersJSON,
 		&flagsJSON,
+		&email.AttachmentCount,
+		&email.InReplyTo,
+		&referencesJSON,
+		&email.ListID,
+		&email.ReplyTo,
+		&email.ReturnPath,
+		&email.XMailer,
+		&threadID,
+		&email.BodyEvicted,
 		&email.CachedAt,
 	)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("email not found: %d", emailID)
-		}
-		return nil, fmt.Errorf("failed to get email: %w", err)
+		return nil, err
 	}
+	email.ThreadID = threadID.Int64
 
 	// Parse date
 	email.Date, err = time.Parse(time.RFC3339, dateStr)
@@ -197,7 +448,25 @@ func (s *Store) GetEmail(emailID int64) (*types.Email, error) {
 		return nil, fmt.Errorf("failed to parse date: %w", err)
 	}
 
+	cipher := s.cache.cipher
+	if email.BodyText, err = cipher.Decrypt(columnBodyText, email.BodyText); err != nil {
+		return nil, err
+	}
+	if email.BodyHTML, err = cipher.Decrypt(columnBodyHTML, email.BodyHTML); err != nil {
+		return nil, err
+	}
+	if headersJSON, err = cipher.Decrypt(columnHeaders, headersJSON); err != nil {
+		return nil, err
+	}
+
 	// Deserialize JSON fields
+	if email.Locations, err = parseLocations(locationsJSON); err != nil {
+		return nil, err
+	}
+	if len(email.Locations) > 0 {
+		primary := email.Locations[0]
+		email.FolderID, email.FolderPath, email.UID = primary.FolderID, primary.FolderPath, primary.UID
+	}
 	if err := json.Unmarshal([]byte(recipientsJSON), &email.Recipients); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal recipients: %w", err)
 	}
@@ -207,74 +476,26 @@ func (s *Store) GetEmail(emailID int64) (*types.Email, error) {
 	if err := json.Unmarshal([]byte(flagsJSON), &email.Flags); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal flags: %w", err)
 	}
+	if err := json.Unmarshal([]byte(referencesJSON), &email.References); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal references: %w", err)
+	}
 
 	return &email, nil
 }
 
-// ListFolders lists folders for an account
-func (s *Store) ListFolders(accountID *int) ([]types.Folder, error) {
-	var query string
-	var args []interface{}
-
-	if accountID != nil {
-		query = `
-			SELECT f.id, f.account_id, a.name, f.name, f.path, f.message_count, f.last_synced
-			FROM folders f
-			JOIN accounts a ON f.account_id = a.id
-			WHERE f.account_id = ?
-			ORDER BY f.path
-		`
-		args = []interface{}{*accountID}
-	} else {
-		query = `
-			SELECT f.id, f.account_id, a.name, f.name, f.path, f.message_count, f.last_synced
-			FROM folders f
-			JOIN accounts a ON f.account_id = a.id
-			ORDER BY a.name, f.path
-		`
-	}
-
-	rows, err := s.cache.DB().Query(query, args...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query folders: %w", err)
+// parseLocations decodes a column selected with emailLocations
+func parseLocations(value string) ([]types.EmailLocation, error) {
+	var locations []types.EmailLocation
+	if err := json.Unmarshal([]byte(value), &locations); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal locations: %w", err)
 	}
-	defer rows.Close()
-
-	var folders []types.Folder
-	for rows.Next() {
-		var folder types.Folder
-		var lastSynced sql.NullString
-
-		err := rows.Scan(
-			&folder.ID,
-			&folder.AccountID,
-			&folder.AccountName,
-			&folder.Name,
-			&folder.Path,
-			&folder.MessageCount,
-			&lastSynced,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan folder: %w", err)
-		}
-
-		if lastSynced.Valid {
-			t, err := time.Parse(time.RFC3339, lastSynced.String)
-			if err == nil {
-				folder.LastSynced = &t
-			}
-		}
-
-		folders = append(folders, folder)
-	}
-
-	return folders, nil
+	return locations, nil
 }
 
 // HasEmails checks if an account has any cached emails
 func (s *Store) HasEmails(accountID int) (bool, error) {
 	var count int
-	err := s.cache.DB().QueryRow("SELECT COUNT(*) FROM emails WHERE account_id = ?", accountID).Scan(&count)
+	err := s.cache.Reader().QueryRow("SELECT COUNT(*) FROM emails WHERE account_id = ?", accountID).Scan(&count)
 	if err != nil {
 		return false, fmt.Errorf("failed to check emails count: %w", err)
 	}
@@ -284,7 +505,7 @@ func (s *Store) HasEmails(accountID int) (bool, error) {
 // HasAnyEmails checks if there are any cached emails
 func (s *Store) HasAnyEmails() (bool, error) {
 	var count int
-	err := s.cache.DB().QueryRow("SELECT COUNT(*) FROM emails").Scan(&count)
+	err := s.cache.Reader().QueryRow("SELECT COUNT(*) FROM emails").Scan(&count)
 	if err != nil {
 		return false, fmt.Errorf("failed to check emails count: %w", err)
 	}
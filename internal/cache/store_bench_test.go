@@ -0,0 +1,291 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/internal/config"
+	"github.com/brandon/mcp-email/pkg/types"
+)
+
+// Cache throughput on a synthetic mailbox: how fast a large folder is synced
+// into the cache, how fast it is searched, and how searches hold up while a
+// sync is writing. Run with
+//
+//	go test ./internal/cache -run '^$' -bench . -benchtime 3x
+
+// benchMessages is the size of the synthetic folder
+const benchMessages = 100000
+
+// benchSingle is the number of emails written one transaction at a time,
+// for comparison
+const benchSingle = 5000
+
+// benchBodyTerms is the number of distinct terms in synthetic bodies. Terms
+// are Zipf-distributed like words in real text, so searches are selective.
+const benchBodyTerms = 50000
+
+// benchVocabulary supplies words for synthetic subjects
+var benchVocabulary = strings.Fields(`invoice meeting project report budget review schedule travel contract update
+	release deadline customer support ticket order shipping payment receipt proposal design launch feedback
+	agenda minutes quarterly planning hiring interview offer vacation expense approval security incident
+	migration database server deploy outage dashboard metrics newsletter webinar conference workshop`)
+
+// benchStore is a store in a fresh database with one account
+type benchStore struct {
+	store     *Store
+	accountID int
+	folders   int
+}
+
+func newBenchStore(b *testing.B) *benchStore {
+	b.Helper()
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	c, err := NewCache(filepath.Join(b.TempDir(), "bench.db"), &config.EncryptionConfig{}, logger)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { c.Close() })
+	store := NewStore(c, logger)
+
+	accountID, err := store.UpsertAccount(&config.AccountConfig{Name: "bench", IMAPHost: "localhost", SMTPHost: "localhost"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return &benchStore{store: store, accountID: accountID}
+}
+
+// folder creates an empty folder
+func (s *benchStore) folder(b *testing.B) int {
+	b.Helper()
+	s.folders++
+	name := fmt.Sprintf("Folder%d", s.folders)
+	folderID, err := s.store.UpsertFolder(&types.Folder{AccountID: s.accountID, Name: name, Path: name})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return folderID
+}
+
+// inFolder returns copies of emails in another folder
+func inFolder(emails []*types.Email, folderID int) []*types.Email {
+	copies := make([]*types.Email, len(emails))
+	for i, e := range emails {
+		copied := *e
+		copied.FolderID = folderID
+		copied.MessageID = fmt.Sprintf("<%d.%d@example.com>", copied.UID, folderID)
+		copies[i] = &copied
+	}
+	return copies
+}
+
+// BenchmarkUpsertEmails syncs a 100k-message folder into the cache. Each
+// iteration writes the whole folder; msg/s is the throughput.
+func BenchmarkUpsertEmails(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	emails := benchEmails(rng, 0, 0, benchMessages)
+
+	// One autocommit transaction per email, as syncs used to write; each
+	// iteration writes benchSingle emails, as this is much slower
+	b.Run("single", func(b *testing.B) {
+		s := newBenchStore(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			batch := inFolder(emails[:benchSingle], s.folder(b))
+			b.StartTimer()
+			for _, e := range batch {
+				e.AccountID = s.accountID
+				if err := s.store.UpsertEmail(e); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		b.ReportMetric(float64(b.N*benchSingle)/b.Elapsed().Seconds(), "msg/s")
+	})
+
+	// Batched transactions into an empty folder, as a first sync writes
+	b.Run("batched", func(b *testing.B) {
+		s := newBenchStore(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			batch := inFolder(emails, s.folder(b))
+			for _, e := range batch {
+				e.AccountID = s.accountID
+			}
+			b.StartTimer()
+			if _, err := s.store.UpsertEmails(batch); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(b.N*len(emails))/b.Elapsed().Seconds(), "msg/s")
+	})
+
+	// Re-sync of an already cached folder, e.g. after flag changes
+	b.Run("resync", func(b *testing.B) {
+		s := newBenchStore(b)
+		batch := inFolder(emails, s.folder(b))
+		for _, e := range batch {
+			e.AccountID = s.accountID
+		}
+		if _, err := s.store.UpsertEmails(batch); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			flags := []string{}
+			if i%2 == 0 {
+				flags = []string{`\Seen`}
+			}
+			for _, e := range batch {
+				e.Flags = flags
+			}
+			if _, err := s.store.UpsertEmails(batch); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(b.N*len(batch))/b.Elapsed().Seconds(), "msg/s")
+	})
+}
+
+// BenchmarkSearch runs full-text searches, 50 results per page, on a cached
+// 100k-message folder, idle and while a sync rewrites the folder
+func BenchmarkSearch(b *testing.B) {
+	s := newBenchStore(b)
+	rng := rand.New(rand.NewSource(1))
+	emails := benchEmails(rng, s.accountID, s.folder(b), benchMessages)
+	if _, err := s.store.UpsertEmails(emails); err != nil {
+		b.Fatal(err)
+	}
+	if err := s.store.RebuildThreads(s.accountID); err != nil {
+		b.Fatal(err)
+	}
+	queries := benchQueries(rng, 200)
+
+	b.Run("idle", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := s.store.Search(queries[i%len(queries)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// Re-syncs run back to back while the searches run; synced counts the
+	// messages they wrote
+	b.Run("during-sync", func(b *testing.B) {
+		var done atomic.Bool
+		var synced atomic.Int64
+		var syncErr error
+		var wg sync.WaitGroup
+		start := time.Now()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; !done.Load(); i++ {
+				flags := []string{}
+				if i%2 == 0 {
+					flags = []string{`\Seen`}
+				}
+				for _, e := range emails {
+					e.Flags = flags
+				}
+				n, err := s.store.UpsertEmails(emails)
+				if err != nil {
+					syncErr = err
+					return
+				}
+				synced.Add(int64(n))
+			}
+		}()
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := s.store.Search(queries[i%len(queries)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		done.Store(true)
+		wg.Wait()
+		if syncErr != nil {
+			b.Fatal(syncErr)
+		}
+		// Syncs are timed to the end of the last one, which may finish
+		// after the searches
+		b.ReportMetric(float64(synced.Load())/time.Since(start).Seconds(), "synced-msg/s")
+	})
+}
+
+// benchEmails builds count synthetic emails spread over the last two years
+func benchEmails(rng *rand.Rand, accountID, folderID, count int) []*types.Email {
+	zipf := rand.NewZipf(rng, 1.1, 1, benchBodyTerms-1)
+	now := time.Now().UTC().Truncate(time.Second)
+	emails := make([]*types.Email, count)
+	for i := range emails {
+		sender := fmt.Sprintf("sender%d@example%d.com", rng.Intn(500), rng.Intn(50))
+		emails[i] = &types.Email{
+			AccountID:   accountID,
+			FolderID:    folderID,
+			UID:         uint32(i + 1),
+			MessageID:   fmt.Sprintf("<%d.bench@example.com>", i),
+			Subject:     benchWords(rng, 3+rng.Intn(5)),
+			SenderName:  "Sender " + sender[:strings.Index(sender, "@")],
+			SenderEmail: sender,
+			Recipients:  []string{"me@example.com"},
+			Date:        now.Add(-time.Duration(rng.Int63n(int64(2 * 365 * 24 * time.Hour)))),
+			BodyText:    benchTerms(zipf, 50+rng.Intn(250)),
+			Headers:     map[string][]string{},
+			Flags:       []string{},
+		}
+	}
+	return emails
+}
+
+// benchQueries builds full-text searches for one or two body terms of
+// middling frequency, matching hundreds to thousands of messages each
+func benchQueries(rng *rand.Rand, count int) []SearchOptions {
+	queries := make([]SearchOptions, count)
+	for i := range queries {
+		words := make([]string, 1+rng.Intn(2))
+		for j := range words {
+			words[j] = benchTerm(uint64(20 + rng.Intn(500)))
+		}
+		body := strings.Join(words, " ")
+		queries[i] = SearchOptions{Body: &body, Limit: 50}
+	}
+	return queries
+}
+
+// benchTerms returns n Zipf-distributed body terms
+func benchTerms(zipf *rand.Zipf, n int) string {
+	t := make([]string, n)
+	for i := range t {
+		t[i] = benchTerm(zipf.Uint64())
+	}
+	return strings.Join(t, " ")
+}
+
+// benchTerm names the body term of the given frequency rank
+func benchTerm(rank uint64) string {
+	return fmt.Sprintf("t%d", rank)
+}
+
+// benchWords returns n random vocabulary words
+func benchWords(rng *rand.Rand, n int) string {
+	w := make([]string, n)
+	for i := range w {
+		w[i] = benchVocabulary[rng.Intn(len(benchVocabulary))]
+	}
+	return strings.Join(w, " ")
+}
@@ -0,0 +1,422 @@
+package cache
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/brandon/mcp-email/pkg/types"
+)
+
+// replyPrefix matches the reply and forward markers mail clients prepend to
+// subjects, including localized and counted forms like "AW:" or "Re[2]:"
+var replyPrefix = regexp.MustCompile(`(?i)^\s*(re|fwd?|aw|wg|sv|vs|antw|tr|ref)\s*(\[\d+\])?\s*:\s*`)
+
+// messageIDPattern matches a bracketed message ID
+var messageIDPattern = regexp.MustCompile(`<[^<>\s]+>`)
+
+// threadMessage is a cached email as seen by the threading algorithm
+type threadMessage struct {
+	id        int64
+	messageID string
+	parents   []string
+	subject   string
+	date      time.Time
+	threadID  int64
+}
+
+// threadContainer is a node of the JWZ threading tree. A container without
+// messages stands for a message that is referenced but not cached; copies
+// of the same message in several folders share one container.
+type threadContainer struct {
+	id       string
+	messages []*threadMessage
+	parent   *threadContainer
+	children []*threadContainer
+}
+
+// conversation is a thread computed by threadMessages
+type conversation struct {
+	key      string
+	messages []*threadMessage
+}
+
+// ParseMessageIDs extracts the message IDs from a Message-ID, In-Reply-To
+// or References header value. Unbracketed values are bracketed.
+func ParseMessageIDs(value string) []string {
+	ids := messageIDPattern.FindAllString(value, -1)
+	if len(ids) == 0 {
+		if value = strings.Trim(strings.TrimSpace(value), "<>"); value != "" && !strings.ContainsAny(value, " \t") {
+			ids = []string{"<" + value + ">"}
+		}
+	}
+	return ids
+}
+
+// NormalizeSubject strips reply and forward prefixes and folds case and
+// whitespace so replies compare equal to the message they answer. It also
+// reports whether a prefix was found.
+func NormalizeSubject(subject string) (string, bool) {
+	reply := false
+	for {
+		loc := replyPrefix.FindStringIndex(subject)
+		if loc == nil {
+			break
+		}
+		subject = subject[loc[1]:]
+		reply = true
+	}
+	return strings.ToLower(strings.Join(strings.Fields(subject), " ")), reply
+}
+
+// hasDescendant reports whether d is c or lies below c
+func (c *threadContainer) hasDescendant(d *threadContainer) bool {
+	for ; d != nil; d = d.parent {
+		if d == c {
+			return true
+		}
+	}
+	return false
+}
+
+// setParent moves c below parent, or to the root set if parent is nil
+func (c *threadContainer) setParent(parent *threadContainer) {
+	if c.parent != nil {
+		siblings := c.parent.children
+		for i, sibling := range siblings {
+			if sibling == c {
+				c.parent.children = append(siblings[:i:i], siblings[i+1:]...)
+				break
+			}
+		}
+	}
+	c.parent = parent
+	if parent != nil {
+		parent.children = append(parent.children, c)
+	}
+}
+
+// subject returns the subject of the first message in or below c
+func (c *threadContainer) subject() string {
+	if len(c.messages) > 0 {
+		return c.messages[0].subject
+	}
+	for _, child := range c.children {
+		if subject := child.subject(); subject != "" {
+			return subject
+		}
+	}
+	return ""
+}
+
+// collect appends the messages in and below c
+func (c *threadContainer) collect(messages []*threadMessage) []*threadMessage {
+	messages = append(messages, c.messages...)
+	for _, child := range c.children {
+		messages = child.collect(messages)
+	}
+	return messages
+}
+
+// threadMessages groups messages into conversations using the JWZ algorithm
+// (https://www.jwz.org/doc/threading.html): messages are linked through
+// their References and In-Reply-To headers, missing links are pruned, and
+// replies whose references were lost are attached by subject. Subjects are
+// only used to join a reply to a non-reply, so unrelated messages that share
+// a generic subject stay apart.
+func threadMessages(messages []*threadMessage) []conversation {
+	table := make(map[string]*threadContainer)
+	var order []*threadContainer
+	get := func(id string) *threadContainer {
+		c, ok := table[id]
+		if !ok {
+			c = &threadContainer{id: id}
+			table[id] = c
+			order = append(order, c)
+		}
+		return c
+	}
+
+	// Link each message below its references
+	for _, m := range messages {
+		id := m.messageID
+		if id == "" {
+			id = fmt.Sprintf("<cache-%d>", m.id)
+		}
+		c := get(id)
+		c.messages = append(c.messages, m)
+
+		var prev *threadContainer
+		for _, ref := range m.parents {
+			r := get(ref)
+			if prev != nil && r.parent == nil && !r.hasDescendant(prev) {
+				r.setParent(prev)
+			}
+			prev = r
+		}
+
+		// The message's own headers are authoritative for its parent
+		if prev != nil && !c.hasDescendant(prev) {
+			c.setParent(prev)
+		} else if prev == nil && c.parent != nil {
+			c.setParent(nil)
+		}
+	}
+
+	var roots []*threadContainer
+	for _, c := range order {
+		if c.parent == nil {
+			roots = append(roots, c)
+		}
+	}
+	roots = pruneContainers(roots, true)
+
+	// Attach replies that lost their references to the thread they answer
+	bySubject := make(map[string]*threadContainer)
+	for _, root := range roots {
+		subject, reply := NormalizeSubject(root.subject())
+		if subject == "" {
+			continue
+		}
+		if existing, ok := bySubject[subject]; !ok || (!reply && isReplyContainer(existing)) {
+			bySubject[subject] = root
+		}
+	}
+
+	var merged []*threadContainer
+	for _, root := range roots {
+		subject, reply := NormalizeSubject(root.subject())
+		target := bySubject[subject]
+		if subject == "" || target == nil || target == root || !reply || isReplyContainer(target) {
+			merged = append(merged, root)
+			continue
+		}
+		if len(root.messages) == 0 {
+			for len(root.children) > 0 {
+				root.children[0].setParent(target)
+			}
+		} else {
+			root.setParent(target)
+		}
+	}
+
+	conversations := make([]conversation, 0, len(merged))
+	for _, root := range merged {
+		conversations = append(conversations, conversation{
+			key:      root.id,
+			messages: root.collect(nil),
+		})
+	}
+	return conversations
+}
+
+// isReplyContainer reports whether the subject of c has a reply prefix
+func isReplyContainer(c *threadContainer) bool {
+	_, reply := NormalizeSubject(c.subject())
+	return reply
+}
+
+// pruneContainers drops containers without messages or children and
+// replaces containers without messages by their children. At the root a
+// messageless container is kept when it holds several children, since it
+// is what ties them together.
+func pruneContainers(containers []*threadContainer, root bool) []*threadContainer {
+	var pruned []*threadContainer
+	for _, c := range containers {
+		c.children = pruneContainers(c.children, false)
+		if len(c.messages) == 0 {
+			if len(c.children) == 0 {
+				continue
+			}
+			if !root || len(c.children) == 1 {
+				for _, child := range c.children {
+					child.parent = c.parent
+				}
+				pruned = append(pruned, c.children...)
+				continue
+			}
+		}
+		pruned = append(pruned, c)
+	}
+	return pruned
+}
+
+// RebuildThreads recomputes the conversations of an account from the
+// references of its cached emails, across all folders, and records them in
+// the threads table. Thread IDs stay stable while a thread's root does.
+func (s *Store) RebuildThreads(accountID int) error {
+	rows, err := s.cache.Reader().Query(`
+		SELECT id, message_id, in_reply_to, reference_ids, COALESCE(subject, ''), date, thread_id
+		FROM emails
+		WHERE account_id = ?
+		ORDER BY date, id
+	`, accountID)
+	if err != nil {
+		return fmt.Errorf("failed to load emails for threading: %w", err)
+	}
+
+	var messages []*threadMessage
+	for rows.Next() {
+		var m threadMessage
+		var messageID, inReplyTo, referencesJSON string
+		var threadID sql.NullInt64
+		if err := rows.Scan(&m.id, &messageID, &inReplyTo, &referencesJSON, &m.subject, &m.date, &threadID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan email for threading: %w", err)
+		}
+		if ids := ParseMessageIDs(messageID); len(ids) > 0 {
+			m.messageID = ids[0]
+		}
+		var references []string
+		if err := json.Unmarshal([]byte(referencesJSON), &references); err != nil {
+			references = nil
+		}
+		m.parents = threadParents(references, ParseMessageIDs(inReplyTo), m.messageID)
+		m.threadID = threadID.Int64
+		messages = append(messages, &m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load emails for threading: %w", err)
+	}
+
+	tx, err := s.cache.DB().Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	update, err := tx.Prepare("UPDATE emails SET thread_id = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("failed to prepare thread update: %w", err)
+	}
+	defer update.Close()
+
+	for _, conv := range threadMessages(messages) {
+		first, last := conv.messages[0], conv.messages[0]
+		distinct := make(map[string]bool)
+		for _, m := range conv.messages {
+			if m.date.Before(first.date) {
+				first = m
+			}
+			if m.date.After(last.date) {
+				last = m
+			}
+			if m.messageID == "" {
+				distinct[fmt.Sprintf("<cache-%d>", m.id)] = true
+			} else {
+				distinct[m.messageID] = true
+			}
+		}
+
+		var threadID int64
+		err := tx.QueryRow(`
+			INSERT INTO threads (account_id, root_key, subject, message_count, first_date, last_date)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(account_id, root_key) DO UPDATE SET
+				subject = excluded.subject,
+				message_count = excluded.message_count,
+				first_date = excluded.first_date,
+				last_date = excluded.last_date
+			RETURNING id
+		`, accountID, conv.key, first.subject, len(distinct), first.date, last.date).Scan(&threadID)
+		if err != nil {
+			return fmt.Errorf("failed to upsert thread: %w", err)
+		}
+
+		for _, m := range conv.messages {
+			if m.threadID == threadID {
+				continue
+			}
+			if _, err := update.Exec(threadID, m.id); err != nil {
+				return fmt.Errorf("failed to assign thread: %w", err)
+			}
+		}
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM threads
+		WHERE account_id = ? AND NOT EXISTS (SELECT 1 FROM emails WHERE thread_id = threads.id)
+	`, accountID)
+	if err != nil {
+		return fmt.Errorf("failed to prune threads: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// threadParents returns the ancestry of a message, oldest first: its
+// References, followed by its In-Reply-To parent when References does not
+// already end with it. References to the message itself are dropped.
+func threadParents(references, inReplyTo []string, self string) []string {
+	parents := make([]string, 0, len(references)+1)
+	for _, ref := range references {
+		if ref != self {
+			parents = append(parents, ref)
+		}
+	}
+	if len(inReplyTo) > 0 && inReplyTo[0] != self {
+		if len(parents) == 0 || parents[len(parents)-1] != inReplyTo[0] {
+			parents = append(parents, inReplyTo[0])
+		}
+	}
+	return parents
+}
+
+// GetThread retrieves a thread by ID
+func (s *Store) GetThread(threadID int64) (*types.Thread, error) {
+	var thread types.Thread
+	var subject sql.NullString
+	err := s.cache.Reader().QueryRow(`
+		SELECT t.id, t.account_id, a.name, t.subject, t.message_count, t.first_date, t.last_date
+		FROM threads t
+		JOIN accounts a ON t.account_id = a.id
+		WHERE t.id = ?
+	`, threadID).Scan(
+		&thread.ID,
+		&thread.AccountID,
+		&thread.AccountName,
+		&subject,
+		&thread.MessageCount,
+		&thread.FirstDate,
+		&thread.LastDate,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("thread not found: %d", threadID)
+		}
+		return nil, fmt.Errorf("failed to get thread: %w", err)
+	}
+	thread.Subject = subject.String
+
+	return &thread, nil
+}
+
+// ThreadEmails returns the cached emails of a thread, oldest first
+func (s *Store) ThreadEmails(threadID int64) ([]*types.Email, error) {
+	rows, err := s.cache.Reader().Query(`
+		SELECT `+emailColumns+`
+		FROM emails e
+		JOIN accounts a ON e.account_id = a.id
+		WHERE e.thread_id = ?
+		ORDER BY e.date, e.id
+	`, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list thread emails: %w", err)
+	}
+	defer rows.Close()
+
+	var emails []*types.Email
+	for rows.Next() {
+		email, err := s.scanEmail(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan thread email: %w", err)
+		}
+		emails = append(emails, email)
+	}
+
+	return emails, rows.Err()
+}
@@ -0,0 +1,255 @@
+package cache
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CacheStats describes what the cache holds and how much space it takes
+type CacheStats struct {
+	Path          string `json:"path"`
+	SchemaVersion int    `json:"schema_version"`
+	Encrypted     bool   `json:"encrypted"`
+	// FileBytes and WALBytes are the sizes of the database file and its
+	// write-ahead log; FreeBytes of the file is unused and reclaimable
+	FileBytes  int64          `json:"file_bytes"`
+	WALBytes   int64          `json:"wal_bytes"`
+	FreeBytes  int64          `json:"free_bytes"`
+	AutoVacuum string         `json:"auto_vacuum"`
+	Tables     []TableUsage   `json:"tables"`
+	Accounts   []AccountUsage `json:"accounts"`
+
+	LastEviction       *time.Time      `json:"last_eviction,omitempty"`
+	LastEvictionResult *EvictionResult `json:"last_eviction_result,omitempty"`
+	LastVacuum         *time.Time      `json:"last_vacuum,omitempty"`
+}
+
+// TableUsage is the on-disk size of a table including its indexes
+type TableUsage struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+}
+
+// AccountUsage summarizes the cached emails of an account
+type AccountUsage struct {
+	Name          string        `json:"name"`
+	Messages      int           `json:"messages"`
+	BodiesEvicted int           `json:"bodies_evicted"`
+	ContentBytes  int64         `json:"content_bytes"`
+	Folders       []FolderUsage `json:"folders"`
+}
+
+// FolderUsage summarizes the cached emails of a folder
+type FolderUsage struct {
+	Path          string     `json:"path"`
+	Messages      int        `json:"messages"`
+	BodiesEvicted int        `json:"bodies_evicted"`
+	ContentBytes  int64      `json:"content_bytes"`
+	Oldest        *time.Time `json:"oldest,omitempty"`
+	Newest        *time.Time `json:"newest,omitempty"`
+	LastSynced    *time.Time `json:"last_synced,omitempty"`
+}
+
+// CacheStats reports row counts and sizes per account and folder, and the
+// on-disk size of the database and each table. With a nil accountID every
+// account is included.
+func (s *Store) CacheStats(accountID *int) (*CacheStats, error) {
+	c := s.cache
+	stats := &CacheStats{Path: c.path, Encrypted: c.cipher != nil}
+
+	var err error
+	if stats.SchemaVersion, err = c.SchemaVersion(); err != nil {
+		return nil, err
+	}
+	if err := s.fileUsage(stats); err != nil {
+		return nil, err
+	}
+	if stats.Tables, err = s.tableUsage(); err != nil {
+		return nil, err
+	}
+	if stats.Accounts, err = s.accountUsage(accountID); err != nil {
+		return nil, err
+	}
+
+	if stats.LastEviction, stats.LastEvictionResult, err = s.lastEviction(); err != nil {
+		return nil, err
+	}
+	last, err := c.setting(settingLastVacuum)
+	if err != nil {
+		return nil, err
+	}
+	if t, err := time.Parse(time.RFC3339, last); err == nil {
+		stats.LastVacuum = &t
+	}
+
+	return stats, nil
+}
+
+// fileUsage fills in the database file sizes
+func (s *Store) fileUsage(stats *CacheStats) error {
+	db := s.cache.Reader()
+
+	var pageSize, pages, free, autoVacuum int64
+	for pragma, dest := range map[string]*int64{
+		"page_size":      &pageSize,
+		"page_count":     &pages,
+		"freelist_count": &free,
+		"auto_vacuum":    &autoVacuum,
+	} {
+		if err := db.QueryRow("PRAGMA " + pragma).Scan(dest); err != nil {
+			return fmt.Errorf("failed to read %s: %w", pragma, err)
+		}
+	}
+	stats.FileBytes = pageSize * pages
+	stats.FreeBytes = pageSize * free
+	stats.AutoVacuum = []string{"none", "full", "incremental"}[min(max(autoVacuum, 0), 2)]
+
+	if !isMemoryPath(s.cache.path) {
+		if info, err := os.Stat(s.cache.path + "-wal"); err == nil {
+			stats.WALBytes = info.Size()
+		}
+	}
+	return nil
+}
+
+// tableUsage measures each table with its indexes; the full-text index's
+// internal tables are reported together as emails_fts
+func (s *Store) tableUsage() ([]TableUsage, error) {
+	rows, err := s.cache.Reader().Query(`
+		SELECT COALESCE(m.tbl_name, d.name), SUM(d.pgsize)
+		FROM dbstat d
+		LEFT JOIN sqlite_master m ON m.name = d.name
+		GROUP BY 1
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure tables: %w", err)
+	}
+	defer rows.Close()
+
+	sizes := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var bytes int64
+		if err := rows.Scan(&name, &bytes); err != nil {
+			return nil, fmt.Errorf("failed to measure tables: %w", err)
+		}
+		if strings.HasPrefix(name, "emails_fts") {
+			name = "emails_fts"
+		}
+		sizes[name] += bytes
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to measure tables: %w", err)
+	}
+
+	tables := make([]TableUsage, 0, len(sizes))
+	for name, bytes := range sizes {
+		tables = append(tables, TableUsage{Name: name, Bytes: bytes})
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		if tables[i].Bytes != tables[j].Bytes {
+			return tables[i].Bytes > tables[j].Bytes
+		}
+		return tables[i].Name < tables[j].Name
+	})
+	return tables, nil
+}
+
+// accountUsage counts cached emails per account and folder. A message held
+// in several folders counts once for its account and once in each folder.
+func (s *Store) accountUsage(accountID *int) ([]AccountUsage, error) {
+	where := ""
+	var args []interface{}
+	if accountID != nil {
+		where = "WHERE a.id = ?"
+		args = append(args, *accountID)
+	}
+
+	rows, err := s.cache.Reader().Query(`
+		SELECT a.name, COUNT(e.id), COALESCE(SUM(e.body_evicted_at IS NOT NULL), 0), COALESCE(SUM(e.content_bytes), 0)
+		FROM accounts a
+		LEFT JOIN emails e ON e.account_id = a.id
+		`+where+`
+		GROUP BY a.id
+		ORDER BY a.name
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count cached emails: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []AccountUsage
+	index := make(map[string]int)
+	for rows.Next() {
+		var a AccountUsage
+		if err := rows.Scan(&a.Name, &a.Messages, &a.BodiesEvicted, &a.ContentBytes); err != nil {
+			return nil, fmt.Errorf("failed to count cached emails: %w", err)
+		}
+		index[a.Name] = len(accounts)
+		accounts = append(accounts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to count cached emails: %w", err)
+	}
+
+	rows, err = s.cache.Reader().Query(`
+		SELECT a.name, f.path, COUNT(e.id), COALESCE(SUM(e.body_evicted_at IS NOT NULL), 0),
+			COALESCE(SUM(e.content_bytes), 0), CAST(MIN(e.date) AS TEXT), CAST(MAX(e.date) AS TEXT), f.last_synced
+		FROM folders f
+		JOIN accounts a ON f.account_id = a.id
+		LEFT JOIN email_locations l ON l.folder_id = f.id
+		LEFT JOIN emails e ON e.id = l.email_id
+		`+where+`
+		GROUP BY f.id
+		ORDER BY a.name, f.path
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count cached emails: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var account string
+		var folder FolderUsage
+		var oldest, newest, lastSynced sql.NullString
+		err := rows.Scan(&account, &folder.Path, &folder.Messages, &folder.BodiesEvicted,
+			&folder.ContentBytes, &oldest, &newest, &lastSynced)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count cached emails: %w", err)
+		}
+		folder.Oldest = parseStoredTime(oldest)
+		folder.Newest = parseStoredTime(newest)
+		folder.LastSynced = parseStoredTime(lastSynced)
+
+		if i, ok := index[account]; ok {
+			accounts[i].Folders = append(accounts[i].Folders, folder)
+		}
+	}
+	return accounts, rows.Err()
+}
+
+// storedTimeLayouts are the formats the driver and CURRENT_TIMESTAMP write
+var storedTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05",
+	time.RFC3339Nano,
+}
+
+// parseStoredTime parses a date read without its column type, e.g. from an
+// aggregate, which the driver leaves as the stored text
+func parseStoredTime(value sql.NullString) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	text, _, _ := strings.Cut(value.String, " m=")
+	for _, layout := range storedTimeLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
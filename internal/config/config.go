@@ -1,9 +1,14 @@
 package config
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const defaultAccountName = "default"
@@ -14,9 +19,38 @@ type Config struct {
 	CachePath         string
 	SearchResultLimit int
 	LogLevel          string
+	Encryption        EncryptionConfig
+
+	// Cache retention: rules, how often they are enforced, and how often
+	// the database file may be compacted
+	Retention        RetentionRules
+	EvictionInterval time.Duration
+	VacuumInterval   time.Duration
+
+	// Secrets is the encrypted file account passwords may refer to, or nil
+	Secrets *SecretsFile
 
 	// Accounts
 	Accounts []AccountConfig
+
+	// accountEnv holds the environment variable prefix of each account,
+	// e.g. ACCOUNT_2_, to name the variables in validation errors
+	accountEnv []string
+}
+
+// EncryptionConfig holds the at-rest encryption settings of the cache
+type EncryptionConfig struct {
+	// Keys are 256-bit AES keys. The first encrypts new data; the others
+	// only decrypt data written before a key rotation.
+	Keys [][]byte
+	// IndexText indexes decrypted body text for full-text search. The
+	// search index itself is not encrypted.
+	IndexText bool
+}
+
+// Enabled reports whether cache encryption is configured
+func (e *EncryptionConfig) Enabled() bool {
+	return len(e.Keys) > 0
 }
 
 // AccountConfig holds configuration for a single email account
@@ -27,159 +61,433 @@ type AccountConfig struct {
 	IMAPHost     string
 	IMAPPort     int
 	IMAPUsername string
-	IMAPPassword string
+	IMAPPassword Secret
+	// IMAPSecurity is SecurityTLS, SecurityStartTLS or SecurityNone
+	IMAPSecurity string
 
 	// SMTP settings
 	SMTPHost     string
 	SMTPPort     int
 	SMTPUsername string
-	SMTPPassword string
+	SMTPPassword Secret
+	SMTPSecurity string
+	// SMTPAuthMechanism is the password mechanism: SMTPAuthAuto or one of
+	// SMTPAuthPlain, SMTPAuthLogin and SMTPAuthCRAMMD5
+	SMTPAuthMechanism string
+	// SMTPEHLOHostname is the name sent with EHLO
+	SMTPEHLOHostname string
+	// SMTPIdleTimeout is how long an authenticated SMTP connection is kept
+	// for the next message, and SMTPMaxIdle how many are kept
+	SMTPIdleTimeout time.Duration
+	SMTPMaxIdle     int
+	// SMTPPartialDelivery is what happens when the server rejects some
+	// recipients: PartialDeliveryReject or PartialDeliveryAccept
+	SMTPPartialDelivery string
+	// SMTPDSNNotify and SMTPDSNReturn request delivery status notifications
+	// by default when the server supports them; empty requests none
+	SMTPDSNNotify []string
+	SMTPDSNReturn string
+
+	// Identities are the addresses the account sends as, the first by
+	// default; without any it sends as SMTPUsername
+	Identities []Identity
+
+	// TLS applies to both IMAP and SMTP
+	TLS TLSConfig
+
+	// OAuth2 authenticates both IMAP and SMTP with access tokens instead
+	// of the passwords; nil when not configured
+	OAuth2 *OAuth2Config
 }
 
-// LoadConfig loads configuration from environment variables
-func LoadConfig() (*Config, error) {
+// LoadConfig loads configuration from the YAML or TOML file at path, if
+// not empty, and from environment variables, which override the file.
+// Every malformed setting is reported at once.
+func LoadConfig(path string) (*Config, error) {
+	file := fileConfig{
+		LogLevel:          "info",
+		SearchResultLimit: 100,
+		Cache: fileCacheConfig{
+			Path:             "/data/email_cache.db",
+			EvictionInterval: time.Hour,
+			VacuumInterval:   7 * 24 * time.Hour,
+		},
+	}
+	if path != "" {
+		if err := readConfigFile(path, &file); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs ValidationErrors
+	env := envOverlay{errs: &errs}
+	env.string("LOG_LEVEL", &file.LogLevel)
+	env.int("SEARCH_RESULT_LIMIT", "search_result_limit", &file.SearchResultLimit)
+	env.string("CACHE_PATH", &file.Cache.Path)
+	if retention := os.Getenv("CACHE_RETENTION"); retention != "" {
+		file.Cache.Retention = []string{retention}
+	}
+	env.duration("CACHE_EVICTION_INTERVAL", "cache.eviction_interval", &file.Cache.EvictionInterval)
+	env.duration("CACHE_VACUUM_INTERVAL", "cache.vacuum_interval", &file.Cache.VacuumInterval)
+	encryption := &file.Cache.Encryption
+	if key, keyFile := os.Getenv("CACHE_ENCRYPTION_KEY"), os.Getenv("CACHE_ENCRYPTION_KEY_FILE"); key != "" || keyFile != "" {
+		encryption.Key, encryption.KeyFile = key, keyFile
+	}
+	env.bool("CACHE_ENCRYPTION_INDEX_TEXT", "cache.encryption.index_text", &encryption.IndexText)
+	env.string("SECRETS_FILE", &file.Secrets.File)
+	if key, keyFile := os.Getenv("SECRETS_KEY"), os.Getenv("SECRETS_KEY_FILE"); key != "" || keyFile != "" {
+		file.Secrets.Key, file.Secrets.KeyFile = key, keyFile
+	}
+
 	cfg := &Config{
-		CachePath:         getEnv("CACHE_PATH", "/data/email_cache.db"),
-		SearchResultLimit: getEnvInt("SEARCH_RESULT_LIMIT", 100),
-		LogLevel:          getEnv("LOG_LEVEL", "info"),
+		CachePath:         file.Cache.Path,
+		SearchResultLimit: file.SearchResultLimit,
+		LogLevel:          file.LogLevel,
+		EvictionInterval:  file.Cache.EvictionInterval,
+		VacuumInterval:    file.Cache.VacuumInterval,
 	}
 
-	// Load accounts
-	accounts, err := loadAccounts()
+	retention, err := ParseRetention(strings.Join(file.Cache.Retention, ";"))
 	if err != nil {
-		return nil, fmt.Errorf("failed to load accounts: %w", err)
+		errs.add("cache.retention", err.Error())
 	}
+	cfg.Retention = retention
 
-	if len(accounts) == 0 {
-		return nil, fmt.Errorf("no email accounts configured")
+	cfg.Encryption = loadEncryption(encryption, &errs)
+	cfg.Secrets = loadSecretsFile(&file.Secrets, &errs)
+
+	cfg.Accounts, cfg.accountEnv = loadAccounts(file.Accounts, &env, cfg.Secrets)
+	for i := range cfg.Accounts {
+		if oauth2 := cfg.Accounts[i].OAuth2; oauth2 != nil {
+			oauth2.setTokenStore(cfg.CachePath, cfg.Accounts[i].Name)
+		}
 	}
 
-	cfg.Accounts = accounts
+	if len(errs) > 0 {
+		errs.describe(cfg)
+		return nil, errs
+	}
 	return cfg, nil
 }
 
-// loadAccounts loads email account configurations from environment variables
-func loadAccounts() ([]AccountConfig, error) {
-	var accounts []AccountConfig
+// loadEncryption decodes the cache encryption keys, given directly or in a
+// key file. Either holds one or more keys separated by commas or newlines,
+// newest first.
+func loadEncryption(settings *fileEncryptionConfig, errs *ValidationErrors) EncryptionConfig {
+	keys, ok := readKeys("cache.encryption", settings.Key, settings.KeyFile, errs)
+	if !ok {
+		return EncryptionConfig{}
+	}
 
-	// First, try single account configuration (for backward compatibility)
-	if hasSingleAccount() {
-		account, err := loadSingleAccount()
-		if err != nil {
-			return nil, err
+	if settings.IndexText && len(keys) == 0 {
+		errs.add("cache.encryption.index_text", "requires an encryption key")
+	}
+
+	return EncryptionConfig{Keys: keys, IndexText: settings.IndexText}
+}
+
+// loadSecretsFile returns the configured secrets file, or nil
+func loadSecretsFile(settings *fileSecrets, errs *ValidationErrors) *SecretsFile {
+	keys, ok := readKeys("secrets", settings.Key, settings.KeyFile, errs)
+	if !ok {
+		return nil
+	}
+	if settings.File == "" {
+		if len(keys) > 0 {
+			errs.add("secrets.file", "required with a secrets key")
 		}
-		accounts = append(accounts, *account)
-		return accounts, nil
+		return nil
+	}
+	if len(keys) == 0 {
+		errs.add("secrets.key", "required with a secrets file: set key or key_file")
+		return nil
 	}
+	secrets, _ := NewSecretsFile(settings.File, keys)
+	return secrets
+}
 
-	// Load multiple accounts (ACCOUNT_1_*, ACCOUNT_2_*, etc.)
-	accountNum := 1
-	for {
-		account, err := loadAccountByNumber(accountNum)
+// readKeys decodes the keys given directly or in a key file under the
+// setting at path
+func readKeys(path, keyText, keyFile string, errs *ValidationErrors) ([][]byte, bool) {
+	if keyFile != "" {
+		if keyText != "" {
+			errs.add(path, "set only one of key and key_file")
+			return nil, false
+		}
+		data, err := os.ReadFile(keyFile)
 		if err != nil {
-			break // No more accounts
+			errs.add(path+".key_file", err.Error())
+			return nil, false
 		}
-		accounts = append(accounts, *account)
-		accountNum++
+		keyText = string(data)
 	}
 
-	if len(accounts) == 0 {
-		return nil, fmt.Errorf("no accounts found in environment variables")
+	keys, err := parseKeys(keyText)
+	if err != nil {
+		errs.add(path+".key", err.Error())
+		return nil, false
 	}
-
-	return accounts, nil
+	return keys, true
 }
 
-// hasSingleAccount checks if single account configuration exists
-func hasSingleAccount() bool {
-	return getEnv("IMAP_HOST", "") != "" && getEnv("SMTP_HOST", "") != ""
-}
+// parseKeys decodes base64 or hex encoded 256-bit keys separated by commas
+// or whitespace
+func parseKeys(text string) ([][]byte, error) {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
 
-// loadSingleAccount loads a single account from environment variables
-func loadSingleAccount() (*AccountConfig, error) {
-	imapHost := getEnv("IMAP_HOST", "")
-	imapPort := getEnvInt("IMAP_PORT", 993)
-	imapUsername := getEnv("IMAP_USERNAME", "")
-	imapPassword := getEnv("IMAP_PASSWORD", "")
+	var keys [][]byte
+	for i, field := range fields {
+		key, err := decodeKey(field)
+		if err != nil {
+			return nil, fmt.Errorf("cache encryption key %d: %w", i+1, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
 
-	smtpHost := getEnv("SMTP_HOST", "")
-	smtpPort := getEnvInt("SMTP_PORT", 587)
-	smtpUsername := getEnv("SMTP_USERNAME", "")
-	smtpPassword := getEnv("SMTP_PASSWORD", "")
+// decodeKey decodes one key, without echoing it in errors
+func decodeKey(text string) ([]byte, error) {
+	if len(text) == 64 {
+		if key, err := hex.DecodeString(text); err == nil {
+			return key, nil
+		}
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if key, err := encoding.DecodeString(text); err == nil {
+			if len(key) != 32 {
+				return nil, fmt.Errorf("must be 32 bytes, got %d", len(key))
+			}
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("must be 32 bytes encoded as base64 or hex")
+}
 
-	if imapHost == "" || smtpHost == "" {
-		return nil, fmt.Errorf("IMAP_HOST and SMTP_HOST are required")
+// loadAccounts combines the accounts of the config file with those set by
+// environment variables. ACCOUNT_<N>_* variables override the Nth account
+// of the file, or add an account when the file has fewer; without a file
+// the unnumbered IMAP_*, SMTP_* and ACCOUNT_NAME variables describe a single
+// account. It also returns the variable prefix of each account.
+func loadAccounts(fileAccounts []fileAccount, env *envOverlay, secrets *SecretsFile) ([]AccountConfig, []string) {
+	var prefixes []string
+	for i := range fileAccounts {
+		prefixes = append(prefixes, fmt.Sprintf("ACCOUNT_%d_", i+1))
 	}
 
-	if imapUsername == "" || smtpUsername == "" {
-		return nil, fmt.Errorf("IMAP_USERNAME and SMTP_USERNAME are required")
+	switch {
+	case len(fileAccounts) == 0 && hasSingleAccount():
+		account := fileAccount{Name: getEnv("ACCOUNT_NAME", defaultAccountName)}
+		fileAccounts = append(fileAccounts, account)
+		prefixes = append(prefixes, "")
+	default:
+		for _, num := range envNumbers("ACCOUNT_") {
+			if num > len(fileAccounts) {
+				fileAccounts = append(fileAccounts, fileAccount{})
+				prefixes = append(prefixes, fmt.Sprintf("ACCOUNT_%d_", num))
+			}
+		}
 	}
 
-	if imapPassword == "" || smtpPassword == "" {
-		return nil, fmt.Errorf("IMAP_PASSWORD and SMTP_PASSWORD are required")
+	accounts := make([]AccountConfig, len(fileAccounts))
+	for i := range fileAccounts {
+		account := &fileAccounts[i]
+		prefix := prefixes[i]
+		if prefix != "" {
+			env.string(prefix+"NAME", &account.Name)
+		}
+		env.server(prefix+"IMAP_", fmt.Sprintf("accounts[%d].imap", i), &account.IMAP)
+		env.smtp(prefix+"SMTP_", fmt.Sprintf("accounts[%d].smtp", i), &account.SMTP)
+		env.oauth2(prefix, &account.OAuth2)
+		env.tls(prefix, fmt.Sprintf("accounts[%d].tls", i), &account.TLS)
+		env.identities(prefix, &account.Identities)
+
+		if account.IMAP.Port == 0 {
+			account.IMAP.Port = 993
+		}
+		if account.SMTP.Port == 0 {
+			account.SMTP.Port = 587
+		}
+		// Without a setting, IMAP uses STARTTLS on port 143 and implicit
+		// TLS elsewhere, and SMTP implicit TLS on port 465 and STARTTLS
+		// elsewhere
+		if account.IMAP.Security == "" {
+			account.IMAP.Security = SecurityTLS
+			if account.IMAP.Port == 143 {
+				account.IMAP.Security = SecurityStartTLS
+			}
+		}
+		if account.SMTP.AuthMechanism == "" {
+			account.SMTP.AuthMechanism = SMTPAuthAuto
+		}
+		if account.SMTP.IdleTimeout == 0 {
+			account.SMTP.IdleTimeout = DefaultSMTPIdleTimeout
+		}
+		if account.SMTP.MaxIdle == 0 {
+			account.SMTP.MaxIdle = DefaultSMTPMaxIdle
+		}
+		if account.SMTP.PartialDelivery == "" {
+			account.SMTP.PartialDelivery = PartialDeliveryReject
+		}
+		if account.SMTP.Security == "" {
+			account.SMTP.Security = SecurityStartTLS
+			if account.SMTP.Port == 465 {
+				account.SMTP.Security = SecurityTLS
+			}
+		}
+
+		accounts[i] = AccountConfig{
+			Name:                account.Name,
+			IMAPHost:            account.IMAP.Host,
+			IMAPPort:            account.IMAP.Port,
+			IMAPUsername:        account.IMAP.Username,
+			IMAPPassword:        account.IMAP.secret(fmt.Sprintf("accounts[%d].imap", i), secrets, env.errs),
+			IMAPSecurity:        strings.ToLower(account.IMAP.Security),
+			SMTPHost:            account.SMTP.Host,
+			SMTPPort:            account.SMTP.Port,
+			SMTPUsername:        account.SMTP.Username,
+			SMTPPassword:        account.SMTP.secret(fmt.Sprintf("accounts[%d].smtp", i), secrets, env.errs),
+			SMTPSecurity:        strings.ToLower(account.SMTP.Security),
+			SMTPAuthMechanism:   strings.ToLower(account.SMTP.AuthMechanism),
+			SMTPEHLOHostname:    account.SMTP.EHLOHostname,
+			SMTPIdleTimeout:     account.SMTP.IdleTimeout,
+			SMTPMaxIdle:         account.SMTP.MaxIdle,
+			SMTPPartialDelivery: strings.ToLower(account.SMTP.PartialDelivery),
+			SMTPDSNNotify:       lowerAll(account.SMTP.DSNNotify),
+			SMTPDSNReturn:       strings.ToLower(account.SMTP.DSNReturn),
+			Identities:          identitiesConfig(account.Identities),
+			TLS:                 account.TLS.config(),
+			OAuth2:              account.OAuth2.config(fmt.Sprintf("accounts[%d].oauth2", i), secrets, env.errs),
+		}
 	}
+	return accounts, prefixes
+}
+
+// hasSingleAccount checks if single account configuration exists
+func hasSingleAccount() bool {
+	return getEnv("IMAP_HOST", "") != "" && getEnv("SMTP_HOST", "") != ""
+}
 
-	// Default account name
-	name := getEnv("ACCOUNT_NAME", defaultAccountName)
-	if name == "" {
-		name = defaultAccountName
+// envNumbers returns the N of every <prefix><N>_* environment variable, such
+// as ACCOUNT_<N>_*, in ascending order; numbers may have gaps
+func envNumbers(prefix string) []int {
+	seen := make(map[int]bool)
+	for _, entry := range os.Environ() {
+		rest, ok := strings.CutPrefix(entry, prefix)
+		if !ok {
+			continue
+		}
+		digits, _, ok := strings.Cut(rest, "_")
+		if num, err := strconv.Atoi(digits); ok && err == nil && num > 0 {
+			seen[num] = true
+		}
 	}
 
-	return &AccountConfig{
-		Name:         name,
-		IMAPHost:     imapHost,
-		IMAPPort:     imapPort,
-		IMAPUsername: imapUsername,
-		IMAPPassword: imapPassword,
-		SMTPHost:     smtpHost,
-		SMTPPort:     smtpPort,
-		SMTPUsername: smtpUsername,
-		SMTPPassword: smtpPassword,
-	}, nil
+	numbers := make([]int, 0, len(seen))
+	for num := range seen {
+		numbers = append(numbers, num)
+	}
+	sort.Ints(numbers)
+	return numbers
 }
 
-// loadAccountByNumber loads an account by number (ACCOUNT_1_*, ACCOUNT_2_*, etc.)
-func loadAccountByNumber(num int) (*AccountConfig, error) {
-	prefix := fmt.Sprintf("ACCOUNT_%d_", num)
+// envOverlay overrides settings with the environment variables that are
+// set, recording malformed values
+type envOverlay struct {
+	errs *ValidationErrors
+}
 
-	name := getEnv(prefix+"NAME", "")
-	if name == "" {
-		return nil, fmt.Errorf("account %d: NAME is required", num)
+func (o *envOverlay) string(name string, dest *string) {
+	if value := os.Getenv(name); value != "" {
+		*dest = value
 	}
+}
 
-	imapHost := getEnv(prefix+"IMAP_HOST", "")
-	imapPort := getEnvInt(prefix+"IMAP_PORT", 993)
-	imapUsername := getEnv(prefix+"IMAP_USERNAME", "")
-	imapPassword := getEnv(prefix+"IMAP_PASSWORD", "")
+func (o *envOverlay) int(name, path string, dest *int) {
+	if value := os.Getenv(name); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			o.errs.add(path, fmt.Sprintf("must be an integer, got %q", value))
+			return
+		}
+		*dest = n
+	}
+}
 
-	smtpHost := getEnv(prefix+"SMTP_HOST", "")
-	smtpPort := getEnvInt(prefix+"SMTP_PORT", 587)
-	smtpUsername := getEnv(prefix+"SMTP_USERNAME", "")
-	smtpPassword := getEnv(prefix+"SMTP_PASSWORD", "")
+func (o *envOverlay) bool(name, path string, dest *bool) {
+	if value := os.Getenv(name); value != "" {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			o.errs.add(path, fmt.Sprintf("must be true or false, got %q", value))
+			return
+		}
+		*dest = b
+	}
+}
 
-	if imapHost == "" || smtpHost == "" {
-		return nil, fmt.Errorf("account %d: IMAP_HOST and SMTP_HOST are required", num)
+// duration also accepts d, w and y units
+func (o *envOverlay) duration(name, path string, dest *time.Duration) {
+	if value := os.Getenv(name); value != "" {
+		d, err := ParseAge(value)
+		if err != nil {
+			o.errs.add(path, err.Error())
+			return
+		}
+		*dest = d
 	}
+}
 
-	if imapUsername == "" || smtpUsername == "" {
-		return nil, fmt.Errorf("account %d: IMAP_USERNAME and SMTP_USERNAME are required", num)
+// server overrides an IMAP or SMTP block from <prefix>HOST, PORT and
+// USERNAME. A PASSWORD, PASSWORD_FILE, PASSWORD_COMMAND or PASSWORD_SECRET
+// variable replaces however the block gave its password.
+func (o *envOverlay) server(prefix, path string, dest *fileServer) {
+	o.string(prefix+"HOST", &dest.Host)
+	o.int(prefix+"PORT", path+".port", &dest.Port)
+	o.string(prefix+"USERNAME", &dest.Username)
+	o.string(prefix+"SECURITY", &dest.Security)
+
+	password := fileServer{
+		Password:        os.Getenv(prefix + "PASSWORD"),
+		PasswordFile:    os.Getenv(prefix + "PASSWORD_FILE"),
+		PasswordCommand: os.Getenv(prefix + "PASSWORD_COMMAND"),
+		PasswordSecret:  os.Getenv(prefix + "PASSWORD_SECRET"),
+	}
+	if password.secretSources() > 0 {
+		dest.Password, dest.PasswordFile = password.Password, password.PasswordFile
+		dest.PasswordCommand, dest.PasswordSecret = password.PasswordCommand, password.PasswordSecret
 	}
+}
 
-	if imapPassword == "" || smtpPassword == "" {
-		return nil, fmt.Errorf("account %d: IMAP_PASSWORD and SMTP_PASSWORD are required", num)
+// secretSources counts the ways the block gives its password
+func (s *fileServer) secretSources() int {
+	n := 0
+	for _, source := range []string{s.Password, s.PasswordFile, s.PasswordCommand, s.PasswordSecret} {
+		if source != "" {
+			n++
+		}
 	}
+	return n
+}
 
-	return &AccountConfig{
-		Name:         name,
-		IMAPHost:     imapHost,
-		IMAPPort:     imapPort,
-		IMAPUsername: imapUsername,
-		IMAPPassword: imapPassword,
-		SMTPHost:     smtpHost,
-		SMTPPort:     smtpPort,
-		SMTPUsername: smtpUsername,
-		SMTPPassword: smtpPassword,
-	}, nil
+// secret returns the password of the block at path
+func (s *fileServer) secret(path string, secrets *SecretsFile, errs *ValidationErrors) Secret {
+	if s.secretSources() > 1 {
+		errs.add(path+".password", "set only one of password, password_file, password_command and password_secret")
+		return Secret{}
+	}
+	if s.PasswordSecret != "" && secrets == nil {
+		errs.add(path+".password_secret", "requires a secrets file")
+		return Secret{}
+	}
+	return Secret{
+		value:   s.Password,
+		file:    s.PasswordFile,
+		command: s.PasswordCommand,
+		name:    s.PasswordSecret,
+		secrets: secrets,
+	}
 }
 
 // getEnv gets an environment variable or returns a default value
@@ -190,16 +498,6 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-// getEnvInt gets an environment variable as an integer or returns a default value
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
-		}
-	}
-	return defaultValue
-}
-
 // GetAccountByName finds an account by name
 func (c *Config) GetAccountByName(name string) (*AccountConfig, error) {
 	for i := range c.Accounts {
@@ -227,40 +525,6 @@ func (c *Config) GetDefaultAccount() *AccountConfig {
 	return &c.Accounts[0]
 }
 
-// Validate validates the configuration
-func (c *Config) Validate() error {
-	if c.CachePath == "" {
-		return fmt.Errorf("CACHE_PATH is required")
-	}
-
-	if c.SearchResultLimit < 1 || c.SearchResultLimit > 1000 {
-		return fmt.Errorf("SEARCH_RESULT_LIMIT must be between 1 and 1000")
-	}
-
-	if len(c.Accounts) == 0 {
-		return fmt.Errorf("at least one account must be configured")
-	}
-
-	// Validate each account
-	for i := range c.Accounts {
-		acc := &c.Accounts[i]
-		if acc.IMAPHost == "" {
-			return fmt.Errorf("account %s: IMAP_HOST is required", acc.Name)
-		}
-		if acc.SMTPHost == "" {
-			return fmt.Errorf("account %s: SMTP_HOST is required", acc.Name)
-		}
-		if acc.IMAPPort < 1 || acc.IMAPPort > 65535 {
-			return fmt.Errorf("account %s: invalid IMAP_PORT", acc.Name)
-		}
-		if acc.SMTPPort < 1 || acc.SMTPPort > 65535 {
-			return fmt.Errorf("account %s: invalid SMTP_PORT", acc.Name)
-		}
-	}
-
-	return nil
-}
-
 // AccountNames returns a list of all account names
 func (c *Config) AccountNames() []string {
 	names := make([]string, len(c.Accounts))
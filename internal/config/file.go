@@ -0,0 +1,329 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the layout of a YAML or TOML configuration file. Keys are
+// given by the config tags; every string value may reference environment
+// variables as ${NAME} or ${NAME:-default}.
+type fileConfig struct {
+	LogLevel          string          `config:"log_level"`
+	SearchResultLimit int             `config:"search_result_limit"`
+	Cache             fileCacheConfig `config:"cache"`
+	Secrets           fileSecrets     `config:"secrets"`
+	Accounts          []fileAccount   `config:"accounts"`
+}
+
+type fileCacheConfig struct {
+	Path string `config:"path"`
+	// Retention is one CACHE_RETENTION string or a list of its rules
+	Retention        []string             `config:"retention"`
+	EvictionInterval time.Duration        `config:"eviction_interval"`
+	VacuumInterval   time.Duration        `config:"vacuum_interval"`
+	Encryption       fileEncryptionConfig `config:"encryption"`
+}
+
+type fileEncryptionConfig struct {
+	Key       string `config:"key"`
+	KeyFile   string `config:"key_file"`
+	IndexText bool   `config:"index_text"`
+}
+
+// fileSecrets locates the encrypted secrets file and its keys
+type fileSecrets struct {
+	File    string `config:"file"`
+	Key     string `config:"key"`
+	KeyFile string `config:"key_file"`
+}
+
+type fileAccount struct {
+	Name       string         `config:"name"`
+	IMAP       fileServer     `config:"imap"`
+	SMTP       fileSMTP       `config:"smtp"`
+	OAuth2     fileOAuth2     `config:"oauth2"`
+	TLS        fileTLS        `config:"tls"`
+	Identities []fileIdentity `config:"identities"`
+}
+
+// fileServer is an IMAP or SMTP block. The password is given by at most
+// one of Password, PasswordFile, PasswordCommand and PasswordSecret.
+type fileServer struct {
+	Host            string `config:"host"`
+	Port            int    `config:"port"`
+	Username        string `config:"username"`
+	Security        string `config:"security"`
+	Password        string `config:"password"`
+	PasswordFile    string `config:"password_file"`
+	PasswordCommand string `config:"password_command"`
+	PasswordSecret  string `config:"password_secret"`
+}
+
+// readConfigFile parses a .yaml, .yml or .toml configuration file into
+// file, expanding environment variable references. Settings missing from
+// the file keep their current values.
+func readConfigFile(path string, file *fileConfig) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var tree map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return fmt.Errorf("unsupported config file format %q: use .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	var errs ValidationErrors
+	decodeValue("", tree, reflect.ValueOf(file).Elem(), &errs)
+	if len(errs) > 0 {
+		return fmt.Errorf("config file %s: %w", path, errs)
+	}
+	return nil
+}
+
+// decodeValue stores a parsed value in dest, recording every problem under
+// its field path instead of stopping at the first
+func decodeValue(path string, value interface{}, dest reflect.Value, errs *ValidationErrors) {
+	if value == nil {
+		return
+	}
+
+	if dest.Type() == reflect.TypeOf(time.Duration(0)) {
+		text, ok := decodeString(path, value, errs)
+		if !ok {
+			return
+		}
+		d, err := ParseAge(text)
+		if err != nil {
+			errs.add(path, err.Error())
+			return
+		}
+		dest.SetInt(int64(d))
+		return
+	}
+
+	switch dest.Kind() {
+	case reflect.Struct:
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			errs.add(path, "must be a table of settings")
+			return
+		}
+		keys := make([]string, 0, len(table))
+		for key := range table {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field, ok := fieldByTag(dest, key)
+			if !ok {
+				errs.add(joinPath(path, key), "unknown setting")
+				continue
+			}
+			decodeValue(joinPath(path, key), table[key], field, errs)
+		}
+
+	case reflect.Slice:
+		items, ok := listItems(value)
+		if !ok {
+			// A single value stands for a list of one
+			if dest.Type().Elem().Kind() != reflect.String {
+				errs.add(path, "must be a list")
+				return
+			}
+			items = []interface{}{value}
+		}
+		list := reflect.MakeSlice(dest.Type(), len(items), len(items))
+		for i, item := range items {
+			decodeValue(fmt.Sprintf("%s[%d]", path, i), item, list.Index(i), errs)
+		}
+		dest.Set(list)
+
+	case reflect.String:
+		if text, ok := decodeString(path, value, errs); ok {
+			dest.SetString(text)
+		}
+
+	case reflect.Int:
+		switch v := value.(type) {
+		case int:
+			dest.SetInt(int64(v))
+		case int64:
+			dest.SetInt(v)
+		case string:
+			text, ok := decodeString(path, v, errs)
+			if !ok {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(text))
+			if err != nil {
+				errs.add(path, fmt.Sprintf("must be an integer, got %q", text))
+				return
+			}
+			dest.SetInt(int64(n))
+		default:
+			errs.add(path, "must be an integer")
+		}
+
+	case reflect.Bool:
+		switch v := value.(type) {
+		case bool:
+			dest.SetBool(v)
+		case string:
+			text, ok := decodeString(path, v, errs)
+			if !ok {
+				return
+			}
+			b, err := strconv.ParseBool(strings.TrimSpace(text))
+			if err != nil {
+				errs.add(path, fmt.Sprintf("must be true or false, got %q", text))
+				return
+			}
+			dest.SetBool(b)
+		default:
+			errs.add(path, "must be true or false")
+		}
+
+	default:
+		errs.add(path, fmt.Sprintf("unsupported setting type %s", dest.Type()))
+	}
+}
+
+// decodeString returns a scalar as text with environment variables
+// expanded. Numbers and booleans are accepted as written, so an unquoted
+// password of digits still works.
+func decodeString(path string, value interface{}, errs *ValidationErrors) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		text, err := expandEnv(v)
+		if err != nil {
+			errs.add(path, err.Error())
+			return "", false
+		}
+		return text, true
+	case int, int64, uint64, float64, bool:
+		return fmt.Sprint(v), true
+	default:
+		errs.add(path, "must be a string")
+		return "", false
+	}
+}
+
+// listItems returns the elements of a parsed list; TOML arrays of tables
+// decode as a slice of maps
+func listItems(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case []map[string]interface{}:
+		items := make([]interface{}, len(v))
+		for i := range v {
+			items[i] = v[i]
+		}
+		return items, true
+	}
+	return nil, false
+}
+
+// fieldByTag finds the field of a struct, or of a struct it embeds, with
+// the config tag key
+func fieldByTag(v reflect.Value, key string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Anonymous && t.Field(i).Type.Kind() == reflect.Struct {
+			if field, ok := fieldByTag(v.Field(i), key); ok {
+				return field, true
+			}
+			continue
+		}
+		if t.Field(i).Tag.Get("config") == key {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// expandEnv replaces ${NAME} with the value of an environment variable and
+// ${NAME:-default} with its value or, when unset or empty, the default.
+// $${ stands for a literal ${. Other dollar signs are left alone, so
+// passwords containing them need no escaping.
+func expandEnv(text string) (string, error) {
+	if !strings.Contains(text, "${") {
+		return text, nil
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(text, "${")
+		if i < 0 {
+			b.WriteString(text)
+			return b.String(), nil
+		}
+		if i > 0 && text[i-1] == '$' {
+			b.WriteString(text[:i-1] + "${")
+			text = text[i+2:]
+			continue
+		}
+		b.WriteString(text[:i])
+
+		end := strings.IndexByte(text[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", text[i:])
+		}
+		expr := text[i+2 : i+end]
+		text = text[i+end+1:]
+
+		name, fallback, hasFallback := strings.Cut(expr, ":-")
+		if !isEnvName(name) {
+			return "", fmt.Errorf("invalid environment variable name %q", name)
+		}
+		value, set := os.LookupEnv(name)
+		switch {
+		case value != "":
+			b.WriteString(value)
+		case hasFallback:
+			b.WriteString(fallback)
+		case set:
+			// Set but empty
+		default:
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+	}
+}
+
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if r != '_' && (r < 'A' || r > 'Z') && (r < 'a' || r > 'z') && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// Identity is an address an account sends as. The header From shows the
+// identity, while the envelope sender, where bounces go, may differ.
+type Identity struct {
+	// Name is the display name in the From header
+	Name    string
+	Address string
+	// ReplyTo is the default Reply-To of messages sent as the identity
+	ReplyTo string
+	// Signature is appended to messages, after a "-- " line
+	Signature string
+	// EnvelopeFrom is the envelope sender (MAIL FROM)
+	EnvelopeFrom string
+}
+
+// fileIdentity is an entry of an account's identities list in a config file
+type fileIdentity struct {
+	Name         string `config:"name"`
+	Address      string `config:"address"`
+	ReplyTo      string `config:"reply_to"`
+	Signature    string `config:"signature"`
+	EnvelopeFrom string `config:"envelope_from"`
+}
+
+// identities overrides an identities list from <prefix>IDENTITY_<M>_*
+// variables, M counting from 1
+func (o *envOverlay) identities(prefix string, dest *[]fileIdentity) {
+	prefix += "IDENTITY_"
+	for _, num := range envNumbers(prefix) {
+		for len(*dest) < num {
+			*dest = append(*dest, fileIdentity{})
+		}
+		identity := &(*dest)[num-1]
+		identityPrefix := fmt.Sprintf("%s%d_", prefix, num)
+		o.string(identityPrefix+"NAME", &identity.Name)
+		o.string(identityPrefix+"ADDRESS", &identity.Address)
+		o.string(identityPrefix+"REPLY_TO", &identity.ReplyTo)
+		o.string(identityPrefix+"SIGNATURE", &identity.Signature)
+		o.string(identityPrefix+"ENVELOPE_FROM", &identity.EnvelopeFrom)
+	}
+}
+
+func identitiesConfig(identities []fileIdentity) []Identity {
+	if len(identities) == 0 {
+		return nil
+	}
+	result := make([]Identity, len(identities))
+	for i, identity := range identities {
+		result[i] = Identity(identity)
+	}
+	return result
+}
+
+// SendAs returns the identity to send as for a from parameter: an address
+// of one of the account's identities, optionally with a display name that
+// replaces the identity's. An empty from selects the first identity, or
+// the SMTP username if the account has none.
+func (a *AccountConfig) SendAs(from string) (Identity, error) {
+	identities := a.Identities
+	if len(identities) == 0 {
+		identities = []Identity{{Address: a.SMTPUsername}}
+	}
+
+	identity := identities[0]
+	if from != "" {
+		parsed, err := mail.ParseAddress(from)
+		if err != nil {
+			return Identity{}, fmt.Errorf("invalid from address %q: %w", from, err)
+		}
+		found := false
+		for _, candidate := range identities {
+			if strings.EqualFold(candidate.Address, parsed.Address) {
+				identity, found = candidate, true
+				break
+			}
+		}
+		if !found {
+			addresses := make([]string, len(identities))
+			for i, candidate := range identities {
+				addresses[i] = candidate.Address
+			}
+			return Identity{}, fmt.Errorf("account %s cannot send as %s; its identities are %s", a.Name, parsed.Address, strings.Join(addresses, ", "))
+		}
+		if parsed.Name != "" {
+			identity.Name = parsed.Name
+		}
+	}
+
+	// Bounces go to the login address when it is one, as before identities
+	// existed; relays with plain usernames get the identity's address
+	if identity.EnvelopeFrom == "" {
+		identity.EnvelopeFrom = identity.Address
+		if strings.Contains(a.SMTPUsername, "@") {
+			identity.EnvelopeFrom = a.SMTPUsername
+		}
+	}
+	return identity, nil
+}
+
+// validateIdentities checks an account's identities list
+func validateIdentities(errs *ValidationErrors, path string, identities []Identity) {
+	seen := make(map[string]int)
+	for i, identity := range identities {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+		address := strings.ToLower(identity.Address)
+		switch {
+		case identity.Address == "":
+			errs.add(itemPath+".address", "required")
+		case !isBareAddress(identity.Address):
+			errs.add(itemPath+".address", "must be an address such as ann@example.com; set the display name in name")
+		default:
+			if first, ok := seen[address]; ok {
+				errs.add(itemPath+".address", fmt.Sprintf("duplicates %s[%d]", path, first))
+			} else {
+				seen[address] = i
+			}
+		}
+		if identity.ReplyTo != "" {
+			if _, err := mail.ParseAddressList(identity.ReplyTo); err != nil {
+				errs.add(itemPath+".reply_to", "must be a list of addresses")
+			}
+		}
+		if identity.EnvelopeFrom != "" && !isBareAddress(identity.EnvelopeFrom) {
+			errs.add(itemPath+".envelope_from", "must be an address such as bounces@example.com")
+		}
+		if strings.ContainsAny(identity.Name, "\r\n") {
+			errs.add(itemPath+".name", "must be a single line")
+		}
+	}
+}
+
+func isBareAddress(value string) bool {
+	parsed, err := mail.ParseAddress(value)
+	return err == nil && parsed.Name == "" && parsed.Address == value
+}
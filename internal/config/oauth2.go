@@ -0,0 +1,237 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// SASL mechanisms for OAuth2 access tokens
+const (
+	MechanismAuto        = "auto"
+	MechanismXOAuth2     = "xoauth2"
+	MechanismOAuthBearer = "oauthbearer"
+)
+
+// OAuth2Config holds the OAuth2 client of an account. Access tokens are
+// obtained with the refresh token and saved, with any rotated refresh
+// token, to the entry TokenSecret of the secrets file if one is configured
+// and to TokenFile otherwise.
+type OAuth2Config struct {
+	// Provider is "google", "microsoft" or empty; it fills in the
+	// endpoints and scopes
+	Provider     string
+	ClientID     string
+	ClientSecret Secret
+	TokenURL     string
+	// AuthURL and DeviceAuthURL are the endpoints of the authorization
+	// code and device flows used by the auth command
+	AuthURL       string
+	DeviceAuthURL string
+	Scopes        []string
+	// RefreshToken is used until tokens have been saved
+	RefreshToken Secret
+	// Mechanism is MechanismAuto, MechanismXOAuth2 or MechanismOAuthBearer
+	Mechanism   string
+	TokenFile   string
+	TokenSecret string
+	Secrets     *SecretsFile
+	// RedirectHost is the loopback host of the authorization code flow's
+	// redirect URI
+	RedirectHost string
+}
+
+// OAuth2Provider holds the endpoints and scopes of a well-known provider
+type OAuth2Provider struct {
+	TokenURL      string
+	AuthURL       string
+	DeviceAuthURL string
+	Scopes        []string
+	RedirectHost  string
+}
+
+// OAuth2Providers are the providers that need only a client ID and secret.
+// The openid and email scopes let the auth command show who signed in.
+var OAuth2Providers = map[string]OAuth2Provider{
+	"google": {
+		TokenURL: "https://oauth2.googleapis.com/token",
+		AuthURL:  "https://accounts.google.com/o/oauth2/v2/auth",
+		// Google's device flow does not allow the mail scope
+		Scopes:       []string{"https://mail.google.com/", "openid", "email"},
+		RedirectHost: "127.0.0.1",
+	},
+	"microsoft": {
+		TokenURL:      "https://login.microsoftonline.com/common/oauth2/v2.0/token",
+		AuthURL:       "https://login.microsoftonline.com/common/oauth2/v2.0/authorize",
+		DeviceAuthURL: "https://login.microsoftonline.com/common/oauth2/v2.0/devicecode",
+		Scopes: []string{
+			"https://outlook.office.com/IMAP.AccessAsUser.All",
+			"https://outlook.office.com/SMTP.Send",
+			"offline_access",
+			"openid",
+			"email",
+		},
+		RedirectHost: "localhost",
+	},
+}
+
+// fileOAuth2 is the oauth2 block of an account in a config file
+type fileOAuth2 struct {
+	Provider      string   `config:"provider"`
+	ClientID      string   `config:"client_id"`
+	ClientSecret  string   `config:"client_secret"`
+	TokenURL      string   `config:"token_url"`
+	AuthURL       string   `config:"auth_url"`
+	DeviceAuthURL string   `config:"device_auth_url"`
+	Scopes        []string `config:"scopes"`
+	RefreshToken  string   `config:"refresh_token"`
+	Mechanism     string   `config:"mechanism"`
+	TokenFile     string   `config:"token_file"`
+	TokenSecret   string   `config:"token_secret"`
+}
+
+// configured reports whether the block sets anything
+func (o *fileOAuth2) configured() bool {
+	return o.Provider != "" || o.ClientID != "" || o.ClientSecret != "" || o.TokenURL != "" ||
+		o.AuthURL != "" || o.DeviceAuthURL != "" || len(o.Scopes) > 0 || o.RefreshToken != "" ||
+		o.Mechanism != "" || o.TokenFile != "" || o.TokenSecret != ""
+}
+
+// oauth2 overrides an oauth2 block from <prefix>OAUTH2_* variables
+func (o *envOverlay) oauth2(prefix string, dest *fileOAuth2) {
+	prefix += "OAUTH2_"
+	o.string(prefix+"PROVIDER", &dest.Provider)
+	o.string(prefix+"CLIENT_ID", &dest.ClientID)
+	o.string(prefix+"CLIENT_SECRET", &dest.ClientSecret)
+	o.string(prefix+"TOKEN_URL", &dest.TokenURL)
+	o.string(prefix+"AUTH_URL", &dest.AuthURL)
+	o.string(prefix+"DEVICE_AUTH_URL", &dest.DeviceAuthURL)
+	if scopes := os.Getenv(prefix + "SCOPES"); scopes != "" {
+		dest.Scopes = strings.FieldsFunc(scopes, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+	o.string(prefix+"REFRESH_TOKEN", &dest.RefreshToken)
+	o.string(prefix+"MECHANISM", &dest.Mechanism)
+	o.string(prefix+"TOKEN_FILE", &dest.TokenFile)
+	o.string(prefix+"TOKEN_SECRET", &dest.TokenSecret)
+}
+
+// config returns the OAuth2 settings of the block, or nil when it is empty
+func (o *fileOAuth2) config(path string, secrets *SecretsFile, errs *ValidationErrors) *OAuth2Config {
+	if !o.configured() {
+		return nil
+	}
+
+	cfg := &OAuth2Config{
+		Provider:      strings.ToLower(o.Provider),
+		ClientID:      o.ClientID,
+		ClientSecret:  Secret{value: o.ClientSecret},
+		TokenURL:      o.TokenURL,
+		AuthURL:       o.AuthURL,
+		DeviceAuthURL: o.DeviceAuthURL,
+		Scopes:        o.Scopes,
+		RefreshToken:  Secret{value: o.RefreshToken},
+		Mechanism:     strings.ToLower(o.Mechanism),
+		TokenFile:     o.TokenFile,
+		TokenSecret:   o.TokenSecret,
+		Secrets:       secrets,
+		RedirectHost:  "127.0.0.1",
+	}
+	if cfg.Provider != "" {
+		provider, ok := OAuth2Providers[cfg.Provider]
+		if !ok {
+			errs.add(path+".provider", "must be google or microsoft")
+		}
+		if cfg.TokenURL == "" {
+			cfg.TokenURL = provider.TokenURL
+		}
+		if cfg.AuthURL == "" {
+			cfg.AuthURL = provider.AuthURL
+		}
+		if cfg.DeviceAuthURL == "" {
+			cfg.DeviceAuthURL = provider.DeviceAuthURL
+		}
+		if len(cfg.Scopes) == 0 {
+			cfg.Scopes = provider.Scopes
+		}
+		if provider.RedirectHost != "" {
+			cfg.RedirectHost = provider.RedirectHost
+		}
+	}
+	if cfg.TokenSecret != "" && secrets == nil {
+		errs.add(path+".token_secret", "requires a secrets file")
+	}
+	if cfg.Mechanism == "" {
+		cfg.Mechanism = MechanismAuto
+	}
+	return cfg
+}
+
+// validateOAuth2 checks the oauth2 block at path
+func validateOAuth2(errs *ValidationErrors, path string, cfg *OAuth2Config) {
+	if cfg.ClientID == "" {
+		errs.add(path+".client_id", "required")
+	}
+	if cfg.TokenURL == "" {
+		errs.add(path+".token_url", "required unless provider is set")
+	} else if err := checkEndpoint(cfg.TokenURL); err != nil {
+		errs.add(path+".token_url", err.Error())
+	}
+	if cfg.AuthURL != "" {
+		if err := checkEndpoint(cfg.AuthURL); err != nil {
+			errs.add(path+".auth_url", err.Error())
+		}
+	}
+	if cfg.DeviceAuthURL != "" {
+		if err := checkEndpoint(cfg.DeviceAuthURL); err != nil {
+			errs.add(path+".device_auth_url", err.Error())
+		}
+	}
+	switch cfg.Mechanism {
+	case MechanismAuto, MechanismXOAuth2, MechanismOAuthBearer:
+	default:
+		errs.add(path+".mechanism", "must be auto, xoauth2 or oauthbearer")
+	}
+}
+
+// checkEndpoint requires HTTPS, except on the loopback interface so a local
+// stand-in server can be used for testing
+func checkEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("must be an absolute URL")
+	}
+	if u.Scheme == "https" {
+		return nil
+	}
+	if u.Scheme == "http" {
+		host := u.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("must use https")
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// setTokenStore saves tokens in the secrets file when there is one, and in
+// a file next to the cache otherwise, unless configured
+func (cfg *OAuth2Config) setTokenStore(cachePath, account string) {
+	if cfg.TokenFile != "" || cfg.TokenSecret != "" {
+		return
+	}
+	if cfg.Secrets != nil {
+		cfg.TokenSecret = "oauth2/" + account
+		return
+	}
+	cfg.TokenFile = defaultTokenFile(cachePath, account)
+}
+
+// defaultTokenFile places an account's tokens next to the cache
+func defaultTokenFile(cachePath, account string) string {
+	return filepath.Join(filepath.Dir(cachePath), "oauth2", unsafeFileChars.ReplaceAllString(account, "_")+".json")
+}
@@ -0,0 +1,313 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionRule limits what the cache keeps for the accounts and folders it
+// matches. Every matching rule is enforced, so the strictest limit wins.
+type RetentionRule struct {
+	// Account is an account name or "*" for every account
+	Account string
+	// Folder is a folder path pattern: "*" matches any run of characters
+	// other than "/", "?" any one of them and a backslash escapes the next
+	// character; everything else, including "[" and "]", is literal. Empty
+	// means the limits apply to each matching account as a whole rather
+	// than to each of its folders.
+	Folder string
+
+	// MaxAge evicts messages older than this
+	MaxAge time.Duration
+	// BodyMaxAge drops the bodies of messages older than this, keeping
+	// their headers searchable
+	BodyMaxAge time.Duration
+	// MaxMessages keeps at most this many messages, newest first
+	MaxMessages int
+	// MaxBytes caps the cached content size, dropping bodies of the oldest
+	// messages first and then the messages themselves
+	MaxBytes int64
+}
+
+// String formats the rule as written in CACHE_RETENTION
+func (r RetentionRule) String() string {
+	parts := []string{quoteScope(r.Scope())}
+	if r.MaxAge > 0 {
+		parts = append(parts, "max_age="+formatDuration(r.MaxAge))
+	}
+	if r.BodyMaxAge > 0 {
+		parts = append(parts, "body_max_age="+formatDuration(r.BodyMaxAge))
+	}
+	if r.MaxMessages > 0 {
+		parts = append(parts, fmt.Sprintf("max_messages=%d", r.MaxMessages))
+	}
+	if r.MaxBytes > 0 {
+		parts = append(parts, "max_bytes="+FormatBytes(r.MaxBytes))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Scope returns the account and folder the rule applies to
+func (r RetentionRule) Scope() string {
+	if r.Folder == "" {
+		return r.Account
+	}
+	return r.Account + ":" + r.Folder
+}
+
+// MatchesAccount reports whether the rule applies to the account
+func (r RetentionRule) MatchesAccount(account string) bool {
+	return r.Account == "*" || r.Account == account
+}
+
+// MatchesFolder reports whether the rule applies to each folder it matches
+// separately, and matches the given one
+func (r RetentionRule) MatchesFolder(account, folder string) bool {
+	if r.Folder == "" || !r.MatchesAccount(account) {
+		return false
+	}
+	ok, _ := path.Match(folderPattern(r.Folder), folder)
+	return ok
+}
+
+// folderPattern turns a Folder pattern into path.Match syntax, escaping
+// brackets so folders such as [Gmail]/Spam are matched literally
+func folderPattern(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '\\':
+			b.WriteByte(c)
+			if i+1 < len(pattern) {
+				i++
+				b.WriteByte(pattern[i])
+			}
+		case '[', ']':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// RetentionRules is the cache retention policy
+type RetentionRules []RetentionRule
+
+// Ages returns the strictest MaxAge and BodyMaxAge that apply to messages
+// in a folder; zero means unlimited
+func (rules RetentionRules) Ages(account, folder string) (maxAge, bodyMaxAge time.Duration) {
+	for _, r := range rules {
+		if !r.MatchesAccount(account) || (r.Folder != "" && !r.MatchesFolder(account, folder)) {
+			continue
+		}
+		maxAge = minLimit(maxAge, r.MaxAge)
+		bodyMaxAge = minLimit(bodyMaxAge, r.BodyMaxAge)
+	}
+	return maxAge, bodyMaxAge
+}
+
+// minLimit returns the smaller of two limits where zero means unlimited
+func minLimit(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// ParseRetention parses CACHE_RETENTION: rules separated by semicolons,
+// each an optional scope followed by limits, e.g.
+//
+//	max_age=2y max_bytes=2GB; *:Trash max_age=30d; *:"[Gmail]/All Mail" body_max_age=1y
+//
+// A scope is an account name or "*", optionally followed by ":" and a folder
+// pattern. Rules without a scope apply to every account. Double quotes keep
+// spaces and semicolons in a scope, as in a shell; within them a backslash
+// escapes a quote or backslash.
+func ParseRetention(text string) (RetentionRules, error) {
+	parts, err := splitRetention(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules RetentionRules
+	for i, fields := range parts {
+		if len(fields) == 0 {
+			continue
+		}
+
+		rule := RetentionRule{Account: "*"}
+		if fields[0].quoted || !strings.Contains(fields[0].text, "=") {
+			account, folder, _ := strings.Cut(fields[0].text, ":")
+			if account == "" {
+				account = "*"
+			}
+			if _, err := path.Match(folderPattern(folder), ""); err != nil {
+				return nil, fmt.Errorf("retention rule %d: invalid folder pattern %q", i+1, folder)
+			}
+			rule.Account, rule.Folder = account, folder
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("retention rule %d: no limits for %s", i+1, rule.Scope())
+		}
+
+		for _, field := range fields {
+			key, value, ok := strings.Cut(field.text, "=")
+			if !ok {
+				return nil, fmt.Errorf("retention rule %d: expected key=value, got %q", i+1, field.text)
+			}
+			var err error
+			switch key {
+			case "max_age":
+				rule.MaxAge, err = ParseAge(value)
+			case "body_max_age":
+				rule.BodyMaxAge, err = ParseAge(value)
+			case "max_messages":
+				rule.MaxMessages, err = strconv.Atoi(value)
+				if err == nil && rule.MaxMessages <= 0 {
+					err = fmt.Errorf("must be positive")
+				}
+			case "max_bytes":
+				rule.MaxBytes, err = ParseBytes(value)
+			default:
+				err = fmt.Errorf("unknown limit (expected max_age, body_max_age, max_messages or max_bytes)")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("retention rule %d: %s: %w", i+1, key, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// retentionField is a word of a retention rule
+type retentionField struct {
+	text string
+	// quoted is set when any of the word was quoted
+	quoted bool
+}
+
+// splitRetention splits CACHE_RETENTION into rules at semicolons and each
+// rule into words at spaces, except within double quotes
+func splitRetention(text string) ([][]retentionField, error) {
+	var rules [][]retentionField
+	var fields []retentionField
+	var word strings.Builder
+	inWord, quoted, inQuotes := false, false, false
+
+	endWord := func() {
+		if inWord {
+			fields = append(fields, retentionField{text: word.String(), quoted: quoted})
+		}
+		word.Reset()
+		inWord, quoted = false, false
+	}
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case inQuotes && c == '\\' && i+1 < len(text) && (text[i+1] == '"' || text[i+1] == '\\'):
+			i++
+			word.WriteByte(text[i])
+		case c == '"':
+			inQuotes = !inQuotes
+			inWord, quoted = true, true
+		case inQuotes:
+			word.WriteByte(c)
+		case c == ';':
+			endWord()
+			rules = append(rules, fields)
+			fields = nil
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			endWord()
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("retention rule %d: unterminated quote", len(rules)+1)
+	}
+	endWord()
+	return append(rules, fields), nil
+}
+
+// quoteScope quotes a scope for CACHE_RETENTION when it holds spaces,
+// semicolons, quotes or "="
+func quoteScope(scope string) string {
+	if !strings.ContainsAny(scope, " \t\n\r;\"=") {
+		return scope
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(scope) + `"`
+}
+
+// ParseAge parses a duration that may also use d (days), w (weeks) and
+// y (365 days) units, e.g. "90d" or "1y"
+func ParseAge(text string) (time.Duration, error) {
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour, 'y': 365 * 24 * time.Hour}
+	if text != "" {
+		if unit, ok := units[text[len(text)-1]]; ok {
+			n, err := strconv.Atoi(text[:len(text)-1])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid age %q", text)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(text)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid age %q (e.g. 90d, 12w, 1y, 36h)", text)
+	}
+	return d, nil
+}
+
+// byteUnits are the size suffixes accepted by ParseBytes, in powers of 1024
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+}
+
+// ParseBytes parses a size such as "500MB" or "2G"; units are powers of 1024
+func ParseBytes(text string) (int64, error) {
+	upper := strings.ToUpper(text)
+	multiplier := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper, multiplier = strings.TrimSuffix(upper, unit.suffix), unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q (e.g. 500MB, 2GB)", text)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// FormatBytes formats a size with the largest whole unit, e.g. "1.5GB"
+func FormatBytes(n int64) string {
+	for _, unit := range byteUnits[:4] {
+		if n >= unit.size {
+			value := strconv.FormatFloat(float64(n)/float64(unit.size), 'f', 1, 64)
+			return strings.TrimSuffix(value, ".0") + unit.suffix
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// formatDuration formats an age in days when it is a whole number of them
+func formatDuration(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
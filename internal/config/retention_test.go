@@ -0,0 +1,120 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRetention(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		text string
+		want RetentionRules
+	}{
+		{
+			text: `max_age=2y max_bytes=2GB; *:Trash max_age=30d; work:INBOX max_messages=50000`,
+			want: RetentionRules{
+				{Account: "*", MaxAge: 365 * 2 * day, MaxBytes: 2 << 30},
+				{Account: "*", Folder: "Trash", MaxAge: 30 * day},
+				{Account: "work", Folder: "INBOX", MaxMessages: 50000},
+			},
+		},
+		{
+			// Quoted scopes keep spaces
+			text: `*:"[Gmail]/All Mail" body_max_age=1y; "personal:[Gmail]/All Mail" max_age=2y`,
+			want: RetentionRules{
+				{Account: "*", Folder: "[Gmail]/All Mail", BodyMaxAge: 365 * day},
+				{Account: "personal", Folder: "[Gmail]/All Mail", MaxAge: 2 * 365 * day},
+			},
+		},
+		{
+			// and semicolons, quotes and "="
+			text: `"*:a;b \"c\" d=e" max_age=1d`,
+			want: RetentionRules{
+				{Account: "*", Folder: `a;b "c" d=e`, MaxAge: day},
+			},
+		},
+		{
+			text: `:[Gmail]/Spam max_age=30d;;`,
+			want: RetentionRules{
+				{Account: "*", Folder: "[Gmail]/Spam", MaxAge: 30 * day},
+			},
+		},
+		{text: ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := ParseRetention(tt.text)
+			if err != nil {
+				t.Fatalf("ParseRetention() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParseRetention() = %+v, want %+v", got, tt.want)
+			}
+
+			// String writes rules that parse back to the same
+			var texts []string
+			for _, rule := range got {
+				texts = append(texts, rule.String())
+			}
+			again, err := ParseRetention(strings.Join(texts, "; "))
+			if err != nil || !reflect.DeepEqual(again, got) {
+				t.Errorf("ParseRetention(%q) = %+v, %v, want %+v", strings.Join(texts, "; "), again, err, got)
+			}
+		})
+	}
+}
+
+func TestParseRetentionErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{`*:"[Gmail]/All Mail max_age=1d`, "retention rule 1: unterminated quote"},
+		{`max_age=1d; *:Trash`, "retention rule 2: no limits for *:Trash"},
+		{`"*:Trash"`, "retention rule 1: no limits for *:Trash"},
+		{`*:Trash 30d`, `retention rule 1: expected key=value, got "30d"`},
+		{`max_age=soon`, "retention rule 1: max_age: invalid age"},
+		{`max_messages=0`, "retention rule 1: max_messages: must be positive"},
+		{`max_size=1GB`, "retention rule 1: max_size: unknown limit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			_, err := ParseRetention(tt.text)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseRetention() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetentionMatchesFolder(t *testing.T) {
+	tests := []struct {
+		pattern string
+		folder  string
+		want    bool
+	}{
+		// Brackets are literal, so Gmail's system folders can be named
+		{"[Gmail]/Spam", "[Gmail]/Spam", true},
+		{"[Gmail]/Spam", "G/Spam", false},
+		{"[Gmail]/All Mail", "[Gmail]/All Mail", true},
+		{"[Gmail]/*", "[Gmail]/Trash", true},
+		{"[Gmail]/*", "[Gmail]/Lists/Go", false},
+		{"Lists/*", "Lists/golang-nuts", true},
+		{"Archive/20??", "Archive/2024", true},
+		{`Odd\*`, "Odd*", true},
+		{`Odd\*`, "Oddities", false},
+		{"INBOX", "Inbox", false},
+	}
+
+	for _, tt := range tests {
+		rule := RetentionRule{Account: "*", Folder: tt.pattern}
+		if got := rule.MatchesFolder("work", tt.folder); got != tt.want {
+			t.Errorf("MatchesFolder(%q) with pattern %q = %v, want %v", tt.folder, tt.pattern, got, tt.want)
+		}
+	}
+}
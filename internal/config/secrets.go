@@ -0,0 +1,262 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretCommandTimeout bounds how long a password command may run
+const secretCommandTimeout = 30 * time.Second
+
+// Secret is a password given directly or by a provider: a file, a command
+// printing it, or an entry of the encrypted secrets file. Providers are
+// consulted every time the secret is resolved, so rotated secrets take
+// effect on the next connection. A Secret never prints its value.
+type Secret struct {
+	value   string
+	file    string
+	command string
+	name    string
+	secrets *SecretsFile
+}
+
+// IsSet reports whether the secret has a value or a provider
+func (s Secret) IsSet() bool {
+	return s.value != "" || s.file != "" || s.command != "" || s.name != ""
+}
+
+// Source describes where the secret comes from, without revealing it
+func (s Secret) Source() string {
+	switch {
+	case s.file != "":
+		return "file " + s.file
+	case s.command != "":
+		return "command"
+	case s.name != "":
+		return "secrets file entry " + s.name
+	case s.value != "":
+		return "value"
+	}
+	return "none"
+}
+
+// String redacts the secret so it cannot leak into logs
+func (s Secret) String() string {
+	if !s.IsSet() {
+		return ""
+	}
+	return "[redacted]"
+}
+
+// GoString redacts the secret in %#v output
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// MarshalJSON redacts the secret
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Resolve returns the current value of the secret
+func (s Secret) Resolve() (string, error) {
+	switch {
+	case s.file != "":
+		data, err := os.ReadFile(s.file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case s.command != "":
+		return runSecretCommand(s.command)
+	case s.name != "":
+		if s.secrets == nil {
+			return "", fmt.Errorf("secret %q needs a secrets file", s.name)
+		}
+		return s.secrets.Get(s.name)
+	}
+	return s.value, nil
+}
+
+// runSecretCommand runs a shell command such as `pass show mail/work` and
+// returns the first line it prints
+func runSecretCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+	defer cancel()
+
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	cmd := exec.CommandContext(ctx, shell, flag, command)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		// Only stderr is reported; stdout may hold the secret
+		message := strings.TrimSpace(stderr.String())
+		if len(message) > 200 {
+			message = message[:200] + "..."
+		}
+		if message != "" {
+			return "", fmt.Errorf("password command failed: %w: %s", err, message)
+		}
+		return "", fmt.Errorf("password command failed: %w", err)
+	}
+
+	line, _, _ := strings.Cut(stdout.String(), "\n")
+	line = strings.TrimRight(line, "\r")
+	if line == "" {
+		return "", fmt.Errorf("password command printed nothing")
+	}
+	return line, nil
+}
+
+// secretsMagic starts every secrets file and is sealed with its contents
+var secretsMagic = []byte("mcp-email-secrets:v1\n")
+
+// ErrSecretNotFound is returned for a name missing from the secrets file
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretsFile is a local file of named secrets, encrypted with AES-256-GCM.
+// The first key encrypts; every key decrypts, so the key can be rotated by
+// putting the new one first and saving the file again.
+type SecretsFile struct {
+	Path string
+	keys [][]byte
+
+	// mu serializes Set, which rewrites the whole file
+	mu sync.Mutex
+}
+
+// NewSecretsFile returns the secrets file at path, encrypted with keys
+// (newest first)
+func NewSecretsFile(path string, keys [][]byte) (*SecretsFile, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("secrets file %s needs a key", path)
+	}
+	return &SecretsFile{Path: path, keys: keys}, nil
+}
+
+// Get reads the file and returns one secret
+func (f *SecretsFile) Get(name string) (string, error) {
+	secrets, err := f.Load()
+	if err != nil {
+		return "", err
+	}
+	value, ok := secrets[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	return value, nil
+}
+
+// Load decrypts every secret. A missing file holds no secrets.
+func (f *SecretsFile) Load() (map[string]string, error) {
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets file: %w", err)
+	}
+	if !bytes.HasPrefix(data, secretsMagic) {
+		return nil, fmt.Errorf("%s is not a secrets file", f.Path)
+	}
+	data = data[len(secretsMagic):]
+
+	for _, key := range f.keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < aead.NonceSize() {
+			return nil, fmt.Errorf("secrets file %s is truncated", f.Path)
+		}
+		plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], secretsMagic)
+		if err != nil {
+			continue
+		}
+		secrets := make(map[string]string)
+		if err := json.Unmarshal(plain, &secrets); err != nil {
+			return nil, fmt.Errorf("secrets file %s is corrupt: %w", f.Path, err)
+		}
+		return secrets, nil
+	}
+	return nil, fmt.Errorf("secrets file %s cannot be decrypted with the configured keys", f.Path)
+}
+
+// Set stores one secret, keeping the others
+func (f *SecretsFile) Set(name, value string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	secrets, err := f.Load()
+	if err != nil {
+		return err
+	}
+	secrets[name] = value
+	return f.Save(secrets)
+}
+
+// Save encrypts the secrets with the first key and replaces the file
+// atomically, readable only by its owner
+func (f *SecretsFile) Save(secrets map[string]string) error {
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(f.keys[0])
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	data := append(append([]byte{}, secretsMagic...), nonce...)
+	data = aead.Seal(data, nonce, plain, secretsMagic)
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write secrets file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write secrets file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write secrets file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write secrets file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.Path); err != nil {
+		return fmt.Errorf("failed to write secrets file: %w", err)
+	}
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
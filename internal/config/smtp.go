@@ -0,0 +1,149 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Defaults of the SMTP session pool
+const (
+	DefaultSMTPIdleTimeout = 30 * time.Second
+	DefaultSMTPMaxIdle     = 2
+)
+
+// SMTP password authentication mechanisms
+const (
+	// SMTPAuthAuto picks the first of PLAIN, LOGIN and CRAM-MD5 that the
+	// server offers, and only CRAM-MD5 on an unencrypted connection
+	SMTPAuthAuto    = "auto"
+	SMTPAuthPlain   = "plain"
+	SMTPAuthLogin   = "login"
+	SMTPAuthCRAMMD5 = "cram-md5"
+)
+
+// Partial delivery policies, for messages some of whose recipients the
+// server rejects
+const (
+	// PartialDeliveryReject sends nothing unless every recipient is accepted
+	PartialDeliveryReject = "reject"
+	// PartialDeliveryAccept sends to the accepted recipients
+	PartialDeliveryAccept = "accept"
+)
+
+// DSN requests (RFC 3461): when to notify, and what to return with a
+// failure notice
+const (
+	DSNNotifySuccess = "success"
+	DSNNotifyFailure = "failure"
+	DSNNotifyDelay   = "delay"
+	DSNNotifyNever   = "never"
+
+	DSNReturnFull    = "full"
+	DSNReturnHeaders = "hdrs"
+)
+
+// fileSMTP is the smtp block of an account in a config file
+type fileSMTP struct {
+	fileServer
+	AuthMechanism string        `config:"auth_mechanism"`
+	EHLOHostname  string        `config:"ehlo_hostname"`
+	IdleTimeout   time.Duration `config:"idle_timeout"`
+	MaxIdle       int           `config:"max_idle"`
+
+	PartialDelivery string   `config:"partial_delivery"`
+	DSNNotify       []string `config:"dsn_notify"`
+	DSNReturn       string   `config:"dsn_return"`
+}
+
+// smtp overrides an smtp block from <prefix>* variables
+func (o *envOverlay) smtp(prefix, path string, dest *fileSMTP) {
+	o.server(prefix, path, &dest.fileServer)
+	o.string(prefix+"AUTH_MECHANISM", &dest.AuthMechanism)
+	o.string(prefix+"EHLO_HOSTNAME", &dest.EHLOHostname)
+	o.duration(prefix+"IDLE_TIMEOUT", path+".idle_timeout", &dest.IdleTimeout)
+	o.int(prefix+"MAX_IDLE", path+".max_idle", &dest.MaxIdle)
+	o.string(prefix+"PARTIAL_DELIVERY", &dest.PartialDelivery)
+	if notify := os.Getenv(prefix + "DSN_NOTIFY"); notify != "" {
+		dest.DSNNotify = SplitList(notify)
+	}
+	o.string(prefix+"DSN_RETURN", &dest.DSNReturn)
+}
+
+// SplitList splits a list given as one string, separated by commas or
+// spaces
+func SplitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, len(values))
+	for i, value := range values {
+		lowered[i] = strings.ToLower(value)
+	}
+	return lowered
+}
+
+// CheckPartialDelivery checks a partial delivery policy
+func CheckPartialDelivery(policy string) error {
+	switch policy {
+	case PartialDeliveryReject, PartialDeliveryAccept:
+		return nil
+	}
+	return fmt.Errorf("must be reject or accept")
+}
+
+// CheckDSNNotify checks the conditions of a DSN request: never, or any of
+// success, failure and delay
+func CheckDSNNotify(notify []string) error {
+	for _, value := range notify {
+		switch value {
+		case DSNNotifySuccess, DSNNotifyFailure, DSNNotifyDelay:
+		case DSNNotifyNever:
+			if len(notify) > 1 {
+				return fmt.Errorf("never cannot be combined with other conditions")
+			}
+		default:
+			return fmt.Errorf("%q is not one of success, failure, delay or never", value)
+		}
+	}
+	return nil
+}
+
+// CheckDSNReturn checks what a DSN returns of the message: full or hdrs
+func CheckDSNReturn(ret string) error {
+	switch ret {
+	case "", DSNReturnFull, DSNReturnHeaders:
+		return nil
+	}
+	return fmt.Errorf("must be full or hdrs")
+}
+
+// validateSMTP checks the SMTP-only settings of an account
+func validateSMTP(errs *ValidationErrors, path string, acc *AccountConfig) {
+	switch acc.SMTPAuthMechanism {
+	case SMTPAuthAuto:
+	case SMTPAuthPlain, SMTPAuthLogin, SMTPAuthCRAMMD5:
+		if acc.OAuth2 != nil {
+			errs.add(path+".auth_mechanism", "not used with oauth2; set oauth2.mechanism instead")
+		}
+	default:
+		errs.add(path+".auth_mechanism", "must be auto, plain, login or cram-md5")
+	}
+	if acc.SMTPMaxIdle < 1 {
+		errs.add(path+".max_idle", "must be at least 1")
+	}
+	if err := CheckPartialDelivery(acc.SMTPPartialDelivery); err != nil {
+		errs.add(path+".partial_delivery", err.Error())
+	}
+	if err := CheckDSNNotify(acc.SMTPDSNNotify); err != nil {
+		errs.add(path+".dsn_notify", err.Error())
+	}
+	if err := CheckDSNReturn(acc.SMTPDSNReturn); err != nil {
+		errs.add(path+".dsn_return", err.Error())
+	}
+	if strings.ContainsAny(acc.SMTPEHLOHostname, " \t\r\n") {
+		errs.add(path+".ehlo_hostname", "must be a domain name or an address literal such as [192.0.2.1]")
+	}
+}
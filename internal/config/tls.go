@@ -0,0 +1,199 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Connection security modes of an IMAP or SMTP server
+const (
+	// SecurityTLS connects with implicit TLS, as on ports 993 and 465
+	SecurityTLS = "tls"
+	// SecurityStartTLS upgrades a plain connection with STARTTLS, which
+	// the server must offer
+	SecurityStartTLS = "starttls"
+	// SecurityNone sends everything in the clear, for local relays
+	SecurityNone = "none"
+)
+
+// TLSConfig holds an account's TLS settings, used for both IMAP and SMTP.
+// Files are read on every connection so renewed certificates are picked up.
+type TLSConfig struct {
+	// CAFile is a PEM bundle of the CAs trusted instead of the system's
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and its key
+	CertFile string
+	KeyFile  string
+	// PinnedSHA256 are SHA-256 hashes of trusted public keys (SPKI), in
+	// base64 or hex. One of them must be in the server's verified chain.
+	PinnedSHA256 []string
+	// InsecureSkipVerify accepts any certificate, or with pins a server
+	// certificate whose own key is pinned, for lab servers
+	InsecureSkipVerify bool
+}
+
+// fileTLS is the tls block of an account in a config file
+type fileTLS struct {
+	CAFile             string   `config:"ca_file"`
+	CertFile           string   `config:"cert_file"`
+	KeyFile            string   `config:"key_file"`
+	PinnedSHA256       []string `config:"pinned_sha256"`
+	InsecureSkipVerify bool     `config:"insecure_skip_verify"`
+}
+
+// tls overrides a tls block from <prefix>TLS_* variables
+func (o *envOverlay) tls(prefix, path string, dest *fileTLS) {
+	prefix += "TLS_"
+	o.string(prefix+"CA_FILE", &dest.CAFile)
+	o.string(prefix+"CERT_FILE", &dest.CertFile)
+	o.string(prefix+"KEY_FILE", &dest.KeyFile)
+	if pins := os.Getenv(prefix + "PINNED_SHA256"); pins != "" {
+		dest.PinnedSHA256 = SplitList(pins)
+	}
+	o.bool(prefix+"INSECURE_SKIP_VERIFY", path+".insecure_skip_verify", &dest.InsecureSkipVerify)
+}
+
+func (t *fileTLS) config() TLSConfig {
+	return TLSConfig{
+		CAFile:             t.CAFile,
+		CertFile:           t.CertFile,
+		KeyFile:            t.KeyFile,
+		PinnedSHA256:       t.PinnedSHA256,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+}
+
+// ClientConfig returns the TLS configuration for connecting to serverName
+func (t *TLSConfig) ClientConfig(serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: t.InsecureSkipVerify, //nolint:gosec // opt-in for lab servers
+	}
+
+	if t.CAFile != "" {
+		pool, err := loadCAFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(t.PinnedSHA256) > 0 {
+		pins := make([][]byte, 0, len(t.PinnedSHA256))
+		for _, pin := range t.PinnedSHA256 {
+			hash, err := parsePin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins = append(pins, hash)
+		}
+		// Runs after the usual verification, and also when it is skipped.
+		// Only certificates the verification chained to a trusted root are
+		// compared: the peer can send any certificate alongside its own,
+		// including the real server's. Without verification only the
+		// server's own certificate counts.
+		insecure := t.InsecureSkipVerify
+		cfg.VerifyConnection = func(state tls.ConnectionState) error {
+			var candidates []*x509.Certificate
+			if insecure {
+				if len(state.PeerCertificates) > 0 {
+					candidates = state.PeerCertificates[:1]
+				}
+			} else {
+				if len(state.VerifiedChains) == 0 {
+					return fmt.Errorf("certificate of %s was not verified, so its pinned public key cannot be checked", serverName)
+				}
+				for _, chain := range state.VerifiedChains {
+					candidates = append(candidates, chain...)
+				}
+			}
+			for _, cert := range candidates {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, pin := range pins {
+					if bytes.Equal(sum[:], pin) {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("certificate of %s matches no pinned public key", serverName)
+		}
+	}
+
+	return cfg, nil
+}
+
+// loadCAFile reads a PEM bundle of CA certificates
+func loadCAFile(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA file %s holds no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// parsePin decodes a public key hash given in base64, as printed by
+// `openssl ... | openssl dgst -sha256 -binary | base64`, optionally with a
+// sha256/ prefix, or in hex with optional colons
+func parsePin(pin string) ([]byte, error) {
+	text := strings.TrimPrefix(pin, "sha256/")
+	if hash, err := hex.DecodeString(strings.ReplaceAll(text, ":", "")); err == nil && len(hash) == sha256.Size {
+		return hash, nil
+	}
+	if hash, err := base64.StdEncoding.DecodeString(text); err == nil && len(hash) == sha256.Size {
+		return hash, nil
+	}
+	return nil, fmt.Errorf("pin %q is not a base64 or hex SHA-256 hash", pin)
+}
+
+// validateTLS checks the tls block at path
+func validateTLS(errs *ValidationErrors, path string, cfg *TLSConfig) {
+	if cfg.CAFile != "" {
+		if _, err := loadCAFile(cfg.CAFile); err != nil {
+			errs.add(path+".ca_file", err.Error())
+		}
+	}
+	switch {
+	case cfg.CertFile != "" && cfg.KeyFile == "":
+		errs.add(path+".key_file", "required with cert_file")
+	case cfg.CertFile == "" && cfg.KeyFile != "":
+		errs.add(path+".cert_file", "required with key_file")
+	case cfg.CertFile != "":
+		if _, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile); err != nil {
+			errs.add(path+".cert_file", err.Error())
+		}
+	}
+	for _, pin := range cfg.PinnedSHA256 {
+		if _, err := parsePin(pin); err != nil {
+			errs.add(path+".pinned_sha256", err.Error())
+		}
+	}
+}
+
+// validateSecurity checks the security mode of an IMAP or SMTP block
+func validateSecurity(errs *ValidationErrors, path, security string) {
+	switch security {
+	case SecurityTLS, SecurityStartTLS, SecurityNone:
+	default:
+		errs.add(path+".security", "must be tls, starttls or none")
+	}
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FieldError is a problem with one setting
+type FieldError struct {
+	// Path locates the setting in a config file, e.g. accounts[1].imap.port
+	Path string
+	// Env is the environment variable that also sets it, if any
+	Env     string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	if e.Env != "" {
+		return fmt.Sprintf("%s (%s): %s", e.Path, e.Env, e.Message)
+	}
+	return e.Path + ": " + e.Message
+}
+
+// ValidationErrors lists every problem found in a configuration
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	messages := make([]string, len(e))
+	for i := range e {
+		messages[i] = e[i].Error()
+	}
+	return fmt.Sprintf("%d configuration errors: %s", len(e), strings.Join(messages, "; "))
+}
+
+func (e *ValidationErrors) add(path, message string) {
+	*e = append(*e, FieldError{Path: path, Message: message})
+}
+
+// describe names the environment variable of each setting
+func (e ValidationErrors) describe(c *Config) {
+	for i := range e {
+		e[i].Env = c.envName(e[i].Path)
+	}
+}
+
+// globalEnv maps settings outside accounts to their environment variables
+var globalEnv = map[string]string{
+	"log_level":                   "LOG_LEVEL",
+	"search_result_limit":         "SEARCH_RESULT_LIMIT",
+	"cache.path":                  "CACHE_PATH",
+	"cache.retention":             "CACHE_RETENTION",
+	"cache.eviction_interval":     "CACHE_EVICTION_INTERVAL",
+	"cache.vacuum_interval":       "CACHE_VACUUM_INTERVAL",
+	"cache.encryption.key":        "CACHE_ENCRYPTION_KEY",
+	"cache.encryption.key_file":   "CACHE_ENCRYPTION_KEY_FILE",
+	"cache.encryption.index_text": "CACHE_ENCRYPTION_INDEX_TEXT",
+	"secrets.file":                "SECRETS_FILE",
+	"secrets.key":                 "SECRETS_KEY",
+	"secrets.key_file":            "SECRETS_KEY_FILE",
+}
+
+var (
+	accountPath  = regexp.MustCompile(`^accounts\[(\d+)\]\.(.+)$`)
+	identityPath = regexp.MustCompile(`^identities\[(\d+)\]\.(.+)$`)
+)
+
+// envName returns the environment variable that sets the setting at path,
+// or "" if there is none
+func (c *Config) envName(path string) string {
+	if name, ok := globalEnv[path]; ok {
+		return name
+	}
+
+	m := accountPath.FindStringSubmatch(path)
+	if m == nil {
+		return ""
+	}
+	var i int
+	fmt.Sscan(m[1], &i)
+	if i >= len(c.accountEnv) {
+		return ""
+	}
+	prefix := c.accountEnv[i]
+	if m[2] == "name" && prefix == "" {
+		return "ACCOUNT_NAME"
+	}
+	// Identities are numbered from 1, as IDENTITY_<M>_*
+	if id := identityPath.FindStringSubmatch(m[2]); id != nil {
+		var j int
+		fmt.Sscan(id[1], &j)
+		return fmt.Sprintf("%sIDENTITY_%d_%s", prefix, j+1, strings.ToUpper(id[2]))
+	}
+	return prefix + strings.ToUpper(strings.ReplaceAll(m[2], ".", "_"))
+}
+
+var logLevels = []string{"panic", "fatal", "error", "warn", "warning", "info", "debug", "trace"}
+
+// Validate checks the whole configuration and reports every problem as
+// ValidationErrors
+func (c *Config) Validate() error {
+	var errs ValidationErrors
+
+	if c.CachePath == "" {
+		errs.add("cache.path", "required")
+	}
+	if c.SearchResultLimit < 1 || c.SearchResultLimit > 1000 {
+		errs.add("search_result_limit", "must be between 1 and 1000")
+	}
+	if !containsFold(logLevels, c.LogLevel) {
+		errs.add("log_level", fmt.Sprintf("must be one of %s", strings.Join(logLevels, ", ")))
+	}
+	if c.EvictionInterval <= 0 {
+		errs.add("cache.eviction_interval", "must be positive")
+	}
+	if c.VacuumInterval <= 0 {
+		errs.add("cache.vacuum_interval", "must be positive")
+	}
+
+	if len(c.Accounts) == 0 {
+		errs.add("accounts", "at least one account must be configured")
+	}
+
+	names := make(map[string]int)
+	for i := range c.Accounts {
+		acc := &c.Accounts[i]
+		path := fmt.Sprintf("accounts[%d]", i)
+
+		if acc.Name == "" {
+			errs.add(path+".name", "required")
+		} else if first, ok := names[acc.Name]; ok {
+			errs.add(path+".name", fmt.Sprintf("duplicates accounts[%d]", first))
+		} else {
+			names[acc.Name] = i
+		}
+
+		// Passwords are not needed when OAuth2 provides access tokens
+		oauth2 := acc.OAuth2 != nil
+		validateServer(&errs, path+".imap", acc.IMAPHost, acc.IMAPPort, acc.IMAPUsername, acc.IMAPPassword, oauth2)
+		validateServer(&errs, path+".smtp", acc.SMTPHost, acc.SMTPPort, acc.SMTPUsername, acc.SMTPPassword, oauth2)
+		validateSecurity(&errs, path+".imap", acc.IMAPSecurity)
+		validateSecurity(&errs, path+".smtp", acc.SMTPSecurity)
+		validateTLS(&errs, path+".tls", &acc.TLS)
+		validateSMTP(&errs, path+".smtp", acc)
+		validateIdentities(&errs, path+".identities", acc.Identities)
+		if oauth2 {
+			validateOAuth2(&errs, path+".oauth2", acc.OAuth2)
+		}
+	}
+
+	if len(errs) > 0 {
+		errs.describe(c)
+		return errs
+	}
+	return nil
+}
+
+// validateServer checks an IMAP or SMTP block
+func validateServer(errs *ValidationErrors, path, host string, port int, username string, password Secret, oauth2 bool) {
+	if host == "" {
+		errs.add(path+".host", "required")
+	}
+	if port < 1 || port > 65535 {
+		errs.add(path+".port", "must be between 1 and 65535")
+	}
+	if username == "" {
+		errs.add(path+".username", "required")
+	}
+	if !password.IsSet() && !oauth2 {
+		errs.add(path+".password", "required: set password, password_file, password_command or password_secret")
+	}
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -74,6 +74,9 @@ func (m *AccountManager) Close() error {
 		if account.IMAP != nil {
 			account.IMAP.Close()
 		}
+		if account.SMTP != nil {
+			account.SMTP.Close()
+		}
 	}
 	return nil
 }
@@ -0,0 +1,187 @@
+package email
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/pkg/types"
+)
+
+// lookupAccount returns an account and its cache ID, creating the cache row if needed
+func (m *Manager) lookupAccount(accountName string) (*Account, int, error) {
+	account, err := m.accountManager.GetAccount(accountName)
+	if err != nil || account == nil {
+		return nil, 0, fmt.Errorf("account not found: %s", accountName)
+	}
+
+	accountID, err := m.store.GetAccountID(accountName)
+	if err != nil {
+		accountID, err = m.store.UpsertAccount(account.Config)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to create account in cache: %w", err)
+		}
+	}
+
+	return account, accountID, nil
+}
+
+// FolderPath joins a parent folder and a leaf name using the account's
+// hierarchy delimiter. An empty parent yields a top-level folder.
+func (m *Manager) FolderPath(accountName, parent, name string) (string, error) {
+	account, _, err := m.lookupAccount(accountName)
+	if err != nil {
+		return "", err
+	}
+
+	delimiter, err := account.IMAP.Delimiter()
+	if err != nil {
+		return "", err
+	}
+
+	name = NormalizeFolderName(name)
+	if err := validateFolderName(name); err != nil {
+		return "", err
+	}
+	if delimiter != "" && strings.Contains(name, delimiter) {
+		return "", fmt.Errorf("folder name %q cannot contain the hierarchy delimiter %q", name, delimiter)
+	}
+
+	if parent == "" {
+		return name, nil
+	}
+	if delimiter == "" {
+		return "", fmt.Errorf("account %s does not support nested folders", accountName)
+	}
+
+	return NormalizeFolderName(parent) + delimiter + name, nil
+}
+
+// ParentFolder returns the parent path of a folder, or "" for top-level folders
+func (m *Manager) ParentFolder(accountName, path string) (string, error) {
+	account, _, err := m.lookupAccount(accountName)
+	if err != nil {
+		return "", err
+	}
+
+	delimiter, err := account.IMAP.Delimiter()
+	if err != nil {
+		return "", err
+	}
+
+	parent, _ := types.SplitFolderPath(NormalizeFolderName(path), delimiter)
+	return parent, nil
+}
+
+// CreateFolder creates a folder on the server and records it in the cache.
+// It returns the decoded folder path.
+func (m *Manager) CreateFolder(accountName, path string) (string, error) {
+	account, accountID, err := m.lookupAccount(accountName)
+	if err != nil {
+		return "", err
+	}
+
+	path = NormalizeFolderName(path)
+	if err := validateFolderName(path); err != nil {
+		return "", err
+	}
+
+	delimiter, err := account.IMAP.Delimiter()
+	if err != nil {
+		return "", err
+	}
+
+	if err := account.IMAP.CreateFolder(path); err != nil {
+		return "", err
+	}
+
+	if _, err := m.store.EnsureFolder(accountID, path, delimiter); err != nil {
+		m.logger.WithError(err).WithField("folder", path).Warn("Failed to cache created folder")
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"account": accountName,
+		"folder":  path,
+	}).Info("Created folder")
+
+	return path, nil
+}
+
+// RenameFolder renames a folder on the server and updates cached paths,
+// including those of its children
+func (m *Manager) RenameFolder(accountName, oldPath, newPath string) error {
+	account, accountID, err := m.lookupAccount(accountName)
+	if err != nil {
+		return err
+	}
+
+	oldPath = NormalizeFolderName(oldPath)
+	newPath = NormalizeFolderName(newPath)
+	if err := validateFolderName(newPath); err != nil {
+		return err
+	}
+	if strings.EqualFold(oldPath, imap.InboxName) {
+		return fmt.Errorf("renaming INBOX is not supported")
+	}
+
+	delimiter, err := account.IMAP.Delimiter()
+	if err != nil {
+		return err
+	}
+
+	if err := account.IMAP.RenameFolder(oldPath, newPath); err != nil {
+		return err
+	}
+
+	if err := m.store.RenameFolder(accountID, oldPath, newPath, delimiter); err != nil {
+		m.logger.WithError(err).WithField("folder", oldPath).Warn("Failed to rename folder in cache")
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"account": accountName,
+		"from":    oldPath,
+		"to":      newPath,
+	}).Info("Renamed folder")
+
+	return nil
+}
+
+// DeleteFolder deletes a folder on the server and drops it from the cache
+func (m *Manager) DeleteFolder(accountName, path string) error {
+	account, accountID, err := m.lookupAccount(accountName)
+	if err != nil {
+		return err
+	}
+
+	path = NormalizeFolderName(path)
+	if strings.EqualFold(path, imap.InboxName) {
+		return fmt.Errorf("INBOX cannot be deleted")
+	}
+
+	if err := account.IMAP.DeleteFolder(path); err != nil {
+		return err
+	}
+
+	if err := m.store.DeleteFolder(accountID, path); err != nil {
+		m.logger.WithError(err).WithField("folder", path).Warn("Failed to delete folder from cache")
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"account": accountName,
+		"folder":  path,
+	}).Info("Deleted folder")
+
+	return nil
+}
+
+// SubscribeFolder subscribes to or unsubscribes from a folder
+func (m *Manager) SubscribeFolder(accountName, path string, subscribe bool) error {
+	account, _, err := m.lookupAccount(accountName)
+	if err != nil {
+		return err
+	}
+
+	return account.IMAP.SubscribeFolder(NormalizeFolderName(path), subscribe)
+}
@@ -2,7 +2,6 @@ package email
 
 import (
 	"bytes"
-	"crypto/tls"
 	"fmt"
 	"io"
 
@@ -11,7 +10,9 @@ import (
 	"github.com/jhillyerd/enmime"
 	"github.com/sirupsen/logrus"
 
+	"github.com/brandon/mcp-email/internal/cache"
 	"github.com/brandon/mcp-email/internal/config"
+	"github.com/brandon/mcp-email/internal/oauth"
 	"github.com/brandon/mcp-email/pkg/types"
 )
 
@@ -21,6 +22,8 @@ type IMAPClient struct {
 	client    *client.Client
 	logger    *logrus.Logger
 	connected bool
+	delimiter *string
+	mechanism string
 }
 
 // NewIMAPClient creates a new IMAP client (does not connect immediately)
@@ -38,21 +41,14 @@ func (c *IMAPClient) Connect() error {
 		return nil
 	}
 
-	addr := fmt.Sprintf("%s:%d", c.config.IMAPHost, c.config.IMAPPort)
-
-	// Connect to server
-	cl, err := client.DialTLS(addr, &tls.Config{
-		ServerName: c.config.IMAPHost,
-		MinVersion: tls.VersionTLS12,
-	})
+	cl, err := c.dial()
 	if err != nil {
-		return fmt.Errorf("failed to connect to IMAP server: %w", err)
+		return err
 	}
 
 	c.client = cl
 
-	// Login
-	if err := c.client.Login(c.config.IMAPUsername, c.config.IMAPPassword); err != nil {
+	if err := c.login(); err != nil {
 		c.logger.WithError(err).Error("Failed to login to IMAP server")
 		c.client.Logout() //nolint:errcheck
 		c.client = nil
@@ -64,6 +60,68 @@ func (c *IMAPClient) Connect() error {
 	return nil
 }
 
+// dial connects to the server with the account's connection security
+func (c *IMAPClient) dial() (*client.Client, error) {
+	addr := fmt.Sprintf("%s:%d", c.config.IMAPHost, c.config.IMAPPort)
+	tlsConfig, err := tlsConfigFor(c.config, "imap", c.config.IMAPHost, c.config.IMAPSecurity, c.logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up TLS for IMAP: %w", err)
+	}
+
+	if c.config.IMAPSecurity == config.SecurityTLS {
+		cl, err := client.DialTLS(addr, tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to IMAP server: %w", err)
+		}
+		return cl, nil
+	}
+
+	cl, err := client.Dial(addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to IMAP server: %w", err)
+	}
+	if c.config.IMAPSecurity == config.SecurityStartTLS {
+		if ok, _ := cl.SupportStartTLS(); !ok {
+			cl.Logout() //nolint:errcheck
+			return nil, fmt.Errorf("IMAP server does not offer STARTTLS; set security to tls or none")
+		}
+		if err := cl.StartTLS(tlsConfig); err != nil {
+			cl.Logout() //nolint:errcheck
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	return cl, nil
+}
+
+// login authenticates with an OAuth2 access token over SASL, or with the
+// password. Secrets are resolved on every connect so rotated ones are
+// picked up.
+func (c *IMAPClient) login() error {
+	if c.config.OAuth2 != nil {
+		mechanism := oauth.Mechanism(c.config.OAuth2.Mechanism, func(mech string) bool {
+			ok, _ := c.client.SupportAuth(mech)
+			return ok
+		})
+		c.mechanism = mechanism
+		return withAccessToken(c.config, func(token string) error {
+			return c.client.Authenticate(oauth.NewClient(mechanism, c.config.IMAPUsername, token, c.config.IMAPHost, c.config.IMAPPort))
+		})
+	}
+
+	password, err := c.config.IMAPPassword.Resolve()
+	if err != nil {
+		return fmt.Errorf("failed to get IMAP password from %s: %w", c.config.IMAPPassword.Source(), err)
+	}
+	c.mechanism = "LOGIN"
+	return c.client.Login(c.config.IMAPUsername, password)
+}
+
+// AuthMechanism returns how the connection logged in: LOGIN with a
+// password, or the SASL mechanism that presented an access token
+func (c *IMAPClient) AuthMechanism() string {
+	return c.mechanism
+}
+
 // Close closes the IMAP connection
 func (c *IMAPClient) Close() error {
 	if c.client != nil {
@@ -76,8 +134,52 @@ func (c *IMAPClient) Close() error {
 	return nil
 }
 
-// ListFolders lists all mailboxes/folders
+// ListFolders lists all mailboxes/folders with their hierarchy, attributes
+// and SPECIAL-USE role. Message counts are not filled in; see FolderStatus.
 func (c *IMAPClient) ListFolders() ([]types.Folder, error) {
+	return c.listFolders("*")
+}
+
+// GetFolder returns a single folder with its message counts
+func (c *IMAPClient) GetFolder(path string) (*types.Folder, error) {
+	folders, err := c.listFolders(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(folders) == 0 {
+		return nil, fmt.Errorf("folder not found: %s", path)
+	}
+
+	folder := &folders[0]
+	if folder.Selectable() {
+		if err := c.FolderStatus(folder); err != nil {
+			return nil, err
+		}
+	}
+
+	return folder, nil
+}
+
+// FolderStatus fills in total, unseen and recent message counts using STATUS
+func (c *IMAPClient) FolderStatus(folder *types.Folder) error {
+	if err := c.Connect(); err != nil {
+		return err
+	}
+
+	items := []imap.StatusItem{imap.StatusMessages, imap.StatusRecent, imap.StatusUnseen}
+	status, err := c.client.Status(folder.Path, items)
+	if err != nil {
+		return fmt.Errorf("failed to get status of %s: %w", folder.Path, err)
+	}
+
+	folder.MessageCount = int(status.Messages)
+	folder.RecentCount = int(status.Recent)
+	folder.UnseenCount = int(status.Unseen)
+	return nil
+}
+
+// listFolders runs LIST with the given pattern and converts the results
+func (c *IMAPClient) listFolders(pattern string) ([]types.Folder, error) {
 	if err := c.Connect(); err != nil {
 		return nil, err
 	}
@@ -86,22 +188,36 @@ func (c *IMAPClient) ListFolders() ([]types.Folder, error) {
 	done := make(chan error, 1)
 
 	go func() {
-		done <- c.client.List("", "*", mailboxes)
+		done <- c.client.List("", pattern, mailboxes)
 	}()
 
 	var folders []types.Folder
 	for m := range mailboxes {
+		parent, name := types.SplitFolderPath(m.Name, m.Delimiter)
 		folder := types.Folder{
-			Name: m.Name,
-			Path: m.Name,
+			Name:       name,
+			Path:       m.Name,
+			ParentPath: parent,
+			Delimiter:  m.Delimiter,
+			Attributes: m.Attributes,
 		}
+		folder.SpecialUse = specialUseRole(&folder)
 		folders = append(folders, folder)
+
+		if c.delimiter == nil && m.Delimiter != "" {
+			delimiter := m.Delimiter
+			c.delimiter = &delimiter
+		}
 	}
 
 	if err := <-done; err != nil {
 		return nil, fmt.Errorf("failed to list folders: %w", err)
 	}
 
+	if pattern == "*" {
+		assignFallbackRoles(folders)
+	}
+
 	return folders, nil
 }
 
@@ -176,11 +292,12 @@ func (c *IMAPClient) parseMessage(msg *imap.Message, folderName string) *types.E
 	email := &types.Email{
 		UID:        msg.Uid,
 		MessageID:  msg.Envelope.MessageId,
+		InReplyTo:  msg.Envelope.InReplyTo,
 		Subject:    msg.Envelope.Subject,
 		Date:       msg.Envelope.Date,
 		FolderPath: folderName,
 		Recipients: []string{},
-		Headers:    make(map[string]string),
+		Headers:    make(map[string][]string),
 		Flags:      []string{},
 	}
 
@@ -245,6 +362,8 @@ func (c *IMAPClient) parseMessage(msg *imap.Message, folderName string) *types.E
 			if err == nil {
 				email.BodyText = env.Text
 				email.BodyHTML = env.HTML
+				email.AttachmentCount = len(env.Attachments)
+				parseHeaders(email, env)
 				c.logger.WithFields(logrus.Fields{
 					"text_len": len(env.Text),
 					"html_len": len(env.HTML),
@@ -265,20 +384,37 @@ func (c *IMAPClient) parseMessage(msg *imap.Message, folderName string) *types.E
 	return email
 }
 
-// SearchEmails searches for emails in a folder
+// parseHeaders copies every header of a message, decoding RFC 2047 encoded
+// words, and fills the header fields the cache indexes
+func parseHeaders(email *types.Email, env *enmime.Envelope) {
+	for _, key := range env.GetHeaderKeys() {
+		email.Headers[key] = env.GetHeaderValues(key)
+	}
+
+	email.References = cache.ParseMessageIDs(env.GetHeader("References"))
+	email.ListID = env.GetHeader("List-Id")
+	email.ReplyTo = env.GetHeader("Reply-To")
+	email.ReturnPath = env.GetHeader("Return-Path")
+	email.XMailer = env.GetHeader("X-Mailer")
+	if email.InReplyTo == "" {
+		email.InReplyTo = env.GetHeader("In-Reply-To")
+	}
+}
+
+// SearchEmails runs UID SEARCH in a folder and returns the matching UIDs
 func (c *IMAPClient) SearchEmails(folderName string, criteria *imap.SearchCriteria) ([]uint32, error) {
 	if err := c.Connect(); err != nil {
 		return nil, err
 	}
 
-	// Select folder
-	_, err := c.client.Select(folderName, false)
+	// Select folder read-only
+	_, err := c.client.Select(folderName, true)
 	if err != nil {
 		return nil, fmt.Errorf("failed to select folder: %w", err)
 	}
 
 	// Search
-	uids, err := c.client.Search(criteria)
+	uids, err := c.client.UidSearch(criteria)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search emails: %w", err)
 	}
@@ -286,6 +422,45 @@ func (c *IMAPClient) SearchEmails(folderName string, criteria *imap.SearchCriter
 	return uids, nil
 }
 
+// FetchEmailsByUID fetches the given messages from a folder. The folder is
+// selected read-only so fetching does not mark messages as read.
+func (c *IMAPClient) FetchEmailsByUID(folderName string, uids []uint32) ([]*types.Email, error) {
+	if len(uids) == 0 {
+		return []*types.Email{}, nil
+	}
+
+	if err := c.Connect(); err != nil {
+		return nil, err
+	}
+
+	if _, err := c.client.Select(folderName, true); err != nil {
+		return nil, fmt.Errorf("failed to select folder: %w", err)
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+
+	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchInternalDate, imap.FetchUid, imap.FetchRFC822}
+
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.client.UidFetch(seqSet, items, messages)
+	}()
+
+	var emails []*types.Email
+	for msg := range messages {
+		emails = append(emails, c.parseMessage(msg, folderName))
+	}
+
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+
+	return emails, nil
+}
+
 // SetLogger sets the logger for the client
 func (c *IMAPClient) SetLogger(logger *logrus.Logger) {
 	c.logger = logger
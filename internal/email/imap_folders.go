@@ -0,0 +1,206 @@
+package email
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/utf7"
+
+	"github.com/brandon/mcp-email/pkg/types"
+)
+
+// Delimiter returns the server's hierarchy delimiter, as reported by
+// LIST "" "". An empty string means the server has a flat namespace.
+func (c *IMAPClient) Delimiter() (string, error) {
+	if c.delimiter != nil {
+		return *c.delimiter, nil
+	}
+
+	if err := c.Connect(); err != nil {
+		return "", err
+	}
+
+	mailboxes := make(chan *imap.MailboxInfo, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.client.List("", "", mailboxes)
+	}()
+
+	delimiter := ""
+	for m := range mailboxes {
+		delimiter = m.Delimiter
+	}
+
+	if err := <-done; err != nil {
+		return "", fmt.Errorf("failed to get hierarchy delimiter: %w", err)
+	}
+
+	c.delimiter = &delimiter
+	return delimiter, nil
+}
+
+// CreateFolder creates a mailbox. Names are modified UTF-7 encoded on the wire.
+func (c *IMAPClient) CreateFolder(path string) error {
+	if err := c.Connect(); err != nil {
+		return err
+	}
+
+	if err := c.client.Create(path); err != nil {
+		return fmt.Errorf("failed to create folder: %w", err)
+	}
+
+	return nil
+}
+
+// RenameFolder renames a mailbox; servers move its children along with it
+func (c *IMAPClient) RenameFolder(oldPath, newPath string) error {
+	if err := c.Connect(); err != nil {
+		return err
+	}
+
+	if err := c.client.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("failed to rename folder: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteFolder deletes a mailbox
+func (c *IMAPClient) DeleteFolder(path string) error {
+	if err := c.Connect(); err != nil {
+		return err
+	}
+
+	if err := c.client.Delete(path); err != nil {
+		return fmt.Errorf("failed to delete folder: %w", err)
+	}
+
+	return nil
+}
+
+// SubscribeFolder subscribes to or unsubscribes from a mailbox
+func (c *IMAPClient) SubscribeFolder(path string, subscribe bool) error {
+	if err := c.Connect(); err != nil {
+		return err
+	}
+
+	if subscribe {
+		if err := c.client.Subscribe(path); err != nil {
+			return fmt.Errorf("failed to subscribe to folder: %w", err)
+		}
+		return nil
+	}
+
+	if err := c.client.Unsubscribe(path); err != nil {
+		return fmt.Errorf("failed to unsubscribe from folder: %w", err)
+	}
+
+	return nil
+}
+
+// NormalizeFolderName returns the decoded form of a mailbox name. Names that
+// are already in modified UTF-7 (as seen on the wire, e.g. "Entw&APw-rfe")
+// are decoded so they are not encoded twice; anything else is returned as is.
+func NormalizeFolderName(name string) string {
+	if !strings.Contains(name, "&") {
+		return name
+	}
+
+	decoded, err := utf7.Encoding.NewDecoder().String(name)
+	if err != nil {
+		return name
+	}
+
+	encoded, err := utf7.Encoding.NewEncoder().String(decoded)
+	if err != nil || encoded != name {
+		return name
+	}
+
+	return decoded
+}
+
+// validateFolderName rejects names the IMAP server cannot store or that
+// would be interpreted as LIST wildcards
+func validateFolderName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("folder name cannot be empty")
+	}
+	if strings.ContainsAny(name, "*%") {
+		return fmt.Errorf("folder name cannot contain '*' or '%%': %s", name)
+	}
+	if strings.ContainsAny(name, "\r\n") {
+		return fmt.Errorf("folder name cannot contain line breaks")
+	}
+	return nil
+}
+
+// specialUseAttrs maps SPECIAL-USE attributes (RFC 6154) to folder roles
+var specialUseAttrs = map[string]string{
+	imap.SentAttr:    types.FolderRoleSent,
+	imap.DraftsAttr:  types.FolderRoleDrafts,
+	imap.TrashAttr:   types.FolderRoleTrash,
+	imap.JunkAttr:    types.FolderRoleJunk,
+	imap.ArchiveAttr: types.FolderRoleArchive,
+	imap.AllAttr:     types.FolderRoleAll,
+}
+
+// fallbackRoleNames lists common folder names used by servers that do not
+// advertise SPECIAL-USE attributes, matched case-insensitively on the leaf name
+var fallbackRoleNames = map[string][]string{
+	types.FolderRoleSent:    {"Sent", "Sent Items", "Sent Mail", "Sent Messages"},
+	types.FolderRoleDrafts:  {"Drafts", "Draft"},
+	types.FolderRoleTrash:   {"Trash", "Deleted Items", "Deleted Messages", "Bin"},
+	types.FolderRoleJunk:    {"Junk", "Spam", "Junk E-mail", "Bulk Mail"},
+	types.FolderRoleArchive: {"Archive", "Archives"},
+}
+
+// specialUseRole derives a folder's role from its name and attributes
+func specialUseRole(folder *types.Folder) string {
+	if strings.EqualFold(folder.Path, imap.InboxName) {
+		return types.FolderRoleInbox
+	}
+	for attr, role := range specialUseAttrs {
+		if folder.HasAttribute(attr) {
+			return role
+		}
+	}
+	return ""
+}
+
+// assignFallbackRoles assigns roles by well-known folder names for every role
+// no folder claimed through SPECIAL-USE attributes
+func assignFallbackRoles(folders []types.Folder) {
+	claimed := make(map[string]bool)
+	for i := range folders {
+		if folders[i].SpecialUse != "" {
+			claimed[folders[i].SpecialUse] = true
+		}
+	}
+
+	for role, names := range fallbackRoleNames {
+		if claimed[role] {
+			continue
+		}
+		for i := range folders {
+			if folders[i].SpecialUse != "" || !folders[i].Selectable() {
+				continue
+			}
+			if matchesAny(folders[i].Name, names) {
+				folders[i].SpecialUse = role
+				break
+			}
+		}
+	}
+}
+
+// matchesAny reports whether name equals one of candidates, ignoring case
+func matchesAny(name string, candidates []string) bool {
+	for _, candidate := range candidates {
+		if strings.EqualFold(name, candidate) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,269 @@
+package email
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+	"github.com/sirupsen/logrus"
+)
+
+// codeCopyUID is the UIDPLUS response code reporting destination UIDs (RFC 4315)
+const codeCopyUID imap.StatusRespCode = "COPYUID"
+
+// MoveEmails moves messages identified by UID from one folder to another.
+// It uses UID MOVE (RFC 6851) when the server supports it and falls back to
+// UID COPY, \Deleted and UID EXPUNGE otherwise. The returned map contains the
+// UIDs assigned in the destination folder, when the server reports them.
+func (c *IMAPClient) MoveEmails(folderName string, uids []uint32, dest string) (map[uint32]uint32, error) {
+	if err := c.selectForUpdate(folderName); err != nil {
+		return nil, err
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+
+	supportsMove, err := c.client.Support("MOVE")
+	if err != nil {
+		return nil, fmt.Errorf("failed to check MOVE capability: %w", err)
+	}
+
+	if supportsMove {
+		handler := &copyUIDHandler{}
+		cmd := &commands.Uid{Cmd: &commands.Move{SeqSet: seqSet, Mailbox: dest}}
+		status, err := c.client.Execute(cmd, handler)
+		if err != nil {
+			return nil, fmt.Errorf("failed to move messages: %w", err)
+		}
+		if err := status.Err(); err != nil {
+			return nil, fmt.Errorf("failed to move messages: %w", err)
+		}
+		// Some servers attach COPYUID to the tagged response instead
+		handler.Handle(status) //nolint:errcheck
+		return handler.mapping, nil
+	}
+
+	mapping, err := c.copyEmails(seqSet, dest)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.expungeUIDs(seqSet); err != nil {
+		return nil, err
+	}
+
+	return mapping, nil
+}
+
+// CopyEmails copies messages identified by UID into another folder. The
+// returned map contains the UIDs assigned in the destination folder, when the
+// server reports them.
+func (c *IMAPClient) CopyEmails(folderName string, uids []uint32, dest string) (map[uint32]uint32, error) {
+	if err := c.selectForUpdate(folderName); err != nil {
+		return nil, err
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+
+	return c.copyEmails(seqSet, dest)
+}
+
+// DeleteEmails permanently removes messages identified by UID from a folder
+func (c *IMAPClient) DeleteEmails(folderName string, uids []uint32) error {
+	if err := c.selectForUpdate(folderName); err != nil {
+		return err
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+
+	return c.expungeUIDs(seqSet)
+}
+
+// selectForUpdate selects a folder in read-write mode
+func (c *IMAPClient) selectForUpdate(folderName string) error {
+	if err := c.Connect(); err != nil {
+		return err
+	}
+
+	if _, err := c.client.Select(folderName, false); err != nil {
+		return fmt.Errorf("failed to select folder: %w", err)
+	}
+
+	return nil
+}
+
+// copyEmails issues UID COPY for the selected folder and collects COPYUID data
+func (c *IMAPClient) copyEmails(seqSet *imap.SeqSet, dest string) (map[uint32]uint32, error) {
+	handler := &copyUIDHandler{}
+	cmd := &commands.Uid{Cmd: &commands.Copy{SeqSet: seqSet, Mailbox: dest}}
+	status, err := c.client.Execute(cmd, handler)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy messages: %w", err)
+	}
+	if err := status.Err(); err != nil {
+		return nil, fmt.Errorf("failed to copy messages: %w", err)
+	}
+
+	// UIDPLUS servers report COPYUID on the tagged response of UID COPY
+	handler.Handle(status) //nolint:errcheck
+
+	return handler.mapping, nil
+}
+
+// expungeUIDs marks messages as \Deleted and expunges them. UID EXPUNGE is
+// used when UIDPLUS is available so other \Deleted messages are left alone.
+// Without it, the \Deleted flag of other messages is cleared for the
+// plain EXPUNGE and restored afterwards.
+func (c *IMAPClient) expungeUIDs(seqSet *imap.SeqSet) error {
+	supportsUIDPlus, err := c.client.Support("UIDPLUS")
+	if err != nil {
+		return fmt.Errorf("failed to check UIDPLUS capability: %w", err)
+	}
+	if !supportsUIDPlus {
+		return c.expungeOnly(seqSet)
+	}
+
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	if err := c.client.UidStore(seqSet, item, []interface{}{imap.DeletedFlag}, nil); err != nil {
+		return fmt.Errorf("failed to flag messages as deleted: %w", err)
+	}
+
+	status, err := c.client.Execute(&uidExpunge{SeqSet: seqSet}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to expunge messages: %w", err)
+	}
+	if err := status.Err(); err != nil {
+		return fmt.Errorf("failed to expunge messages: %w", err)
+	}
+
+	return nil
+}
+
+// expungeOnly expunges the messages in seqSet with a plain EXPUNGE, which
+// removes every \Deleted message of the folder. Other messages flagged
+// \Deleted, by the user or another client, are unflagged first and
+// flagged again once the EXPUNGE is done.
+func (c *IMAPClient) expungeOnly(seqSet *imap.SeqSet) error {
+	criteria := imap.NewSearchCriteria()
+	criteria.WithFlags = []string{imap.DeletedFlag}
+	deleted, err := c.client.UidSearch(criteria)
+	if err != nil {
+		return fmt.Errorf("failed to find messages flagged as deleted: %w", err)
+	}
+	others := new(imap.SeqSet)
+	for _, uid := range deleted {
+		if !seqSet.Contains(uid) {
+			others.AddNum(uid)
+		}
+	}
+
+	if !others.Empty() {
+		item := imap.FormatFlagsOp(imap.RemoveFlags, true)
+		if err := c.client.UidStore(others, item, []interface{}{imap.DeletedFlag}, nil); err != nil {
+			return fmt.Errorf("failed to protect other deleted messages from EXPUNGE: %w", err)
+		}
+	}
+
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	err = c.client.UidStore(seqSet, item, []interface{}{imap.DeletedFlag}, nil)
+	if err != nil {
+		err = fmt.Errorf("failed to flag messages as deleted: %w", err)
+	} else if err = c.client.Expunge(nil); err != nil {
+		err = fmt.Errorf("failed to expunge messages: %w", err)
+	}
+
+	if !others.Empty() {
+		if restoreErr := c.client.UidStore(others, item, []interface{}{imap.DeletedFlag}, nil); restoreErr != nil {
+			c.logger.WithError(restoreErr).WithFields(logrus.Fields{
+				"account": c.config.Name,
+				"uids":    others.String(),
+			}).Error("Failed to restore the deleted flag of messages kept from EXPUNGE")
+			if err == nil {
+				err = fmt.Errorf("expunged, but failed to flag messages %s as deleted again: %w", others, restoreErr)
+			}
+		}
+	}
+	return err
+}
+
+// uidExpunge is a UID EXPUNGE command, as defined in RFC 4315 section 2.1
+type uidExpunge struct {
+	SeqSet *imap.SeqSet
+}
+
+// Command implements imap.Commander
+func (cmd *uidExpunge) Command() *imap.Command {
+	return &imap.Command{
+		Name:      "UID",
+		Arguments: []interface{}{imap.RawString("EXPUNGE"), cmd.SeqSet},
+	}
+}
+
+// copyUIDHandler records the UID mapping from a COPYUID response code
+type copyUIDHandler struct {
+	mapping map[uint32]uint32
+}
+
+// Handle implements responses.Handler
+func (h *copyUIDHandler) Handle(resp imap.Resp) error {
+	status, ok := resp.(*imap.StatusResp)
+	if !ok || status.Code != codeCopyUID || len(status.Arguments) < 3 {
+		return responses.ErrUnhandled
+	}
+
+	src := parseUIDSet(status.Arguments[1])
+	dst := parseUIDSet(status.Arguments[2])
+	if len(src) != len(dst) {
+		return nil
+	}
+
+	if h.mapping == nil {
+		h.mapping = make(map[uint32]uint32, len(src))
+	}
+	for i := range src {
+		h.mapping[src[i]] = dst[i]
+	}
+
+	return nil
+}
+
+// parseUIDSet expands a uid-set (e.g. "304,319:320") preserving its order
+func parseUIDSet(field interface{}) []uint32 {
+	var raw string
+	switch v := field.(type) {
+	case string:
+		raw = v
+	case uint32:
+		return []uint32{v}
+	default:
+		raw = fmt.Sprintf("%v", v)
+	}
+
+	var uids []uint32
+	for _, part := range strings.Split(raw, ",") {
+		bounds := strings.SplitN(part, ":", 2)
+		start, err := strconv.ParseUint(bounds[0], 10, 32)
+		if err != nil {
+			return nil
+		}
+		stop := start
+		if len(bounds) == 2 {
+			stop, err = strconv.ParseUint(bounds[1], 10, 32)
+			if err != nil {
+				return nil
+			}
+		}
+		if stop < start {
+			start, stop = stop, start
+		}
+		for uid := start; uid <= stop; uid++ {
+			uids = append(uids, uint32(uid))
+		}
+	}
+
+	return uids
+}
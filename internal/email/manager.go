@@ -2,11 +2,13 @@ package email
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/brandon/mcp-email/internal/cache"
 	"github.com/brandon/mcp-email/internal/config"
+	"github.com/brandon/mcp-email/pkg/types"
 )
 
 // Manager manages email operations
@@ -40,100 +42,411 @@ func NewManager(cfg *config.Config, cacheStore *cache.Store, logger *logrus.Logg
 
 // SyncAccount syncs emails from IMAP to cache for an account
 func (m *Manager) SyncAccount(accountName string, folderName string) error {
-	account, err := m.accountManager.GetAccount(accountName)
+	account, accountID, err := m.lookupAccount(accountName)
 	if err != nil {
-		return fmt.Errorf("account not found: %s", accountName)
-	}
-	if account == nil {
-		return fmt.Errorf("account not found: %s", accountName)
-	}
-
-	// Get account ID
-	accountID, err := m.store.GetAccountID(accountName)
-	if err != nil {
-		// Account not in cache, create it
-		accountID, err = m.store.UpsertAccount(account.Config)
-		if err != nil {
-			return fmt.Errorf("failed to create account in cache: %w", err)
-		}
+		return err
 	}
 
 	// List folders if folderName is empty
 	if folderName == "" {
-		folders, err := account.IMAP.ListFolders()
+		folders, err := m.refreshFolders(account, accountID)
 		if err != nil {
-			return fmt.Errorf("failed to list folders: %w", err)
+			return err
 		}
 
-		// Sync all folders
-		for _, folder := range folders {
-			if err := m.syncFolder(account, accountID, folder.Name); err != nil {
-				m.logger.WithError(err).WithField("folder", folder.Name).Warn("Failed to sync folder")
+		// Sync all folders that can hold messages
+		for i := range folders {
+			if !folders[i].Selectable() {
+				continue
+			}
+			if err := m.syncFolder(account, &folders[i]); err != nil {
+				m.logger.WithError(err).WithField("folder", folders[i].Path).Warn("Failed to sync folder")
 			}
 		}
 	} else {
 		// Sync specific folder
-		if err := m.syncFolder(account, accountID, folderName); err != nil {
+		folder, err := account.IMAP.GetFolder(folderName)
+		if err != nil {
+			return fmt.Errorf("failed to get folder: %w", err)
+		}
+		folder.AccountID = accountID
+		if err := m.syncFolder(account, folder); err != nil {
 			return fmt.Errorf("failed to sync folder: %w", err)
 		}
 	}
 
+	m.rebuildThreads(account, accountID)
+
 	return nil
 }
 
-// syncFolder syncs a single folder
-func (m *Manager) syncFolder(account *Account, accountID int, folderName string) error {
-	// Get folder status
-	status, err := account.IMAP.GetFolderStatus(folderName)
+// RefreshFolders re-lists an account's folders from the server, including
+// SPECIAL-USE roles and STATUS counts, and replaces the cached folder list
+func (m *Manager) RefreshFolders(accountName string) ([]types.Folder, error) {
+	account, accountID, err := m.lookupAccount(accountName)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.refreshFolders(account, accountID)
+}
+
+// refreshFolders lists folders with their counts and stores them in the cache
+func (m *Manager) refreshFolders(account *Account, accountID int) ([]types.Folder, error) {
+	folders, err := account.IMAP.ListFolders()
 	if err != nil {
-		return fmt.Errorf("failed to get folder status: %w", err)
+		return nil, fmt.Errorf("failed to list folders: %w", err)
 	}
 
+	paths := make([]string, 0, len(folders))
+	for i := range folders {
+		folder := &folders[i]
+		folder.AccountID = accountID
+		folder.AccountName = account.Config.Name
+		paths = append(paths, folder.Path)
+
+		if folder.Selectable() {
+			if err := account.IMAP.FolderStatus(folder); err != nil {
+				m.logger.WithError(err).WithField("folder", folder.Path).Warn("Failed to get folder status")
+			}
+		}
+
+		folder.ID, err = m.store.UpsertFolder(folder)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upsert folder: %w", err)
+		}
+	}
+
+	if err := m.store.PruneFolders(accountID, paths); err != nil {
+		m.logger.WithError(err).WithField("account", account.Config.Name).Warn("Failed to prune folders")
+	}
+
+	return folders, nil
+}
+
+// syncFolder syncs a single folder
+func (m *Manager) syncFolder(account *Account, folder *types.Folder) error {
 	// Upsert folder in cache
-	folderID, err := m.store.UpsertFolder(accountID, folderName, folderName, int(status.Messages))
+	folderID, err := m.store.UpsertFolder(folder)
 	if err != nil {
 		return fmt.Errorf("failed to upsert folder: %w", err)
 	}
 
 	// Fetch emails (recent 100 by default)
-	emails, err := account.IMAP.FetchEmails(folderName, 0, 0)
+	emails, err := account.IMAP.FetchEmails(folder.Path, 0, 0)
 	if err != nil {
 		return fmt.Errorf("failed to fetch emails: %w", err)
 	}
 
 	// Store emails in cache
+	emails = m.retain(account.Config.Name, folder.Path, emails)
 	for _, email := range emails {
-		email.AccountID = accountID
+		email.AccountID = folder.AccountID
 		email.FolderID = folderID
-		if err := m.store.UpsertEmail(email); err != nil {
-			m.logger.WithError(err).WithField("email_id", email.UID).Warn("Failed to cache email")
-		}
+	}
+	if _, err := m.store.UpsertEmails(emails); err != nil {
+		return fmt.Errorf("failed to cache emails: %w", err)
+	}
+
+	if err := m.store.MarkFolderSynced(folderID); err != nil {
+		m.logger.WithError(err).WithField("folder", folder.Path).Warn("Failed to mark folder synced")
 	}
 
 	m.logger.WithFields(logrus.Fields{
 		"account": account.Config.Name,
-		"folder":  folderName,
+		"folder":  folder.Path,
 		"count":   len(emails),
 	}).Info("Synced folder")
 
 	return nil
 }
 
-// SendEmail sends an email
-func (m *Manager) SendEmail(accountName string, msg *EmailMessage) error {
+// retain applies the age limits of the cache retention rules to fetched
+// emails, so a sync does not re-cache what eviction would drop again:
+// emails past the maximum age are skipped and bodies past the maximum body
+// age are left out
+func (m *Manager) retain(accountName, folderPath string, emails []*types.Email) []*types.Email {
+	maxAge, bodyMaxAge := m.config.Retention.Ages(accountName, folderPath)
+	if maxAge == 0 && bodyMaxAge == 0 {
+		return emails
+	}
+
+	now := time.Now()
+	kept := emails[:0]
+	for _, email := range emails {
+		age := now.Sub(email.Date)
+		if maxAge > 0 && age > maxAge {
+			continue
+		}
+		if bodyMaxAge > 0 && age > bodyMaxAge {
+			email.BodyText, email.BodyHTML, email.Headers = "", "", map[string][]string{}
+			email.BodyEvicted = true
+		}
+		kept = append(kept, email)
+	}
+	return kept
+}
+
+// rebuildThreads regroups an account's cached emails into conversations.
+// Threads span folders, so it runs once the folders of a sync are cached.
+func (m *Manager) rebuildThreads(account *Account, accountID int) {
+	if err := m.store.RebuildThreads(accountID); err != nil {
+		m.logger.WithError(err).WithField("account", account.Config.Name).Warn("Failed to rebuild threads")
+	}
+}
+
+// SendEmail sends an email and returns the server's reply to each
+// recipient. The result is also returned with an error if some recipients
+// were answered before the send failed.
+func (m *Manager) SendEmail(accountName string, msg *EmailMessage) (*DeliveryResult, error) {
 	account, err := m.accountManager.GetAccount(accountName)
 	if err != nil {
-		return fmt.Errorf("account not found: %s", accountName)
+		return nil, fmt.Errorf("account not found: %s", accountName)
 	}
 	if account == nil {
-		return fmt.Errorf("account not found: %s", accountName)
+		return nil, fmt.Errorf("account not found: %s", accountName)
 	}
 
-	if err := account.SMTP.Send(msg); err != nil {
-		return fmt.Errorf("failed to send email: %w", err)
+	result, err := account.SMTP.Send(msg)
+	if err != nil {
+		return result, fmt.Errorf("failed to send email: %w", err)
+	}
+	if rejected := result.Rejected(); len(rejected) > 0 {
+		m.logger.WithFields(logrus.Fields{
+			"account":  accountName,
+			"rejected": len(rejected),
+			"sent":     result.Sent,
+		}).Warn("SMTP server rejected recipients")
 	}
 
-	return nil
+	return result, nil
+}
+
+// emailGroup is a set of cached emails sharing an account and folder
+type emailGroup struct {
+	account    *Account
+	accountID  int
+	folderPath string
+	emails     []*types.Email
+}
+
+// uids returns the IMAP UIDs of the emails in the group
+func (g *emailGroup) uids() []uint32 {
+	uids := make([]uint32, len(g.emails))
+	for i, email := range g.emails {
+		uids[i] = email.UID
+	}
+	return uids
+}
+
+// groupEmails loads cached emails and groups them by account and folder.
+// Emails held in several folders are acted on in their primary location.
+func (m *Manager) groupEmails(emailIDs []int64) ([]*emailGroup, error) {
+	if len(emailIDs) == 0 {
+		return nil, fmt.Errorf("no email IDs given")
+	}
+
+	var groups []*emailGroup
+	index := make(map[string]*emailGroup)
+	for _, id := range emailIDs {
+		email, err := m.store.GetEmail(id)
+		if err != nil {
+			return nil, err
+		}
+
+		key := fmt.Sprintf("%d/%s", email.AccountID, email.FolderPath)
+		group, exists := index[key]
+		if !exists {
+			account, err := m.accountManager.GetAccount(email.AccountName)
+			if err != nil || account == nil {
+				return nil, fmt.Errorf("account not found: %s", email.AccountName)
+			}
+			group = &emailGroup{
+				account:    account,
+				accountID:  email.AccountID,
+				folderPath: email.FolderPath,
+			}
+			index[key] = group
+			groups = append(groups, group)
+		}
+		group.emails = append(group.emails, email)
+	}
+
+	return groups, nil
+}
+
+// MoveEmails moves cached emails to another folder of the same account
+func (m *Manager) MoveEmails(emailIDs []int64, dest string) (int, error) {
+	groups, err := m.groupEmails(emailIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, group := range groups {
+		n, err := m.moveGroup(group, dest)
+		moved += n
+		if err != nil {
+			return moved, err
+		}
+	}
+
+	return moved, nil
+}
+
+// CopyEmails copies cached emails to another folder of the same account
+func (m *Manager) CopyEmails(emailIDs []int64, dest string) (int, error) {
+	groups, err := m.groupEmails(emailIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	copied := 0
+	for _, group := range groups {
+		mapping, err := group.account.IMAP.CopyEmails(group.folderPath, group.uids(), dest)
+		if err != nil {
+			return copied, fmt.Errorf("failed to copy emails in %s: %w", group.folderPath, err)
+		}
+		copied += len(group.emails)
+
+		// Without COPYUID or a cached destination the copies arrive on next sync
+		destID, err := m.store.GetFolderID(group.accountID, dest)
+		if err != nil || mapping == nil {
+			continue
+		}
+		for _, email := range group.emails {
+			if uid, ok := mapping[email.UID]; ok {
+				if err := m.store.CopyEmail(email.ID, destID, uid); err != nil {
+					m.logger.WithError(err).WithField("email_id", email.ID).Warn("Failed to cache copied email")
+				}
+			}
+		}
+	}
+
+	return copied, nil
+}
+
+// ArchiveEmails moves cached emails to the account's archive folder
+func (m *Manager) ArchiveEmails(emailIDs []int64) (int, error) {
+	groups, err := m.groupEmails(emailIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	archived := 0
+	for _, group := range groups {
+		dest, err := m.specialUseFolder(group, types.FolderRoleArchive, types.FolderRoleAll)
+		if err != nil {
+			return archived, err
+		}
+		if dest == "" {
+			return archived, fmt.Errorf("account %s has no archive folder", group.account.Config.Name)
+		}
+
+		n, err := m.moveGroup(group, dest)
+		archived += n
+		if err != nil {
+			return archived, err
+		}
+	}
+
+	return archived, nil
+}
+
+// DeleteEmails moves cached emails to the account's trash folder, or
+// expunges them when permanent is set or they are already in the trash
+func (m *Manager) DeleteEmails(emailIDs []int64, permanent bool) (int, error) {
+	groups, err := m.groupEmails(emailIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, group := range groups {
+		trash := ""
+		if !permanent {
+			trash, err = m.specialUseFolder(group, types.FolderRoleTrash)
+			if err != nil {
+				return deleted, err
+			}
+			if trash == "" {
+				return deleted, fmt.Errorf("account %s has no trash folder, use permanent deletion", group.account.Config.Name)
+			}
+		}
+
+		if trash != "" && trash != group.folderPath {
+			n, err := m.moveGroup(group, trash)
+			deleted += n
+			if err != nil {
+				return deleted, err
+			}
+			continue
+		}
+
+		if err := group.account.IMAP.DeleteEmails(group.folderPath, group.uids()); err != nil {
+			return deleted, fmt.Errorf("failed to delete emails in %s: %w", group.folderPath, err)
+		}
+		for _, email := range group.emails {
+			if err := m.store.RemoveEmailLocation(email.FolderID, email.UID); err != nil {
+				m.logger.WithError(err).WithField("email_id", email.ID).Warn("Failed to remove email from cache")
+			}
+		}
+		deleted += len(group.emails)
+	}
+
+	return deleted, nil
+}
+
+// moveGroup moves a group of emails on the server and mirrors it in the cache
+func (m *Manager) moveGroup(group *emailGroup, dest string) (int, error) {
+	if dest == group.folderPath {
+		return 0, nil
+	}
+
+	mapping, err := group.account.IMAP.MoveEmails(group.folderPath, group.uids(), dest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to move emails from %s: %w", group.folderPath, err)
+	}
+
+	// Relocate the moved copies when the new UIDs are known, otherwise drop
+	// them and let the next sync of the destination pick the messages up
+	destID, destErr := m.store.GetFolderID(group.accountID, dest)
+	for _, email := range group.emails {
+		uid, ok := mapping[email.UID]
+		if ok && destErr == nil {
+			err = m.store.RelocateEmail(email.FolderID, email.UID, destID, uid)
+		} else {
+			err = m.store.RemoveEmailLocation(email.FolderID, email.UID)
+		}
+		if err != nil {
+			m.logger.WithError(err).WithField("email_id", email.ID).Warn("Failed to update moved email in cache")
+		}
+	}
+
+	return len(group.emails), nil
+}
+
+// specialUseFolder returns the first folder with one of the given roles, in
+// order of preference. The folder list is refreshed once if none is cached.
+func (m *Manager) specialUseFolder(group *emailGroup, roles ...string) (string, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		for _, role := range roles {
+			folder, err := m.store.GetFolderByRole(group.accountID, role)
+			if err != nil {
+				return "", err
+			}
+			if folder != nil {
+				return folder.Path, nil
+			}
+		}
+
+		if attempt == 0 {
+			if _, err := m.refreshFolders(group.account, group.accountID); err != nil {
+				return "", err
+			}
+		}
+	}
+	return "", nil
 }
 
 // Close closes all connections
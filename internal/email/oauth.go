@@ -0,0 +1,27 @@
+package email
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/brandon/mcp-email/internal/config"
+	"github.com/brandon/mcp-email/internal/oauth"
+)
+
+// withAccessToken calls login with a current OAuth2 access token of the
+// account. A rejected token is refreshed and tried once more, in case it
+// was revoked before it expired.
+func withAccessToken(account *config.AccountConfig, login func(token string) error) error {
+	source := oauth.TokenSourceFor(account)
+	for attempt := 0; ; attempt++ {
+		token, err := source.Token(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to get OAuth2 access token: %w", err)
+		}
+		err = login(token.AccessToken)
+		if err == nil || attempt > 0 {
+			return err
+		}
+		source.Invalidate()
+	}
+}
@@ -0,0 +1,81 @@
+package email
+
+import (
+	"regexp"
+	"strings"
+)
+
+// attributionPattern matches the line a client writes above quoted history,
+// e.g. "On Mon, 1 Jan 2025 at 10:00, Jane <jane@example.com> wrote:"
+var attributionPattern = regexp.MustCompile(
+	`(?i)^(on|am|le|el|il|op|den)\s.*\s(wrote|schrieb|a écrit|escribió|ha scritto|schreef|skrev)\s?:$`)
+
+// separatorPattern matches lines clients put between a reply and the
+// message it quotes
+var separatorPattern = regexp.MustCompile(`(?i)^(-{2,}\s*original message\s*-{2,}|_{10,})$`)
+
+// headerBlockPattern matches the first line of a quoted header block as
+// written by Outlook
+var headerBlockPattern = regexp.MustCompile(`(?i)^\*?(from|von|de):\*?\s`)
+
+// headerBlockFollowers match the lines that confirm a quoted header block
+var headerBlockFollowers = regexp.MustCompile(`(?i)^\*?(sent|date|gesendet|envoyé|enviado|to|an|à|para):\*?\s`)
+
+// StripQuoted removes quoted history from a plain-text reply: lines starting
+// with ">", and everything from an attribution line ("On ... wrote:"), an
+// "Original Message" separator or an Outlook header block onwards. Text that
+// is entirely quoted is returned unchanged, so nothing is lost.
+func StripQuoted(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	var kept []string
+	hasText := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if startsQuotedHistory(lines, i, hasText) {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		kept = append(kept, strings.TrimRight(line, " \t"))
+		hasText = hasText || trimmed != ""
+	}
+
+	stripped := strings.TrimSpace(strings.Join(kept, "\n"))
+	if stripped == "" {
+		return strings.TrimSpace(text)
+	}
+	return stripped
+}
+
+// startsQuotedHistory reports whether lines[i] opens the quoted history of
+// a reply. Header blocks only count below some text of the reply itself.
+func startsQuotedHistory(lines []string, i int, hasText bool) bool {
+	line := strings.TrimSpace(lines[i])
+	if line == "" {
+		return false
+	}
+
+	if separatorPattern.MatchString(line) || attributionPattern.MatchString(line) {
+		return true
+	}
+
+	// Long attributions are often wrapped onto a second line
+	if i+1 < len(lines) {
+		joined := line + " " + strings.TrimSpace(lines[i+1])
+		if attributionPattern.MatchString(joined) {
+			return true
+		}
+	}
+
+	if hasText && headerBlockPattern.MatchString(line) {
+		for j := i + 1; j < len(lines) && j <= i+4; j++ {
+			if headerBlockFollowers.MatchString(strings.TrimSpace(lines[j])) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,163 @@
+package email
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/emersion/go-imap"
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/internal/cache"
+	"github.com/brandon/mcp-email/pkg/types"
+)
+
+// SearchServer runs an IMAP SEARCH built from opts in the selectable folders
+// of the given accounts that opts does not exclude. Matching messages missing
+// from the cache are fetched and cached, newest first and at most limit per
+// folder. It returns the cache IDs of the matches it considered.
+func (m *Manager) SearchServer(accountNames []string, opts cache.SearchOptions, limit int) ([]int64, error) {
+	criteria := opts.IMAPCriteria()
+
+	var ids []int64
+	var lastErr error
+	searched := 0
+
+	for _, accountName := range accountNames {
+		account, accountID, err := m.lookupAccount(accountName)
+		if err != nil {
+			return nil, err
+		}
+
+		folders, err := m.searchFolders(account, accountID, &opts)
+		if err != nil {
+			m.logger.WithError(err).WithField("account", accountName).Warn("Failed to list folders for server search")
+			lastErr = err
+			continue
+		}
+
+		for i := range folders {
+			folderIDs, err := m.searchFolder(account, &folders[i], criteria, limit)
+			if err != nil {
+				m.logger.WithError(err).WithFields(logrus.Fields{
+					"account": accountName,
+					"folder":  folders[i].Path,
+				}).Warn("Failed to search folder on server")
+				lastErr = err
+				continue
+			}
+			ids = append(ids, folderIDs...)
+			searched++
+		}
+
+		m.rebuildThreads(account, accountID)
+	}
+
+	if searched == 0 && lastErr != nil {
+		return nil, fmt.Errorf("server search failed: %w", lastErr)
+	}
+
+	return ids, nil
+}
+
+// searchFolders returns the cached folders of an account a server search
+// should cover, listing them from the server if none are cached yet
+func (m *Manager) searchFolders(account *Account, accountID int, opts *cache.SearchOptions) ([]types.Folder, error) {
+	folders, err := m.store.ListFolders(&accountID)
+	if err != nil {
+		return nil, err
+	}
+	if len(folders) == 0 {
+		if folders, err = m.refreshFolders(account, accountID); err != nil {
+			return nil, err
+		}
+	}
+
+	included := make(map[int]bool)
+	for _, id := range opts.FolderIDs {
+		included[id] = true
+	}
+	if opts.FolderID != nil {
+		included[*opts.FolderID] = true
+	}
+	excluded := make(map[int]bool)
+	for _, id := range opts.ExcludeFolderIDs {
+		excluded[id] = true
+	}
+
+	var selected []types.Folder
+	for _, folder := range folders {
+		if !folder.Selectable() || excluded[folder.ID] {
+			continue
+		}
+		if len(included) > 0 && !included[folder.ID] {
+			continue
+		}
+		selected = append(selected, folder)
+	}
+
+	return selected, nil
+}
+
+// searchFolder searches one folder on the server and caches the newest
+// matches that are not cached yet
+func (m *Manager) searchFolder(account *Account, folder *types.Folder, criteria *imap.SearchCriteria,
+	limit int) ([]int64, error) {
+	uids, err := account.IMAP.SearchEmails(folder.Path, criteria)
+	if err != nil {
+		return nil, err
+	}
+	if len(uids) == 0 {
+		return nil, nil
+	}
+
+	// UIDs grow with arrival, so the highest are the newest
+	sort.Slice(uids, func(i, j int) bool { return uids[i] > uids[j] })
+	if limit > 0 && len(uids) > limit {
+		uids = uids[:limit]
+	}
+
+	cached, err := m.store.FolderUIDs(folder.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []uint32
+	for _, uid := range uids {
+		if _, ok := cached[uid]; !ok {
+			missing = append(missing, uid)
+		}
+	}
+
+	if len(missing) > 0 {
+		emails, err := account.IMAP.FetchEmailsByUID(folder.Path, missing)
+		if err != nil {
+			return nil, err
+		}
+		for _, email := range emails {
+			email.AccountID = folder.AccountID
+			email.FolderID = folder.ID
+		}
+		if _, err := m.store.UpsertEmails(emails); err != nil {
+			return nil, err
+		}
+
+		if cached, err = m.store.FolderUIDs(folder.ID); err != nil {
+			return nil, err
+		}
+
+		m.logger.WithFields(logrus.Fields{
+			"account": account.Config.Name,
+			"folder":  folder.Path,
+			"fetched": len(emails),
+		}).Info("Cached messages found by server search")
+	}
+
+	ids := make([]int64, 0, len(uids))
+	for _, uid := range uids {
+		if id, ok := cached[uid]; ok {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
@@ -4,18 +4,34 @@ import (
 	"bytes"
 	"crypto/tls"
 	"fmt"
+	"html"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
 	"net/smtp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/net/idna"
 
 	"github.com/brandon/mcp-email/internal/config"
+	"github.com/brandon/mcp-email/internal/oauth"
 )
 
-// SMTPClient wraps an SMTP client
+// SMTPClient sends an account's mail, keeping authenticated connections
+// for the next message
 type SMTPClient struct {
 	config *config.AccountConfig
 	logger *logrus.Logger
+
+	mu     sync.Mutex
+	idle   []*smtpSession
+	reaper *time.Timer
+	closed bool
 }
 
 // EmailMessage represents an email to be sent
@@ -29,6 +45,17 @@ type EmailMessage struct {
 	Attachments []Attachment
 	ReplyTo     string
 	InReplyTo   string
+
+	// From selects the identity to send as, by address and optionally with
+	// a display name; empty sends as the account's default identity
+	From string
+	// OmitSignature leaves out the identity's signature
+	OmitSignature bool
+	// PartialDelivery overrides the account's policy for recipients the
+	// server rejects: config.PartialDeliveryReject or PartialDeliveryAccept
+	PartialDelivery string
+	// DSN overrides the account's delivery status notification request
+	DSN *DSNRequest
 }
 
 // Attachment represents an email attachment
@@ -46,158 +73,364 @@ func NewSMTPClient(cfg *config.AccountConfig) (*SMTPClient, error) {
 	}, nil
 }
 
-// Send sends an email
-func (c *SMTPClient) Send(msg *EmailMessage) error {
-	// Create message
-	emailBytes := c.createMessage(msg)
-
-	// Connect to server
-	addr := fmt.Sprintf("%s:%d", c.config.SMTPHost, c.config.SMTPPort)
-
-	// Determine if TLS is needed
-	useTLS := c.config.SMTPPort == 465
+// Send sends an email over a pooled session. A reused session that turns
+// out to be dead before the message was handed over is replaced once.
+// Recipients the server rejects do not make an error: the result reports
+// the reply to each, and whether the message was sent under the partial
+// delivery policy.
+func (c *SMTPClient) Send(msg *EmailMessage) (*DeliveryResult, error) {
+	identity, err := c.config.SendAs(msg.From)
+	if err != nil {
+		return nil, err
+	}
 
-	var auth smtp.Auth
-	if c.config.SMTPPassword != "" {
-		auth = smtp.PlainAuth("", c.config.SMTPUsername, c.config.SMTPPassword, c.config.SMTPHost)
+	partial := msg.PartialDelivery
+	if partial == "" {
+		partial = c.config.SMTPPartialDelivery
+	}
+	if err := config.CheckPartialDelivery(partial); err != nil {
+		return nil, fmt.Errorf("invalid partial delivery policy: %w", err)
 	}
 
-	if useTLS {
-		// TLS connection (port 465)
-		conn, err := tls.Dial("tcp", addr, &tls.Config{
-			ServerName: c.config.SMTPHost,
-			MinVersion: tls.VersionTLS12,
-		})
-		if err != nil {
-			return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	var dsn *DSNRequest
+	switch {
+	case msg.DSN != nil:
+		request := *msg.DSN
+		dsn = &request
+	case len(c.config.SMTPDSNNotify) > 0:
+		dsn = &DSNRequest{Notify: c.config.SMTPDSNNotify, Return: c.config.SMTPDSNReturn}
+	}
+	if dsn != nil {
+		if err := dsn.validate(); err != nil {
+			return nil, err
 		}
-		defer conn.Close()
+	}
 
-		client, err := smtp.NewClient(conn, c.config.SMTPHost)
+	for attempt := 0; ; attempt++ {
+		session, reused, err := c.session()
 		if err != nil {
-			return fmt.Errorf("failed to create SMTP client: %w", err)
+			return nil, err
 		}
-		defer client.Close()
-
-		// Auth
-		if auth != nil {
-			if authErr := client.Auth(auth); authErr != nil {
-				return fmt.Errorf("failed to authenticate: %w", authErr)
-			}
+		result, err := c.send(session, msg, &identity, partial == config.PartialDeliveryAccept, dsn)
+		c.release(session)
+		if err == nil || !reused || attempt > 0 || !session.retryable(err) {
+			return result, err
 		}
+		c.logger.WithError(err).WithField("account", c.config.Name).Debug("Pooled SMTP connection failed, reconnecting")
+	}
+}
+
+// send writes one message over a session
+func (c *SMTPClient) send(session *smtpSession, msg *EmailMessage, identity *config.Identity, partial bool, dsn *DSNRequest) (*DeliveryResult, error) {
+	ext := session.ext
+
+	// Write addresses the way this server accepts them. The envelope
+	// sender and the header From are separate: bounces go to the first,
+	// and recipients see the second.
+	sender, senderUTF8, err := envelopeAddress(identity.EnvelopeFrom, ext.smtpUTF8)
+	if err != nil {
+		return nil, err
+	}
+	fromAddress, fromUTF8, err := envelopeAddress(identity.Address, ext.smtpUTF8)
+	if err != nil {
+		return nil, err
+	}
+	from := (&mail.Address{Name: identity.Name, Address: fromAddress}).String()
 
-		// Set sender
-		if mailErr := client.Mail(c.config.SMTPUsername); mailErr != nil {
-			return fmt.Errorf("failed to set sender: %w", mailErr)
+	prepared := *msg
+	if prepared.ReplyTo == "" {
+		prepared.ReplyTo = identity.ReplyTo
+	}
+	if identity.Signature != "" && !msg.OmitSignature {
+		addSignature(&prepared, identity.Signature)
+	}
+	var toUTF8, ccUTF8, bccUTF8 bool
+	if prepared.To, toUTF8, err = envelopeAddresses(msg.To, ext.smtpUTF8); err != nil {
+		return nil, err
+	}
+	if prepared.Cc, ccUTF8, err = envelopeAddresses(msg.Cc, ext.smtpUTF8); err != nil {
+		return nil, err
+	}
+	if prepared.Bcc, bccUTF8, err = envelopeAddresses(msg.Bcc, ext.smtpUTF8); err != nil {
+		return nil, err
+	}
+
+	// Create message
+	emailBytes, eightBit := c.createMessage(&prepared, from, ext.eightBitMIME)
+	if ext.size > 0 && int64(len(emailBytes)) > ext.size {
+		return nil, fmt.Errorf("message is %d bytes but the SMTP server accepts at most %d", len(emailBytes), ext.size)
+	}
+
+	var params []string
+	if ext.hasSize {
+		params = append(params, fmt.Sprintf("SIZE=%d", len(emailBytes)))
+	}
+	if eightBit {
+		params = append(params, "BODY=8BITMIME")
+	}
+	if senderUTF8 || fromUTF8 || toUTF8 || ccUTF8 || bccUTF8 {
+		params = append(params, "SMTPUTF8")
+	}
+
+	result := &DeliveryResult{}
+	var rcptParams []string
+	switch {
+	case dsn != nil && ext.dsn:
+		result.DSNRequested = true
+		result.EnvelopeID = dsn.EnvelopeID
+		if dsn.Return != "" {
+			params = append(params, "RET="+strings.ToUpper(dsn.Return))
 		}
+		params = append(params, "ENVID="+xtext(dsn.EnvelopeID))
+		rcptParams = append(rcptParams, "NOTIFY="+strings.ToUpper(strings.Join(dsn.Notify, ",")))
+	case dsn != nil:
+		result.Warnings = append(result.Warnings, "the SMTP server does not support DSN, so no delivery notifications were requested")
+	}
 
-		// Set recipients
-		recipients := append(append(msg.To, msg.Cc...), msg.Bcc...)
-		for _, to := range recipients {
-			if rcptErr := client.Rcpt(to); rcptErr != nil {
-				return fmt.Errorf("failed to set recipient %s: %w", to, rcptErr)
+	env := &envelope{
+		mail:       "MAIL FROM:<" + sender + ">",
+		recipients: append(append(append([]string{}, msg.To...), msg.Cc...), msg.Bcc...),
+		partial:    partial,
+	}
+	if len(params) > 0 {
+		env.mail += " " + strings.Join(params, " ")
+	}
+	for i, to := range append(append(prepared.To, prepared.Cc...), prepared.Bcc...) {
+		rcpt := "RCPT TO:<" + to + ">"
+		if len(rcptParams) > 0 {
+			rcpt += " " + strings.Join(rcptParams, " ")
+			// ORCPT keeps the address as given in notifications; addresses
+			// needing SMTPUTF8 would need the utf-8 address type instead
+			if original := env.recipients[i]; isASCII(original) {
+				rcpt += " ORCPT=rfc822;" + xtext(original)
 			}
 		}
+		env.rcpt = append(env.rcpt, rcpt)
+	}
 
-		// Send data
-		w, dataErr := client.Data()
-		if dataErr != nil {
-			return fmt.Errorf("failed to send data command: %w", dataErr)
-		}
+	return result, session.transaction(env, emailBytes, result)
+}
 
-		if _, writeErr := w.Write(emailBytes); writeErr != nil {
-			return fmt.Errorf("failed to write message: %w", writeErr)
-		}
+// Verify connects and authenticates without sending anything, and returns
+// the SASL mechanism used, or "" if the server was not asked to
+// authenticate
+func (c *SMTPClient) Verify() (string, error) {
+	session, err := c.open()
+	if err != nil {
+		return "", err
+	}
+	defer session.close()
+	return session.mechanism, nil
+}
 
-		if closeErr := w.Close(); closeErr != nil {
-			return fmt.Errorf("failed to close data writer: %w", closeErr)
-		}
+// dial connects to the server with the account's connection security and
+// returns the client and its underlying connection, for deadlines
+func (c *SMTPClient) dial() (*smtp.Client, net.Conn, error) {
+	addr := net.JoinHostPort(c.config.SMTPHost, strconv.Itoa(c.config.SMTPPort))
+	tlsConfig, err := tlsConfigFor(c.config, "smtp", c.config.SMTPHost, c.config.SMTPSecurity, c.logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to set up TLS for SMTP: %w", err)
+	}
 
-		return client.Quit()
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+	var conn net.Conn
+	if c.config.SMTPSecurity == config.SecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
 	} else {
-		// StartTLS connection (port 587)
-		client, err := smtp.Dial(addr)
-		if err != nil {
-			return fmt.Errorf("failed to connect to SMTP server: %w", err)
-		}
-		defer client.Close()
-
-		// Start TLS
-		if err := client.StartTLS(&tls.Config{
-			ServerName: c.config.SMTPHost,
-			MinVersion: tls.VersionTLS12,
-		}); err != nil {
-			return fmt.Errorf("failed to start TLS: %w", err)
-		}
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(smtpCommandTimeout)) //nolint:errcheck
 
-		// Auth
-		if auth != nil {
-			if authErr := client.Auth(auth); authErr != nil {
-				return fmt.Errorf("failed to authenticate: %w", authErr)
-			}
+	client, err := smtp.NewClient(conn, c.config.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to create SMTP client: %w", err)
+	}
+	if err := c.hello(client); err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	if c.config.SMTPSecurity == config.SecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, nil, fmt.Errorf("SMTP server does not offer STARTTLS; set security to tls or none")
 		}
-
-		// Set sender
-		if mailErr := client.Mail(c.config.SMTPUsername); mailErr != nil {
-			return fmt.Errorf("failed to set sender: %w", mailErr)
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, nil, fmt.Errorf("failed to start TLS: %w", err)
 		}
+	}
+	return client, conn, nil
+}
 
-		// Set recipients
-		recipients := append(append(msg.To, msg.Cc...), msg.Bcc...)
-		for _, to := range recipients {
-			if rcptErr := client.Rcpt(to); rcptErr != nil {
-				return fmt.Errorf("failed to set recipient %s: %w", to, rcptErr)
-			}
-		}
+// hello sends EHLO with the configured hostname; net/smtp sends
+// "localhost" otherwise
+func (c *SMTPClient) hello(client *smtp.Client) error {
+	if c.config.SMTPEHLOHostname == "" {
+		return nil
+	}
+	if err := client.Hello(c.config.SMTPEHLOHostname); err != nil {
+		return fmt.Errorf("SMTP server rejected EHLO %s: %w", c.config.SMTPEHLOHostname, err)
+	}
+	return nil
+}
 
-		// Send data
-		w, dataErr := client.Data()
-		if dataErr != nil {
-			return fmt.Errorf("failed to send data command: %w", dataErr)
-		}
+// authenticate logs in with an OAuth2 access token over SASL, or with the
+// password if one is set, and returns the mechanism used. Secrets are
+// resolved for every message so rotated ones are picked up.
+func (c *SMTPClient) authenticate(client *smtp.Client) (string, error) {
+	_, offered := client.Extension("AUTH")
+	mechanisms := strings.Fields(offered)
 
-		if _, writeErr := w.Write(emailBytes); writeErr != nil {
-			return fmt.Errorf("failed to write message: %w", writeErr)
-		}
+	if c.config.OAuth2 != nil {
+		mechanism := oauth.Mechanism(c.config.OAuth2.Mechanism, func(mech string) bool {
+			return containsFold(mechanisms, mech)
+		})
+		return mechanism, withAccessToken(c.config, func(token string) error {
+			return client.Auth(oauth.SMTPAuth(oauth.NewClient(mechanism, c.config.SMTPUsername, token, c.config.SMTPHost, c.config.SMTPPort)))
+		})
+	}
 
-		if closeErr := w.Close(); closeErr != nil {
-			return fmt.Errorf("failed to close data writer: %w", closeErr)
-		}
+	if !c.config.SMTPPassword.IsSet() {
+		return "", nil
+	}
+	password, err := c.config.SMTPPassword.Resolve()
+	if err != nil {
+		return "", fmt.Errorf("failed to get SMTP password from %s: %w", c.config.SMTPPassword.Source(), err)
+	}
+	_, encrypted := client.TLSConnectionState()
+	auth, mechanism, err := passwordAuth(c.config.SMTPAuthMechanism, mechanisms, c.config.SMTPUsername, password, c.config.SMTPHost, encrypted)
+	if err != nil {
+		return "", err
+	}
+	return mechanism, client.Auth(auth)
+}
 
-		return client.Quit()
+// addSignature appends a signature to the bodies of a message, after the
+// usual "-- " separator line
+func addSignature(msg *EmailMessage, signature string) {
+	if msg.BodyText != "" {
+		msg.BodyText = strings.TrimRight(msg.BodyText, "\r\n") + "\n\n-- \n" + signature
+	}
+	if msg.BodyHTML != "" {
+		lines := strings.Split(html.EscapeString(signature), "\n")
+		msg.BodyHTML += "\n<p>-- <br>\n" + strings.Join(lines, "<br>\n") + "</p>"
 	}
 }
 
-// createMessage creates an email message in MIME format
-func (c *SMTPClient) createMessage(msg *EmailMessage) []byte {
+// createMessage creates an email message in MIME format. It reports
+// whether the body is sent as 8bit, which only servers offering 8BITMIME
+// accept; for others non-ASCII text is quoted-printable.
+func (c *SMTPClient) createMessage(msg *EmailMessage, from string, eightBitMIME bool) ([]byte, bool) {
 	var buf bytes.Buffer
 
 	// Write headers manually (simpler approach)
-	buf.WriteString(fmt.Sprintf("From: %s\r\n", c.config.SMTPUsername))
+	buf.WriteString(fmt.Sprintf("From: %s\r\n", from))
 	buf.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(msg.To, ", ")))
 	if len(msg.Cc) > 0 {
 		buf.WriteString(fmt.Sprintf("Cc: %s\r\n", strings.Join(msg.Cc, ", ")))
 	}
-	buf.WriteString(fmt.Sprintf("Subject: %s\r\n", msg.Subject))
+	buf.WriteString(fmt.Sprintf("Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject)))
 	if msg.ReplyTo != "" {
 		buf.WriteString(fmt.Sprintf("Reply-To: %s\r\n", msg.ReplyTo))
 	}
 	if msg.InReplyTo != "" {
 		buf.WriteString(fmt.Sprintf("In-Reply-To: %s\r\n", msg.InReplyTo))
 	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
 
 	// Set content type
+	body, contentType := msg.BodyText, "text/plain"
 	if msg.BodyHTML != "" {
-		buf.WriteString("Content-Type: text/html; charset=utf-8\r\n")
-		buf.WriteString("\r\n")
-		buf.WriteString(msg.BodyHTML)
+		body, contentType = msg.BodyHTML, "text/html"
+	}
+	encoding := bodyEncoding(body, eightBitMIME)
+	buf.WriteString(fmt.Sprintf("Content-Type: %s; charset=utf-8\r\n", contentType))
+	buf.WriteString(fmt.Sprintf("Content-Transfer-Encoding: %s\r\n", encoding))
+	buf.WriteString("\r\n")
+	if encoding == "quoted-printable" {
+		qp := quotedprintable.NewWriter(&buf)
+		qp.Write([]byte(body)) //nolint:errcheck // writes to a bytes.Buffer
+		qp.Close()             //nolint:errcheck
 	} else {
-		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
-		buf.WriteString("\r\n")
-		buf.WriteString(msg.BodyText)
+		buf.WriteString(body)
+	}
+
+	return buf.Bytes(), encoding == "8bit"
+}
+
+// maxLineLength is the longest line SMTP allows, without CRLF (RFC 5321)
+const maxLineLength = 998
+
+// bodyEncoding picks the Content-Transfer-Encoding of a body: 7bit for
+// ASCII, 8bit for other text if the server offers 8BITMIME, and
+// quoted-printable otherwise or when a line is too long for SMTP
+func bodyEncoding(body string, eightBitMIME bool) string {
+	for _, line := range strings.Split(body, "\n") {
+		if len(line) > maxLineLength {
+			return "quoted-printable"
+		}
+	}
+	switch {
+	case isASCII(body):
+		return "7bit"
+	case eightBitMIME:
+		return "8bit"
 	}
+	return "quoted-printable"
+}
 
-	return buf.Bytes()
+// envelopeAddresses applies envelopeAddress to a list, reporting whether
+// any address needs SMTPUTF8
+func envelopeAddresses(addrs []string, smtpUTF8 bool) ([]string, bool, error) {
+	if addrs == nil {
+		return nil, false, nil
+	}
+	result := make([]string, len(addrs))
+	needsUTF8 := false
+	for i, addr := range addrs {
+		converted, utf8, err := envelopeAddress(addr, smtpUTF8)
+		if err != nil {
+			return nil, false, err
+		}
+		result[i] = converted
+		needsUTF8 = needsUTF8 || utf8
+	}
+	return result, needsUTF8, nil
+}
+
+// envelopeAddress returns an address as a server can take it. Servers
+// offering SMTPUTF8 take internationalized addresses as they are, which it
+// reports as needing SMTPUTF8; for others a non-ASCII domain is converted
+// to its ASCII form, and a non-ASCII local part is an error.
+func envelopeAddress(addr string, smtpUTF8 bool) (string, bool, error) {
+	if isASCII(addr) {
+		return addr, false, nil
+	}
+	if smtpUTF8 {
+		return addr, true, nil
+	}
+	at := strings.LastIndex(addr, "@")
+	if at < 0 || !isASCII(addr[:at]) {
+		return "", false, fmt.Errorf("address %s needs SMTPUTF8, which the SMTP server does not support", addr)
+	}
+	domain, err := idna.Lookup.ToASCII(addr[at+1:])
+	if err != nil {
+		return "", false, fmt.Errorf("invalid domain in address %s: %w", addr, err)
+	}
+	return addr[:at+1] + domain, false, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
 }
 
 // SetLogger sets the logger for the client
@@ -0,0 +1,130 @@
+package email
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"regexp"
+	"strings"
+
+	"github.com/brandon/mcp-email/internal/config"
+)
+
+// DSNRequest asks the server for delivery status notifications (RFC 3461)
+type DSNRequest struct {
+	// Notify holds the conditions to notify on: config.DSNNotifyNever, or
+	// any of config.DSNNotifySuccess, DSNNotifyFailure and DSNNotifyDelay
+	Notify []string
+	// Return is config.DSNReturnFull or DSNReturnHeaders for how much of
+	// the message a failure notice includes; empty leaves it to the server
+	Return string
+	// EnvelopeID is quoted in the notifications; one is generated if empty
+	EnvelopeID string
+}
+
+// validate checks a request and fills in its envelope ID
+func (r *DSNRequest) validate() error {
+	if err := config.CheckDSNNotify(r.Notify); err != nil {
+		return fmt.Errorf("invalid DSN notify: %w", err)
+	}
+	if err := config.CheckDSNReturn(r.Return); err != nil {
+		return fmt.Errorf("invalid DSN return: %w", err)
+	}
+	if r.EnvelopeID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		r.EnvelopeID = hex.EncodeToString(id)
+	}
+	if len(r.EnvelopeID) > 100 || !isASCII(r.EnvelopeID) {
+		return fmt.Errorf("DSN envelope ID must be at most 100 ASCII characters")
+	}
+	return nil
+}
+
+// DeliveryResult is the outcome of sending a message
+type DeliveryResult struct {
+	// Sent reports whether the server accepted the message for the
+	// accepted recipients
+	Sent       bool              `json:"sent"`
+	Recipients []RecipientResult `json:"recipients"`
+	// Response is the server's reply to the message, often with a queue ID
+	Response string `json:"response,omitempty"`
+	// DSNRequested reports whether notifications were requested; it is
+	// false when the server does not support DSN
+	DSNRequested bool     `json:"dsn_requested"`
+	EnvelopeID   string   `json:"envelope_id,omitempty"`
+	Warnings     []string `json:"warnings,omitempty"`
+}
+
+// RecipientResult is the server's reply to one recipient
+type RecipientResult struct {
+	Address  string `json:"address"`
+	Accepted bool   `json:"accepted"`
+	Code     int    `json:"code"`
+	// EnhancedStatus is the RFC 3463 status, such as 5.1.1, if given
+	EnhancedStatus string `json:"enhanced_status,omitempty"`
+	Message        string `json:"message"`
+}
+
+// Accepted returns the number of accepted recipients
+func (r *DeliveryResult) Accepted() int {
+	n := 0
+	for _, recipient := range r.Recipients {
+		if recipient.Accepted {
+			n++
+		}
+	}
+	return n
+}
+
+// Rejected returns the recipients the server refused
+func (r *DeliveryResult) Rejected() []RecipientResult {
+	var rejected []RecipientResult
+	for _, recipient := range r.Recipients {
+		if !recipient.Accepted {
+			rejected = append(rejected, recipient)
+		}
+	}
+	return rejected
+}
+
+// enhancedStatus matches an RFC 3463 status at the start of a reply
+var enhancedStatus = regexp.MustCompile(`^[245]\.\d{1,3}\.\d{1,3}\b`)
+
+// recipientResult records the reply to RCPT TO for address
+func recipientResult(address string, code int, message string, err error) RecipientResult {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		code, message = protoErr.Code, protoErr.Msg
+	}
+	result := RecipientResult{
+		Address:  address,
+		Accepted: err == nil,
+		Code:     code,
+		Message:  message,
+	}
+	if status := enhancedStatus.FindString(message); status != "" {
+		result.EnhancedStatus = status
+		result.Message = strings.TrimSpace(message[len(status):])
+	}
+	return result
+}
+
+// xtext encodes a DSN parameter value (RFC 3461 section 4): printable
+// ASCII except "+" and "=" as it is, anything else as +XX
+func xtext(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c >= '!' && c <= '~' && c != '+' && c != '=' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "+%02X", c)
+		}
+	}
+	return b.String()
+}
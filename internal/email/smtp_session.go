@@ -0,0 +1,361 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	smtpDialTimeout = 30 * time.Second
+	// smtpCommandTimeout bounds connecting and each message, including
+	// writing it (RFC 5321 section 4.5.3.2 allows servers 10 minutes)
+	smtpCommandTimeout = 10 * time.Minute
+	// smtpCheckTimeout bounds the RSET that checks a pooled session and
+	// the QUIT that ends one
+	smtpCheckTimeout = 10 * time.Second
+)
+
+// smtpExtensionSet holds the EHLO extensions that change how a message is
+// sent
+type smtpExtensionSet struct {
+	// size is the largest message the server accepts, or 0 for no limit
+	size         int64
+	hasSize      bool
+	eightBitMIME bool
+	smtpUTF8     bool
+	pipelining   bool
+	dsn          bool
+}
+
+func smtpExtensions(client *smtp.Client) smtpExtensionSet {
+	var ext smtpExtensionSet
+	if ok, param := client.Extension("SIZE"); ok {
+		ext.hasSize = true
+		ext.size, _ = strconv.ParseInt(strings.TrimSpace(param), 10, 64)
+	}
+	ext.eightBitMIME, _ = client.Extension("8BITMIME")
+	ext.smtpUTF8, _ = client.Extension("SMTPUTF8")
+	ext.pipelining, _ = client.Extension("PIPELINING")
+	ext.dsn, _ = client.Extension("DSN")
+	return ext
+}
+
+// smtpSession is an authenticated SMTP connection, used for one message at
+// a time
+type smtpSession struct {
+	client    *smtp.Client
+	conn      net.Conn
+	ext       smtpExtensionSet
+	mechanism string
+	idleSince time.Time
+
+	// broken is set when the connection failed or was left mid-transaction;
+	// such sessions are closed rather than pooled
+	broken bool
+	// handedOver is set once the end of a message was written, after which
+	// the server may have accepted it even if the reply was lost
+	handedOver bool
+}
+
+// open connects, reads the server's extensions and authenticates
+func (c *SMTPClient) open() (*smtpSession, error) {
+	client, conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	mechanism, err := c.authenticate(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to authenticate: %w", err)
+	}
+	return &smtpSession{
+		client:    client,
+		conn:      conn,
+		ext:       smtpExtensions(client),
+		mechanism: mechanism,
+	}, nil
+}
+
+// session takes an idle session from the pool, or opens one. Pooled
+// sessions are checked with RSET, which also clears what the previous
+// message left behind. It reports whether the session was reused.
+func (c *SMTPClient) session() (*smtpSession, bool, error) {
+	for {
+		c.mu.Lock()
+		var s *smtpSession
+		if n := len(c.idle); n > 0 {
+			s, c.idle = c.idle[n-1], c.idle[:n-1]
+		}
+		c.mu.Unlock()
+		if s == nil {
+			break
+		}
+
+		if time.Since(s.idleSince) < c.config.SMTPIdleTimeout {
+			s.conn.SetDeadline(time.Now().Add(smtpCheckTimeout)) //nolint:errcheck
+			if err := s.client.Reset(); err == nil {
+				s.conn.SetDeadline(time.Now().Add(smtpCommandTimeout)) //nolint:errcheck
+				s.handedOver = false
+				c.logger.WithField("account", c.config.Name).Debug("Reusing SMTP connection")
+				return s, true, nil
+			}
+		}
+		s.client.Close()
+	}
+
+	s, err := c.open()
+	return s, false, err
+}
+
+// release returns a session to the pool, or closes it if it is broken or
+// the pool is full
+func (c *SMTPClient) release(s *smtpSession) {
+	c.mu.Lock()
+	if s.broken || c.closed || len(c.idle) >= c.config.SMTPMaxIdle {
+		c.mu.Unlock()
+		s.close()
+		return
+	}
+	s.idleSince = time.Now()
+	c.idle = append(c.idle, s)
+	if c.reaper == nil {
+		c.reaper = time.AfterFunc(c.config.SMTPIdleTimeout, c.reap)
+	}
+	c.mu.Unlock()
+}
+
+// reap closes the sessions idle for longer than the idle timeout, and runs
+// again when the next one expires
+func (c *SMTPClient) reap() {
+	c.mu.Lock()
+	var expired []*smtpSession
+	kept := c.idle[:0]
+	for _, s := range c.idle {
+		if time.Since(s.idleSince) >= c.config.SMTPIdleTimeout {
+			expired = append(expired, s)
+		} else {
+			kept = append(kept, s)
+		}
+	}
+	c.idle = kept
+	c.reaper = nil
+	if len(kept) > 0 && !c.closed {
+		// Sessions are pooled in order, so the first expires first
+		c.reaper = time.AfterFunc(c.config.SMTPIdleTimeout-time.Since(kept[0].idleSince), c.reap)
+	}
+	c.mu.Unlock()
+
+	for _, s := range expired {
+		s.close()
+	}
+}
+
+// Close ends the pooled SMTP sessions
+func (c *SMTPClient) Close() error {
+	c.mu.Lock()
+	idle := c.idle
+	c.idle = nil
+	c.closed = true
+	if c.reaper != nil {
+		c.reaper.Stop()
+		c.reaper = nil
+	}
+	c.mu.Unlock()
+
+	for _, s := range idle {
+		s.close()
+	}
+	return nil
+}
+
+// close ends the session with QUIT, or drops the connection if it is broken
+func (s *smtpSession) close() {
+	if !s.broken {
+		s.conn.SetDeadline(time.Now().Add(smtpCheckTimeout)) //nolint:errcheck
+		if s.client.Quit() == nil {
+			return
+		}
+	}
+	s.client.Close()
+}
+
+// envelope is what a transaction sends before the message
+type envelope struct {
+	// mail is the MAIL FROM command and rcpt the RCPT TO command of each
+	// recipient
+	mail string
+	rcpt []string
+	// recipients are the addresses as given, for the results
+	recipients []string
+	// partial sends the message when only some recipients are accepted
+	partial bool
+}
+
+// deliverable reports whether the message goes out with the recipients
+// accepted so far
+func (e *envelope) deliverable(result *DeliveryResult) bool {
+	accepted := result.Accepted()
+	return accepted > 0 && (e.partial || accepted == len(result.Recipients))
+}
+
+// transaction sends one message: MAIL FROM, RCPT TO for each recipient and
+// DATA. With PIPELINING these commands go out together and their replies
+// are read afterwards, saving a round trip per recipient. The reply to each
+// recipient is recorded in result; if the envelope's policy does not allow
+// the recipients accepted, nothing is sent and the error is nil.
+func (s *smtpSession) transaction(env *envelope, message []byte, result *DeliveryResult) error {
+	for _, command := range append([]string{env.mail}, env.rcpt...) {
+		if strings.ContainsAny(command, "\r\n") {
+			return fmt.Errorf("%s contains a line break", command)
+		}
+	}
+
+	var err error
+	if s.ext.pipelining {
+		err = s.pipelinedEnvelope(env, result)
+	} else {
+		err = s.sequentialEnvelope(env, result)
+	}
+	if err != nil || !env.deliverable(result) {
+		return err
+	}
+
+	w := s.client.Text.DotWriter()
+	if _, err := w.Write(message); err != nil {
+		s.broken = true
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	s.handedOver = true
+	if err := w.Close(); err != nil {
+		s.broken = true
+		return fmt.Errorf("failed to close data writer: %w", err)
+	}
+	_, response, err := s.readReply(250)
+	if err != nil {
+		return fmt.Errorf("server rejected message: %w", err)
+	}
+	result.Sent = true
+	result.Response = response
+	return nil
+}
+
+// sequentialEnvelope sends MAIL FROM, RCPT TO and, if the message is to go
+// out, DATA, each after the previous reply
+func (s *smtpSession) sequentialEnvelope(env *envelope, result *DeliveryResult) error {
+	if _, _, err := s.cmd(250, "%s", env.mail); err != nil {
+		return fmt.Errorf("failed to set sender: %w", err)
+	}
+	for i, rcpt := range env.rcpt {
+		code, message, err := s.cmd(25, "%s", rcpt)
+		if s.broken {
+			return fmt.Errorf("failed to set recipient %s: %w", env.recipients[i], err)
+		}
+		result.Recipients = append(result.Recipients, recipientResult(env.recipients[i], code, message, err))
+	}
+	if !env.deliverable(result) {
+		return nil
+	}
+	if _, _, err := s.cmd(354, "DATA"); err != nil {
+		return fmt.Errorf("failed to send data command: %w", err)
+	}
+	return nil
+}
+
+// pipelinedEnvelope sends MAIL FROM, every RCPT TO and DATA at once and
+// then reads their replies in order (RFC 2920)
+func (s *smtpSession) pipelinedEnvelope(env *envelope, result *DeliveryResult) error {
+	text := s.client.Text
+	commands := append(append([]string{env.mail}, env.rcpt...), "DATA")
+	ids := make([]uint, len(commands))
+	for i, command := range commands {
+		id, err := text.Cmd("%s", command)
+		if err != nil {
+			s.broken = true
+			return fmt.Errorf("failed to send %s: %w", strings.Fields(command)[0], err)
+		}
+		ids[i] = id
+	}
+
+	// Every reply is read, even after a failure, to keep the stream in step
+	var senderErr, dataErr error
+	for i, id := range ids {
+		expect := 25
+		switch i {
+		case 0:
+			expect = 250
+		case len(ids) - 1:
+			expect = 354
+		}
+		text.StartResponse(id)
+		code, message, err := s.readReply(expect)
+		text.EndResponse(id)
+		if s.broken {
+			return fmt.Errorf("failed to read SMTP reply: %w", err)
+		}
+
+		switch i {
+		case 0:
+			if err != nil {
+				senderErr = fmt.Errorf("failed to set sender: %w", err)
+			}
+		case len(ids) - 1:
+			dataErr = err
+		default:
+			if senderErr == nil {
+				result.Recipients = append(result.Recipients, recipientResult(env.recipients[i-1], code, message, err))
+			}
+		}
+	}
+
+	deliverable := senderErr == nil && env.deliverable(result)
+	switch {
+	case dataErr == nil && !deliverable:
+		// The server accepted DATA and now waits for the message; the only
+		// way out without delivering it is to drop the connection
+		s.broken = true
+	case dataErr != nil && deliverable:
+		return fmt.Errorf("failed to send data command: %w", dataErr)
+	}
+	return senderErr
+}
+
+// cmd sends a command and reads its reply
+func (s *smtpSession) cmd(expect int, format string, args ...interface{}) (int, string, error) {
+	text := s.client.Text
+	id, err := text.Cmd(format, args...)
+	if err != nil {
+		s.broken = true
+		return 0, "", err
+	}
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+	return s.readReply(expect)
+}
+
+// readReply reads a reply, marking the session broken unless the server
+// answered and is not closing the connection
+func (s *smtpSession) readReply(expect int) (int, string, error) {
+	code, message, err := s.client.Text.ReadResponse(expect)
+	var protoErr *textproto.Error
+	if err != nil && (!errors.As(err, &protoErr) || protoErr.Code == 421) {
+		s.broken = true
+	}
+	return code, message, err
+}
+
+// retryable reports whether a failed send may be repeated on a new
+// session: the connection failed or the server closed it before the
+// message was handed over
+func (s *smtpSession) retryable(err error) bool {
+	if !s.broken || s.handedOver {
+		return false
+	}
+	var protoErr *textproto.Error
+	return !errors.As(err, &protoErr) || protoErr.Code == 421
+}
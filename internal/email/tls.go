@@ -0,0 +1,32 @@
+package email
+
+import (
+	"crypto/tls"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/internal/config"
+)
+
+// insecureWarned remembers the servers already warned about, so each
+// insecure setting is logged once rather than on every connection
+var insecureWarned sync.Map
+
+// tlsConfigFor returns the TLS configuration of an account for host, warning
+// once about connections that are unencrypted or not verified
+func tlsConfigFor(account *config.AccountConfig, protocol, host, security string, logger *logrus.Logger) (*tls.Config, error) {
+	fields := logrus.Fields{"account": account.Name, "protocol": protocol, "host": host}
+	if security == config.SecurityNone {
+		if _, warned := insecureWarned.LoadOrStore(account.Name+"/"+protocol+"/none", true); !warned {
+			logger.WithFields(fields).Warn("Connection security is none: credentials and messages are sent unencrypted")
+		}
+		return nil, nil
+	}
+	if account.TLS.InsecureSkipVerify {
+		if _, warned := insecureWarned.LoadOrStore(account.Name+"/"+protocol+"/verify", true); !warned {
+			logger.WithFields(fields).Warn("insecure_skip_verify is set: the server certificate is not verified")
+		}
+	}
+	return account.TLS.ClientConfig(host)
+}
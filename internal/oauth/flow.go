@@ -0,0 +1,260 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deviceGrantType is the grant of the device authorization flow (RFC 8628)
+const deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// ErrNoDeviceFlow is returned for providers without a device endpoint
+var ErrNoDeviceFlow = errors.New("no OAuth2 device authorization endpoint")
+
+// deviceResponse is the JSON body of a device authorization response.
+// Google calls the verification URI verification_url.
+type deviceResponse struct {
+	DeviceCode              string    `json:"device_code"`
+	UserCode                string    `json:"user_code"`
+	VerificationURI         string    `json:"verification_uri"`
+	VerificationURL         string    `json:"verification_url"`
+	VerificationURIComplete string    `json:"verification_uri_complete"`
+	ExpiresIn               expiresIn `json:"expires_in"`
+	Interval                expiresIn `json:"interval"`
+	oauthError
+}
+
+// DeviceFlow signs in with the device authorization flow: the user enters
+// a code on another device while the token endpoint is polled
+func (s *TokenSource) DeviceFlow(ctx context.Context, out io.Writer) (*Token, error) {
+	if s.config.DeviceAuthURL == "" {
+		return nil, fmt.Errorf("%w for account %s: set oauth2.device_auth_url or use the browser flow", ErrNoDeviceFlow, s.account)
+	}
+
+	form := url.Values{"scope": {strings.Join(s.config.Scopes, " ")}}
+	var device deviceResponse
+	if err := s.post(ctx, s.config.DeviceAuthURL, form, &device); err != nil {
+		return nil, err
+	}
+	verification := device.VerificationURI
+	if verification == "" {
+		verification = device.VerificationURL
+	}
+	if device.DeviceCode == "" || device.UserCode == "" || verification == "" {
+		return nil, fmt.Errorf("OAuth2 device endpoint returned an incomplete response")
+	}
+
+	fmt.Fprintf(out, "To sign in, open %s and enter the code %s\n", verification, device.UserCode)
+	if device.VerificationURIComplete != "" {
+		fmt.Fprintf(out, "or open %s\n", device.VerificationURIComplete)
+	}
+	fmt.Fprintln(out, "Waiting for authorization...")
+
+	interval := time.Duration(device.Interval.seconds()) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if seconds := device.ExpiresIn.seconds(); seconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(seconds)*time.Second)
+		defer cancel()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("device code expired before authorization: %w", ctx.Err())
+		case <-time.After(interval):
+		}
+
+		token, err := s.Exchange(ctx, url.Values{
+			"grant_type":  {deviceGrantType},
+			"device_code": {device.DeviceCode},
+		})
+		var tokenErr *TokenError
+		if errors.As(err, &tokenErr) {
+			switch tokenErr.Code {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				interval += 5 * time.Second
+				continue
+			}
+		}
+		return token, err
+	}
+}
+
+// BrowserFlow signs in with the authorization code flow and PKCE (RFC
+// 7636). The provider redirects the browser to a listener on the loopback
+// interface, on port or any free port if it is 0.
+func (s *TokenSource) BrowserFlow(ctx context.Context, port int, loginHint string, openBrowser bool, out io.Writer) (*Token, error) {
+	if s.config.AuthURL == "" {
+		return nil, fmt.Errorf("no OAuth2 authorization endpoint for account %s: set oauth2.auth_url", s.account)
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the OAuth2 redirect: %w", err)
+	}
+	defer listener.Close()
+	redirectURI := fmt.Sprintf("http://%s:%d/", s.config.RedirectHost, listener.Addr().(*net.TCPAddr).Port)
+
+	state, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {s.config.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(s.config.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+		// Google issues a refresh token only with offline access, and
+		// again for an earlier grant only after the consent screen
+		"access_type": {"offline"},
+		"prompt":      {"consent"},
+	}
+	if loginHint != "" {
+		query.Set("login_hint", loginHint)
+	}
+	authURL := s.config.AuthURL
+	if strings.Contains(authURL, "?") {
+		authURL += "&" + query.Encode()
+	} else {
+		authURL += "?" + query.Encode()
+	}
+
+	type callback struct {
+		code string
+		err  error
+	}
+	result := make(chan callback, 1)
+	server := &http.Server{
+		ReadHeaderTimeout: 10 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			params := r.URL.Query()
+			if params.Get("state") != state {
+				http.Error(w, "Unexpected request.", http.StatusBadRequest)
+				return
+			}
+			var got callback
+			if code := params.Get("error"); code != "" {
+				got.err = &TokenError{Code: code, Description: params.Get("error_description")}
+			} else if got.code = params.Get("code"); got.code == "" {
+				got.err = fmt.Errorf("OAuth2 redirect carried no authorization code")
+			}
+			message := "Signed in. You can close this window and return to the terminal."
+			if got.err != nil {
+				message = "Sign-in failed: " + got.err.Error()
+			}
+			fmt.Fprintf(w, "<!DOCTYPE html><title>mcp-email</title><p>%s</p>", html.EscapeString(message))
+			select {
+			case result <- got:
+			default:
+			}
+		}),
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	fmt.Fprintf(out, "To sign in, open this URL in a browser on this machine:\n\n%s\n\n", authURL)
+	if openBrowser {
+		if err := startBrowser(authURL); err == nil {
+			fmt.Fprintln(out, "A browser window has been opened.")
+		}
+	}
+	fmt.Fprintln(out, "Waiting for authorization...")
+
+	var got callback
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("authorization not completed: %w", ctx.Err())
+	case got = <-result:
+	}
+	if got.err != nil {
+		return nil, got.err
+	}
+
+	return s.Exchange(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {got.code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+}
+
+// randomString returns n random bytes, base64url encoded
+func randomString(n int) (string, error) {
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		return "", fmt.Errorf("failed to generate random data: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// startBrowser opens a URL with the desktop's default browser
+func startBrowser(target string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", target)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", target)
+	default:
+		cmd = exec.Command("xdg-open", target)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// Email returns the email address in the token's ID token, if any. The ID
+// token came straight from the token endpoint over TLS, so its signature is
+// not checked; the address is only displayed.
+func (t *Token) Email() string {
+	parts := strings.Split(t.IDToken, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Email             string `json:"email"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	if claims.Email != "" {
+		return claims.Email
+	}
+	return claims.PreferredUsername
+}
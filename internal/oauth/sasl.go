@@ -0,0 +1,111 @@
+package oauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/smtp"
+	"strings"
+
+	"github.com/emersion/go-sasl"
+
+	"github.com/brandon/mcp-email/internal/config"
+)
+
+// XOAuth2 is the name of Google's and Microsoft's SASL mechanism for access
+// tokens, which predates OAUTHBEARER (RFC 7628)
+const XOAuth2 = "XOAUTH2"
+
+// XOAuth2Error is the error a server sends as an XOAUTH2 challenge
+type XOAuth2Error struct {
+	Status  string `json:"status"`
+	Schemes string `json:"schemes"`
+	Scope   string `json:"scope"`
+}
+
+func (e *XOAuth2Error) Error() string {
+	return fmt.Sprintf("XOAUTH2 authentication error (%s)", e.Status)
+}
+
+type xoauth2Client struct {
+	username string
+	token    string
+}
+
+// NewXOAuth2Client returns a SASL client for the XOAUTH2 mechanism
+func NewXOAuth2Client(username, token string) sasl.Client {
+	return &xoauth2Client{username: username, token: token}
+}
+
+func (c *xoauth2Client) Start() (string, []byte, error) {
+	return XOAuth2, []byte("user=" + c.username + "\x01auth=Bearer " + c.token + "\x01\x01"), nil
+}
+
+// Next receives the server's error report; the exchange is then aborted
+func (c *xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	var authErr XOAuth2Error
+	if err := json.Unmarshal(challenge, &authErr); err != nil {
+		return nil, sasl.ErrUnexpectedServerChallenge
+	}
+	return nil, &authErr
+}
+
+// Mechanism picks the SASL mechanism for an account: the configured one,
+// or with MechanismAuto OAUTHBEARER if the server supports it and XOAUTH2
+// otherwise
+func Mechanism(configured string, supports func(mech string) bool) string {
+	switch configured {
+	case config.MechanismOAuthBearer:
+		return sasl.OAuthBearer
+	case config.MechanismXOAuth2:
+		return XOAuth2
+	}
+	if supports(sasl.OAuthBearer) {
+		return sasl.OAuthBearer
+	}
+	return XOAuth2
+}
+
+// NewClient returns a SASL client presenting an access token with the
+// given mechanism
+func NewClient(mechanism, username, token, host string, port int) sasl.Client {
+	if mechanism == sasl.OAuthBearer {
+		return sasl.NewOAuthBearerClient(&sasl.OAuthBearerOptions{
+			Username: username,
+			Token:    token,
+			Host:     host,
+			Port:     port,
+		})
+	}
+	return NewXOAuth2Client(username, token)
+}
+
+// SMTPAuth adapts a SASL client to net/smtp
+func SMTPAuth(client sasl.Client) smtp.Auth {
+	return &smtpAuth{client: client}
+}
+
+type smtpAuth struct {
+	client sasl.Client
+}
+
+func (a *smtpAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	mech, ir, err := a.client.Start()
+	if err != nil {
+		return "", nil, err
+	}
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, fmt.Errorf("refusing to send an access token over an unencrypted connection")
+	}
+	return mech, ir, nil
+}
+
+func (a *smtpAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	return a.client.Next(fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1" || strings.HasSuffix(name, ".localhost")
+}
@@ -0,0 +1,385 @@
+// Package oauth obtains OAuth2 access tokens for mail accounts and
+// authenticates with them over SASL XOAUTH2 and OAUTHBEARER.
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brandon/mcp-email/internal/config"
+)
+
+// expiryMargin refreshes access tokens this long before they expire, so a
+// token does not run out between being handed out and being used
+const expiryMargin = time.Minute
+
+// ErrNoRefreshToken is returned when an account has no refresh token yet
+var ErrNoRefreshToken = errors.New("no OAuth2 refresh token")
+
+// Token is an access token and the refresh token that renews it
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	TokenType    string    `json:"token_type,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+	// IDToken is the OpenID Connect ID token, if the openid scope was
+	// granted
+	IDToken string `json:"id_token,omitempty"`
+}
+
+// Valid reports whether the access token can still be used
+func (t *Token) Valid() bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || time.Now().Add(expiryMargin).Before(t.Expiry))
+}
+
+// TokenSource hands out access tokens for one account, refreshing them
+// when they expire and saving them to the account's token store
+type TokenSource struct {
+	account string
+	config  *config.OAuth2Config
+	client  *http.Client
+
+	mu    sync.Mutex
+	token *Token
+	// rejected is an access token a server refused, not to be handed out
+	// again when it is read back from the store
+	rejected string
+}
+
+var (
+	sourcesMu sync.Mutex
+	sources   = make(map[string]*TokenSource)
+)
+
+// TokenSourceFor returns the token source of an account, shared by all of
+// its IMAP and SMTP connections so a token is refreshed only once
+func TokenSourceFor(account *config.AccountConfig) *TokenSource {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+
+	key := account.OAuth2.TokenFile
+	if account.OAuth2.TokenSecret != "" {
+		key = account.OAuth2.Secrets.Path + "#" + account.OAuth2.TokenSecret
+	}
+	if source, ok := sources[key]; ok {
+		return source
+	}
+	source := NewTokenSource(account.Name, account.OAuth2)
+	sources[key] = source
+	return source
+}
+
+// NewTokenSource creates a token source for an account's OAuth2 settings
+func NewTokenSource(account string, cfg *config.OAuth2Config) *TokenSource {
+	return &TokenSource{
+		account: account,
+		config:  cfg,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Token returns a valid access token, refreshing it if needed. Before
+// refreshing, the token store is read again, as the auth command or another
+// process may have saved new tokens since.
+func (s *TokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token.Valid() {
+		return s.token, nil
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if s.token.Valid() {
+		return s.token, nil
+	}
+	return s.refresh(ctx)
+}
+
+// Invalidate discards the access token after a server rejected it, so the
+// next Token call refreshes it
+func (s *TokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != nil {
+		s.rejected = s.token.AccessToken
+		s.token.AccessToken = ""
+	}
+}
+
+// load reads the saved tokens. Without them the configured refresh token
+// is used.
+func (s *TokenSource) load() error {
+	data, err := s.read()
+	if err != nil {
+		return err
+	}
+	token := &Token{}
+	if data != nil {
+		if err := json.Unmarshal(data, token); err != nil {
+			return fmt.Errorf("saved OAuth2 token in %s is corrupt: %w", s.Location(), err)
+		}
+	}
+	if token.AccessToken != "" && token.AccessToken == s.rejected {
+		token.AccessToken = ""
+	}
+	s.token = token
+	return nil
+}
+
+// read returns the saved tokens, or nil if there are none
+func (s *TokenSource) read() ([]byte, error) {
+	if s.config.TokenSecret != "" {
+		value, err := s.config.Secrets.Get(s.config.TokenSecret)
+		if errors.Is(err, config.ErrSecretNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []byte(value), nil
+	}
+
+	data, err := os.ReadFile(s.config.TokenFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OAuth2 token file: %w", err)
+	}
+	return data, nil
+}
+
+// Location describes where the account's tokens are saved
+func (s *TokenSource) Location() string {
+	if s.config.TokenSecret != "" {
+		return fmt.Sprintf("secrets file entry %s", s.config.TokenSecret)
+	}
+	return s.config.TokenFile
+}
+
+// refresh exchanges the refresh token for a new access token. If the saved
+// refresh token was revoked, a different configured one is tried next.
+func (s *TokenSource) refresh(ctx context.Context) (*Token, error) {
+	configured, err := s.config.RefreshToken.Resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []string{s.token.RefreshToken}
+	if configured != "" && configured != s.token.RefreshToken {
+		candidates = append(candidates, configured)
+	}
+
+	var lastErr error
+	for _, refreshToken := range candidates {
+		if refreshToken == "" {
+			continue
+		}
+		token, err := s.Exchange(ctx, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {refreshToken},
+		})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if token.RefreshToken == "" {
+			token.RefreshToken = refreshToken
+		}
+		if err := s.save(token); err != nil {
+			return nil, err
+		}
+		s.token = token
+		return token, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("%w for account %s: run mcp-email-server auth %s or set oauth2.refresh_token", ErrNoRefreshToken, s.account, s.account)
+	}
+	return nil, lastErr
+}
+
+// Exchange posts a grant to the token endpoint with the client credentials
+// and returns the issued token
+func (s *TokenSource) Exchange(ctx context.Context, grant url.Values) (*Token, error) {
+	form := url.Values{}
+	for key, values := range grant {
+		form[key] = values
+	}
+	if len(s.config.Scopes) > 0 && form.Get("scope") == "" {
+		form.Set("scope", strings.Join(s.config.Scopes, " "))
+	}
+
+	var result tokenResponse
+	if err := s.post(ctx, s.config.TokenURL, form, &result); err != nil {
+		return nil, err
+	}
+	if result.AccessToken == "" {
+		return nil, fmt.Errorf("OAuth2 token endpoint returned no access token")
+	}
+
+	token := &Token{
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+		TokenType:    result.TokenType,
+		IDToken:      result.IDToken,
+	}
+	if seconds := result.ExpiresIn.seconds(); seconds > 0 {
+		token.Expiry = time.Now().Add(time.Duration(seconds) * time.Second)
+	}
+	return token, nil
+}
+
+// post sends a form with the client credentials to an endpoint and decodes
+// its JSON response into result, which embeds an oauthError
+func (s *TokenSource) post(ctx context.Context, endpoint string, form url.Values, result interface{ err(int, string) error }) error {
+	form.Set("client_id", s.config.ClientID)
+	secret, err := s.config.ClientSecret.Resolve()
+	if err != nil {
+		return err
+	}
+	if secret != "" {
+		form.Set("client_secret", secret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("OAuth2 request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("OAuth2 request failed: %w", err)
+	}
+
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("OAuth2 endpoint returned %s with an unreadable body", resp.Status)
+	}
+	return result.err(resp.StatusCode, resp.Status)
+}
+
+// Store saves a token obtained by the auth command and hands it out from
+// now on
+func (s *TokenSource) Store(token *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.save(token); err != nil {
+		return err
+	}
+	s.token = token
+	return nil
+}
+
+// save writes the tokens to the secrets file, or to the token file
+// atomically, readable only by its owner
+func (s *TokenSource) save(token *Token) error {
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if s.config.TokenSecret != "" {
+		if err := s.config.Secrets.Set(s.config.TokenSecret, string(data)); err != nil {
+			return fmt.Errorf("failed to save OAuth2 token: %w", err)
+		}
+		return nil
+	}
+
+	dir := filepath.Dir(s.config.TokenFile)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to save OAuth2 token: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.config.TokenFile)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to save OAuth2 token: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save OAuth2 token: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save OAuth2 token: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.config.TokenFile); err != nil {
+		return fmt.Errorf("failed to save OAuth2 token: %w", err)
+	}
+	return nil
+}
+
+// tokenResponse is the JSON body of a token endpoint response (RFC 6749
+// section 5)
+type tokenResponse struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	TokenType    string    `json:"token_type"`
+	ExpiresIn    expiresIn `json:"expires_in"`
+	IDToken      string    `json:"id_token"`
+	oauthError
+}
+
+// oauthError holds the error fields shared by OAuth2 endpoint responses
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// TokenError is an error response from the token endpoint
+type TokenError struct {
+	Code        string
+	Description string
+}
+
+func (e *TokenError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("OAuth2 token endpoint: %s: %s", e.Code, e.Description)
+	}
+	return "OAuth2 token endpoint: " + e.Code
+}
+
+// err returns the error reported in the response, if any
+func (r *oauthError) err(code int, status string) error {
+	if r.Code != "" {
+		return &TokenError{Code: r.Code, Description: r.Description}
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("OAuth2 endpoint returned %s", status)
+	}
+	return nil
+}
+
+// expiresIn accepts the lifetime as a number or, as some servers send it,
+// a string
+type expiresIn json.RawMessage
+
+func (e *expiresIn) UnmarshalJSON(data []byte) error {
+	*e = append((*e)[:0], data...)
+	return nil
+}
+
+func (e expiresIn) seconds() int64 {
+	text := strings.Trim(string(e), `"`)
+	n, _ := strconv.ParseInt(text, 10, 64)
+	return n
+}
@@ -0,0 +1,553 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+
+	"github.com/brandon/mcp-email/internal/config"
+)
+
+// tokenServer is a stand-in token endpoint. It answers a refresh grant with
+// the response registered for its refresh token, and any other with 400
+// invalid_grant.
+type tokenServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses map[string]tokenReply
+	requests  []url.Values
+}
+
+// tokenReply is the status and JSON body the endpoint sends
+type tokenReply struct {
+	status int
+	body   string
+}
+
+func newTokenServer(t *testing.T) *tokenServer {
+	t.Helper()
+	s := &tokenServer{responses: make(map[string]tokenReply)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *tokenServer) serve(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, r.PostForm)
+	reply, ok := s.responses[r.PostForm.Get("refresh_token")]
+	s.mu.Unlock()
+
+	if !ok {
+		reply = tokenReply{http.StatusBadRequest, `{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(reply.status)
+	fmt.Fprint(w, reply.body)
+}
+
+// respond registers the reply to a refresh token
+func (s *tokenServer) respond(refreshToken string, status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[refreshToken] = tokenReply{status, body}
+}
+
+// refreshed returns the refresh tokens presented so far
+func (s *tokenServer) refreshed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tokens []string
+	for _, form := range s.requests {
+		tokens = append(tokens, form.Get("refresh_token"))
+	}
+	return tokens
+}
+
+// fileConfig returns OAuth2 settings saving tokens in a file
+func fileConfig(t *testing.T, server *tokenServer) *config.OAuth2Config {
+	t.Helper()
+	return &config.OAuth2Config{
+		ClientID:  "client",
+		TokenURL:  server.URL,
+		Scopes:    []string{"mail", "openid"},
+		TokenFile: filepath.Join(t.TempDir(), "oauth2", "test.json"),
+	}
+}
+
+// writeToken saves a token where a source with cfg finds it
+func writeToken(t *testing.T, cfg *config.OAuth2Config, token *Token) {
+	t.Helper()
+	if err := NewTokenSource("test", cfg).Store(token); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+}
+
+// readToken returns the saved token of cfg
+func readToken(t *testing.T, cfg *config.OAuth2Config) *Token {
+	t.Helper()
+	var data []byte
+	if cfg.TokenSecret != "" {
+		value, err := cfg.Secrets.Get(cfg.TokenSecret)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		data = []byte(value)
+	} else {
+		var err error
+		if data, err = os.ReadFile(cfg.TokenFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token := &Token{}
+	if err := json.Unmarshal(data, token); err != nil {
+		t.Fatalf("saved token is corrupt: %v", err)
+	}
+	return token
+}
+
+func expired() *Token {
+	return &Token{AccessToken: "old-access", RefreshToken: "saved", Expiry: time.Now().Add(-time.Hour)}
+}
+
+func TestTokenRefresh(t *testing.T) {
+	server := newTokenServer(t)
+	server.respond("saved", http.StatusOK, `{"access_token":"new-access","refresh_token":"rotated","token_type":"Bearer","expires_in":3600}`)
+	cfg := fileConfig(t, server)
+	writeToken(t, cfg, expired())
+
+	source := NewTokenSource("test", cfg)
+	token, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if token.AccessToken != "new-access" || token.RefreshToken != "rotated" || token.TokenType != "Bearer" {
+		t.Errorf("Token() = %+v", token)
+	}
+	if until := time.Until(token.Expiry); until < 59*time.Minute || until > time.Hour {
+		t.Errorf("Token() expires in %s, want an hour", until)
+	}
+
+	server.mu.Lock()
+	form := server.requests[0]
+	server.mu.Unlock()
+	want := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {"saved"},
+		"client_id":     {"client"},
+		"scope":         {"mail openid"},
+	}
+	for key := range want {
+		if form.Get(key) != want.Get(key) {
+			t.Errorf("request %s = %q, want %q", key, form.Get(key), want.Get(key))
+		}
+	}
+	if form.Has("client_secret") {
+		t.Errorf("request has a client_secret, but none is configured")
+	}
+
+	// The rotated refresh token is saved, readable only by its owner
+	if saved := readToken(t, cfg); saved.AccessToken != "new-access" || saved.RefreshToken != "rotated" {
+		t.Errorf("saved token = %+v", saved)
+	}
+	info, err := os.Stat(cfg.TokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("token file mode = %o, want 600", perm)
+	}
+
+	// A valid token is handed out without asking the endpoint again
+	if _, err := source.Token(context.Background()); err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if got := server.refreshed(); len(got) != 1 {
+		t.Errorf("endpoint asked %d times, want once", len(got))
+	}
+}
+
+func TestTokenRefreshKeepsRefreshToken(t *testing.T) {
+	// Most providers do not rotate refresh tokens and omit them
+	server := newTokenServer(t)
+	server.respond("saved", http.StatusOK, `{"access_token":"new-access","expires_in":3600}`)
+	cfg := fileConfig(t, server)
+	writeToken(t, cfg, expired())
+
+	if _, err := NewTokenSource("test", cfg).Token(context.Background()); err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if saved := readToken(t, cfg); saved.RefreshToken != "saved" {
+		t.Errorf("saved refresh token = %q, want saved", saved.RefreshToken)
+	}
+}
+
+func TestTokenRevokedFallsBackToConfigured(t *testing.T) {
+	server := newTokenServer(t)
+	server.respond("configured", http.StatusOK, `{"access_token":"new-access","expires_in":3600}`)
+
+	// A configured refresh token is a secret, which only a config file sets
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "test.json")
+	configFile := filepath.Join(dir, "config.yaml")
+	yaml := fmt.Sprintf(`accounts:
+  - name: test
+    imap:
+      host: imap.example.com
+      username: me@example.com
+    smtp:
+      host: smtp.example.com
+      username: me@example.com
+    oauth2:
+      client_id: client
+      token_url: %s
+      refresh_token: configured
+      token_file: %s
+`, server.URL, tokenFile)
+	if err := os.WriteFile(configFile, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := config.LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	cfg := loaded.Accounts[0].OAuth2
+	writeToken(t, cfg, expired())
+
+	token, err := NewTokenSource("test", cfg).Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if token.AccessToken != "new-access" || token.RefreshToken != "configured" {
+		t.Errorf("Token() = %+v", token)
+	}
+	if got, want := server.refreshed(), []string{"saved", "configured"}; !equal(got, want) {
+		t.Errorf("refresh tokens tried = %v, want %v", got, want)
+	}
+	if saved := readToken(t, cfg); saved.RefreshToken != "configured" {
+		t.Errorf("saved refresh token = %q, want configured", saved.RefreshToken)
+	}
+}
+
+func TestTokenExpiresIn(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn string
+		want      time.Duration
+	}{
+		{"number", `,"expires_in":3600`, time.Hour},
+		{"string", `,"expires_in":"3600"`, time.Hour},
+		{"missing", ``, 0},
+		{"unreadable", `,"expires_in":"soon"`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTokenServer(t)
+			server.respond("saved", http.StatusOK, `{"access_token":"new-access"`+tt.expiresIn+`}`)
+			cfg := fileConfig(t, server)
+			writeToken(t, cfg, expired())
+
+			token, err := NewTokenSource("test", cfg).Token(context.Background())
+			if err != nil {
+				t.Fatalf("Token() error = %v", err)
+			}
+			if tt.want == 0 {
+				if !token.Expiry.IsZero() {
+					t.Errorf("Token() expires at %s, want no expiry", token.Expiry)
+				}
+				return
+			}
+			if until := time.Until(token.Expiry); until < tt.want-time.Minute || until > tt.want {
+				t.Errorf("Token() expires in %s, want %s", until, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		code   string
+		want   string
+	}{
+		{
+			name:   "oauth error",
+			status: http.StatusBadRequest,
+			body:   `{"error":"invalid_client","error_description":"The OAuth client was not found."}`,
+			code:   "invalid_client",
+			want:   "OAuth2 token endpoint: invalid_client: The OAuth client was not found.",
+		},
+		{
+			name:   "oauth error without description",
+			status: http.StatusUnauthorized,
+			body:   `{"error":"unauthorized_client"}`,
+			code:   "unauthorized_client",
+			want:   "OAuth2 token endpoint: unauthorized_client",
+		},
+		{
+			name:   "oauth error with success status",
+			status: http.StatusOK,
+			body:   `{"error":"invalid_scope"}`,
+			code:   "invalid_scope",
+			want:   "OAuth2 token endpoint: invalid_scope",
+		},
+		{
+			name:   "status without oauth error",
+			status: http.StatusServiceUnavailable,
+			body:   `{}`,
+			want:   "OAuth2 endpoint returned 503 Service Unavailable",
+		},
+		{
+			name:   "unreadable body",
+			status: http.StatusBadGateway,
+			body:   `<html>Bad Gateway</html>`,
+			want:   "OAuth2 endpoint returned 502 Bad Gateway with an unreadable body",
+		},
+		{
+			name:   "no access token",
+			status: http.StatusOK,
+			body:   `{"token_type":"Bearer"}`,
+			want:   "OAuth2 token endpoint returned no access token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTokenServer(t)
+			server.respond("saved", tt.status, tt.body)
+			cfg := fileConfig(t, server)
+			writeToken(t, cfg, expired())
+
+			_, err := NewTokenSource("test", cfg).Token(context.Background())
+			if err == nil || err.Error() != tt.want {
+				t.Fatalf("Token() error = %v, want %q", err, tt.want)
+			}
+			var tokenErr *TokenError
+			if errors.As(err, &tokenErr) != (tt.code != "") || (tokenErr != nil && tokenErr.Code != tt.code) {
+				t.Errorf("Token() error = %#v, want a TokenError with code %q", err, tt.code)
+			}
+			// The saved token is kept for the next attempt
+			if saved := readToken(t, cfg); saved.RefreshToken != "saved" {
+				t.Errorf("saved refresh token = %q, want saved", saved.RefreshToken)
+			}
+		})
+	}
+}
+
+func TestTokenWithoutRefreshToken(t *testing.T) {
+	server := newTokenServer(t)
+	_, err := NewTokenSource("test", fileConfig(t, server)).Token(context.Background())
+	if !errors.Is(err, ErrNoRefreshToken) {
+		t.Fatalf("Token() error = %v, want ErrNoRefreshToken", err)
+	}
+	if !strings.Contains(err.Error(), "mcp-email-server auth test") {
+		t.Errorf("Token() error = %q, want a hint to run the auth command", err)
+	}
+	if got := server.refreshed(); len(got) != 0 {
+		t.Errorf("endpoint asked with %v, want no request", got)
+	}
+}
+
+func TestTokenReloadsStore(t *testing.T) {
+	server := newTokenServer(t)
+	server.respond("signed-in", http.StatusOK, `{"access_token":"new-access","expires_in":3600}`)
+	cfg := fileConfig(t, server)
+
+	// The server starts before the account is signed in
+	source := NewTokenSource("test", cfg)
+	if _, err := source.Token(context.Background()); !errors.Is(err, ErrNoRefreshToken) {
+		t.Fatalf("Token() error = %v, want ErrNoRefreshToken", err)
+	}
+
+	// The auth command saves tokens from another process
+	signedIn := &Token{AccessToken: "auth-access", RefreshToken: "signed-in", Expiry: time.Now().Add(time.Hour)}
+	writeToken(t, cfg, signedIn)
+	token, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token() after signing in error = %v", err)
+	}
+	if token.AccessToken != "auth-access" {
+		t.Errorf("Token() = %q, want the saved auth-access", token.AccessToken)
+	}
+
+	// Signing in again after the refresh token was revoked
+	revoked := &Token{AccessToken: "stale", RefreshToken: "revoked", Expiry: time.Now().Add(-time.Hour)}
+	writeToken(t, cfg, revoked)
+	source = NewTokenSource("test", cfg)
+	if _, err := source.Token(context.Background()); err == nil {
+		t.Fatalf("Token() with a revoked refresh token succeeded")
+	}
+	writeToken(t, cfg, &Token{RefreshToken: "signed-in"})
+	if token, err = source.Token(context.Background()); err != nil {
+		t.Fatalf("Token() after signing in again error = %v", err)
+	}
+	if token.AccessToken != "new-access" {
+		t.Errorf("Token() = %q, want new-access", token.AccessToken)
+	}
+}
+
+func TestTokenInvalidate(t *testing.T) {
+	server := newTokenServer(t)
+	server.respond("saved", http.StatusOK, `{"access_token":"new-access","expires_in":3600}`)
+	cfg := fileConfig(t, server)
+	writeToken(t, cfg, &Token{AccessToken: "rejected", RefreshToken: "saved", Expiry: time.Now().Add(time.Hour)})
+
+	source := NewTokenSource("test", cfg)
+	token, err := source.Token(context.Background())
+	if err != nil || token.AccessToken != "rejected" {
+		t.Fatalf("Token() = %v, %v, want the saved access token", token, err)
+	}
+
+	// The rejected token is still saved, but is not handed out again
+	source.Invalidate()
+	token, err = source.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if token.AccessToken != "new-access" {
+		t.Errorf("Token() after Invalidate = %q, want new-access", token.AccessToken)
+	}
+}
+
+func TestTokenSecretsFile(t *testing.T) {
+	server := newTokenServer(t)
+	server.respond("saved", http.StatusOK, `{"access_token":"new-access","refresh_token":"rotated","expires_in":3600}`)
+
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	secrets, err := config.NewSecretsFile(filepath.Join(t.TempDir(), "secrets.enc"), [][]byte{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := secrets.Set("imap/password", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.OAuth2Config{
+		ClientID:    "client",
+		TokenURL:    server.URL,
+		TokenSecret: "oauth2/test",
+		Secrets:     secrets,
+	}
+	writeToken(t, cfg, expired())
+
+	token, err := NewTokenSource("test", cfg).Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if token.AccessToken != "new-access" {
+		t.Errorf("Token() = %q, want new-access", token.AccessToken)
+	}
+	if saved := readToken(t, cfg); saved.AccessToken != "new-access" || saved.RefreshToken != "rotated" {
+		t.Errorf("saved token = %+v", saved)
+	}
+	// Other secrets are kept
+	if password, err := secrets.Get("imap/password"); err != nil || password != "hunter2" {
+		t.Errorf("other secret = %q, %v, want hunter2", password, err)
+	}
+
+	// Another source reads the saved token without refreshing
+	if token, err = NewTokenSource("test", cfg).Token(context.Background()); err != nil || token.AccessToken != "new-access" {
+		t.Errorf("Token() from a new source = %v, %v", token, err)
+	}
+	if got := server.refreshed(); len(got) != 1 {
+		t.Errorf("endpoint asked %d times, want once", len(got))
+	}
+}
+
+func TestSASLClients(t *testing.T) {
+	tests := []struct {
+		mechanism string
+		wantMech  string
+		want      string
+	}{
+		{XOAuth2, XOAuth2, "user=me@example.com\x01auth=Bearer ya29.token\x01\x01"},
+		{sasl.OAuthBearer, sasl.OAuthBearer, "n,a=me@example.com,\x01host=imap.example.com\x01port=993\x01auth=Bearer ya29.token\x01\x01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mechanism, func(t *testing.T) {
+			client := NewClient(tt.mechanism, "me@example.com", "ya29.token", "imap.example.com", 993)
+			mech, ir, err := client.Start()
+			if err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if mech != tt.wantMech || string(ir) != tt.want {
+				t.Errorf("Start() = %s %q, want %s %q", mech, ir, tt.wantMech, tt.want)
+			}
+		})
+	}
+}
+
+func TestXOAuth2Error(t *testing.T) {
+	client := NewXOAuth2Client("me@example.com", "ya29.token")
+	_, err := client.Next([]byte(`{"status":"401","schemes":"bearer","scope":"https://mail.google.com/"}`))
+	var authErr *XOAuth2Error
+	if !errors.As(err, &authErr) || authErr.Status != "401" || authErr.Scope != "https://mail.google.com/" {
+		t.Errorf("Next() error = %v, want an XOAuth2Error with status 401", err)
+	}
+	if _, err := client.Next([]byte("not json")); !errors.Is(err, sasl.ErrUnexpectedServerChallenge) {
+		t.Errorf("Next() error = %v, want ErrUnexpectedServerChallenge", err)
+	}
+}
+
+func TestMechanism(t *testing.T) {
+	tests := []struct {
+		configured string
+		supported  []string
+		want       string
+	}{
+		{config.MechanismAuto, []string{"PLAIN", sasl.OAuthBearer, XOAuth2}, sasl.OAuthBearer},
+		{config.MechanismAuto, []string{"PLAIN", XOAuth2}, XOAuth2},
+		{config.MechanismAuto, nil, XOAuth2},
+		{config.MechanismXOAuth2, []string{sasl.OAuthBearer}, XOAuth2},
+		{config.MechanismOAuthBearer, nil, sasl.OAuthBearer},
+	}
+
+	for _, tt := range tests {
+		supports := func(mech string) bool {
+			for _, supported := range tt.supported {
+				if supported == mech {
+					return true
+				}
+			}
+			return false
+		}
+		if got := Mechanism(tt.configured, supports); got != tt.want {
+			t.Errorf("Mechanism(%q, %v) = %s, want %s", tt.configured, tt.supported, got, tt.want)
+		}
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
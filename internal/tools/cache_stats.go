@@ -0,0 +1,86 @@
+package tools
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/internal/cache"
+	"github.com/brandon/mcp-email/internal/config"
+	"github.com/brandon/mcp-email/internal/email"
+)
+
+// CacheStatsTool reports cache size and contents per account and folder
+type CacheStatsTool struct {
+	config       *config.Config
+	emailManager *email.Manager
+	cacheStore   *cache.Store
+	logger       *logrus.Logger
+}
+
+// NewCacheStatsTool creates a new cache stats tool
+func NewCacheStatsTool(cfg *config.Config, emailManager *email.Manager, cacheStore *cache.Store, logger *logrus.Logger) *CacheStatsTool {
+	return &CacheStatsTool{
+		config:       cfg,
+		emailManager: emailManager,
+		cacheStore:   cacheStore,
+		logger:       logger,
+	}
+}
+
+// Name returns the tool name
+func (t *CacheStatsTool) Name() string {
+	return "cache_stats"
+}
+
+// Description returns the tool description
+func (t *CacheStatsTool) Description() string {
+	return "Report the local cache's on-disk size per table, cached message counts and sizes per account and folder, " +
+		"evicted bodies, the retention rules in effect and when eviction and vacuum last ran"
+}
+
+// InputSchema returns the JSON schema for tool inputs
+func (t *CacheStatsTool) InputSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"account_name": map[string]interface{}{
+				"type":        "string",
+				"description": "Optional: Specific account name, or all accounts if omitted",
+			},
+		},
+	}
+}
+
+// Execute executes the tool
+func (t *CacheStatsTool) Execute(params map[string]interface{}) (interface{}, error) {
+	var accountID *int
+	accountName, _ := params["account_name"].(string)
+	if accountName != "" {
+		if _, err := t.config.GetAccountByName(accountName); err != nil {
+			return nil, err
+		}
+		id, err := t.cacheStore.GetAccountID(accountName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get account: %w", err)
+		}
+		accountID = &id
+	}
+
+	stats, err := t.cacheStore.CacheStats(accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute cache stats: %w", err)
+	}
+
+	retention := []string{}
+	for _, rule := range t.config.Retention {
+		if accountName == "" || rule.MatchesAccount(accountName) {
+			retention = append(retention, rule.String())
+		}
+	}
+
+	return map[string]interface{}{
+		"cache":     stats,
+		"retention": retention,
+	}, nil
+}
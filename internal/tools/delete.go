@@ -0,0 +1,79 @@
+package tools
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/internal/cache"
+	"github.com/brandon/mcp-email/internal/config"
+	"github.com/brandon/mcp-email/internal/email"
+)
+
+// DeleteEmailsTool deletes emails, moving them to the trash by default
+type DeleteEmailsTool struct {
+	config       *config.Config
+	emailManager *email.Manager
+	cacheStore   *cache.Store
+	logger       *logrus.Logger
+}
+
+// NewDeleteEmailsTool creates a new delete emails tool
+func NewDeleteEmailsTool(cfg *config.Config, emailManager *email.Manager, cacheStore *cache.Store, logger *logrus.Logger) *DeleteEmailsTool {
+	return &DeleteEmailsTool{
+		config:       cfg,
+		emailManager: emailManager,
+		cacheStore:   cacheStore,
+		logger:       logger,
+	}
+}
+
+// Name returns the tool name
+func (t *DeleteEmailsTool) Name() string {
+	return "delete_emails"
+}
+
+// Description returns the tool description
+func (t *DeleteEmailsTool) Description() string {
+	return "Delete emails by moving them to the trash folder, or permanently remove them"
+}
+
+// InputSchema returns the JSON schema for tool inputs
+func (t *DeleteEmailsTool) InputSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"email_ids": emailIDsSchema,
+			"permanent": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Optional: Expunge instead of moving to trash (default: false)",
+			},
+		},
+		"required": []string{"email_ids"},
+	}
+}
+
+// Execute executes the tool
+func (t *DeleteEmailsTool) Execute(params map[string]interface{}) (interface{}, error) {
+	emailIDs, err := parseEmailIDs(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse permanent (optional)
+	permanent := false
+	if p, ok := params["permanent"].(bool); ok {
+		permanent = p
+	}
+
+	deleted, err := t.emailManager.DeleteEmails(emailIDs, permanent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete emails (%d deleted): %w", deleted, err)
+	}
+
+	return map[string]interface{}{
+		"success":   true,
+		"deleted":   deleted,
+		"permanent": permanent,
+	}, nil
+}
@@ -0,0 +1,224 @@
+package tools
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/internal/cache"
+	"github.com/brandon/mcp-email/internal/config"
+	"github.com/brandon/mcp-email/internal/email"
+)
+
+// withSearchFilters adds the email filter properties shared by search_emails
+// and mailbox_stats to a tool's input schema properties
+func withSearchFilters(properties map[string]interface{}) map[string]interface{} {
+	filters := map[string]interface{}{
+		"account_name": map[string]interface{}{
+			"type":        "string",
+			"description": "Optional: Filter by specific account",
+		},
+		"folder": map[string]interface{}{
+			"type":  []string{"string", "array"},
+			"items": map[string]interface{}{"type": "string"},
+			"description": "Optional: Filter by folder path or special-use role " +
+				"(inbox, sent, drafts, trash, junk/spam, archive, all); an array matches any of them",
+		},
+		"exclude_folders": map[string]interface{}{
+			"type":        []string{"string", "array"},
+			"items":       map[string]interface{}{"type": "string"},
+			"description": "Optional: Folder paths or special-use roles to leave out (e.g. [\"junk\", \"trash\"])",
+		},
+		"query": map[string]interface{}{
+			"type": "string",
+			"description": "Optional: Gmail-style query, e.g. from:alice subject:\"Q3 plan\" has:attachment " +
+				"after:2025-01-01 is:unread -label:spam. Supports OR, - (NOT), parentheses and quoted phrases",
+		},
+		"sender": map[string]interface{}{
+			"type":        "string",
+			"description": "Optional: Filter by sender email/name",
+		},
+		"recipient": map[string]interface{}{
+			"type":        "string",
+			"description": "Optional: Filter by recipient email",
+		},
+		"subject": map[string]interface{}{
+			"type":        "string",
+			"description": "Optional: Filter by subject (substring match)",
+		},
+		"body": map[string]interface{}{
+			"type":        "string",
+			"description": "Optional: Filter by body content (full-text search)",
+		},
+		"date_from": map[string]interface{}{
+			"type":        "string",
+			"description": "Optional: Start date (ISO 8601 format)",
+		},
+		"date_to": map[string]interface{}{
+			"type":        "string",
+			"description": "Optional: End date (ISO 8601 format)",
+		},
+	}
+
+	for name, schema := range filters {
+		properties[name] = schema
+	}
+	return properties
+}
+
+// parseSearchFilters builds search options from the filter params shared by
+// search_emails and mailbox_stats, syncing accounts with nothing cached yet
+func parseSearchFilters(params map[string]interface{}, cfg *config.Config, emailManager *email.Manager,
+	cacheStore *cache.Store, logger *logrus.Logger) (cache.SearchOptions, error) {
+	opts := cache.SearchOptions{}
+
+	// Parse account_name
+	accountName, ok := params["account_name"].(string)
+	if !ok {
+		accountName = ""
+	}
+	if accountName != "" {
+		accountID, err := cacheStore.GetAccountID(accountName)
+		if err != nil {
+			// Account might not be in cache yet, try to sync
+			if syncErr := emailManager.SyncAccount(accountName, ""); syncErr != nil {
+				return opts, fmt.Errorf("failed to sync account: %w", syncErr)
+			}
+			accountID, err = cacheStore.GetAccountID(accountName)
+			if err != nil {
+				return opts, fmt.Errorf("account not found: %s", accountName)
+			}
+		}
+		opts.AccountID = &accountID
+
+		// Check if account has cached emails, if not, sync
+		hasEmails, err := cacheStore.HasEmails(accountID)
+		if err != nil {
+			logger.WithError(err).Warn("Failed to check if account has emails")
+		} else if !hasEmails {
+			logger.WithField("account", accountName).Info("No cached emails found, syncing account")
+			if err := emailManager.SyncAccount(accountName, ""); err != nil {
+				logger.WithError(err).WithField("account", accountName).Warn("Failed to sync account for search")
+				// Continue with search even if sync fails
+			}
+		}
+	} else {
+		// No account specified, check if any emails are cached
+		hasAnyEmails, err := cacheStore.HasAnyEmails()
+		if err != nil {
+			logger.WithError(err).Warn("Failed to check if any emails are cached")
+		} else if !hasAnyEmails {
+			// Sync all accounts
+			logger.Info("No cached emails found, syncing all accounts")
+			for _, accountName := range cfg.AccountNames() {
+				if err := emailManager.SyncAccount(accountName, ""); err != nil {
+					logger.WithError(err).WithField("account", accountName).Warn("Failed to sync account")
+				}
+			}
+		}
+	}
+
+	// Parse folder and exclude_folders (paths or special-use roles)
+	if folders := parseStringList(params["folder"]); len(folders) > 0 {
+		folderIDs, err := resolveFolders(cacheStore, accountName, opts.AccountID, folders)
+		if err != nil {
+			return opts, err
+		}
+		opts.FolderIDs = folderIDs
+	}
+	if excluded := parseStringList(params["exclude_folders"]); len(excluded) > 0 {
+		folderIDs, err := resolveFolders(cacheStore, accountName, opts.AccountID, excluded)
+		if err != nil {
+			return opts, err
+		}
+		opts.ExcludeFolderIDs = folderIDs
+	}
+
+	// Parse query
+	if query, ok := params["query"].(string); ok && strings.TrimSpace(query) != "" {
+		q, err := cache.ParseQuery(query)
+		if err != nil {
+			return opts, err
+		}
+		opts.Query = q
+	}
+
+	// Parse sender
+	if sender, ok := params["sender"].(string); ok && sender != "" {
+		opts.Sender = &sender
+	}
+
+	// Parse recipient
+	if recipient, ok := params["recipient"].(string); ok && recipient != "" {
+		opts.Recipient = &recipient
+	}
+
+	// Parse subject
+	if subject, ok := params["subject"].(string); ok && subject != "" {
+		opts.Subject = &subject
+	}
+
+	// Parse body (full-text search)
+	if body, ok := params["body"].(string); ok && body != "" {
+		opts.Body = &body
+	}
+
+	// Parse date_from
+	if dateFromStr, ok := params["date_from"].(string); ok && dateFromStr != "" {
+		dateFrom, err := time.Parse(time.RFC3339, dateFromStr)
+		if err != nil {
+			return opts, fmt.Errorf("invalid date_from format: %w", err)
+		}
+		opts.DateFrom = &dateFrom
+	}
+
+	// Parse date_to
+	if dateToStr, ok := params["date_to"].(string); ok && dateToStr != "" {
+		dateTo, err := time.Parse(time.RFC3339, dateToStr)
+		if err != nil {
+			return opts, fmt.Errorf("invalid date_to format: %w", err)
+		}
+		opts.DateTo = &dateTo
+	}
+
+	return opts, nil
+}
+
+// resolveFolders maps folder paths or roles to cached folder IDs, naming the
+// account in the error when a folder does not exist
+func resolveFolders(cacheStore *cache.Store, accountName string, accountID *int, names []string) ([]int, error) {
+	for i := range names {
+		names[i] = email.NormalizeFolderName(names[i])
+	}
+
+	folderIDs, err := cacheStore.ResolveFolderIDs(accountID, names)
+	if err != nil {
+		if accountName != "" {
+			return nil, fmt.Errorf("account %s: %w", accountName, err)
+		}
+		return nil, err
+	}
+
+	return folderIDs, nil
+}
+
+// parseStringList accepts a single string or an array of strings
+func parseStringList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
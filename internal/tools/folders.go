@@ -0,0 +1,337 @@
+package tools
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/internal/cache"
+	"github.com/brandon/mcp-email/internal/config"
+	"github.com/brandon/mcp-email/internal/email"
+)
+
+// CreateFolderTool creates a new folder
+type CreateFolderTool struct {
+	config       *config.Config
+	emailManager *email.Manager
+	cacheStore   *cache.Store
+	logger       *logrus.Logger
+}
+
+// NewCreateFolderTool creates a new create folder tool
+func NewCreateFolderTool(cfg *config.Config, emailManager *email.Manager, cacheStore *cache.Store, logger *logrus.Logger) *CreateFolderTool {
+	return &CreateFolderTool{
+		config:       cfg,
+		emailManager: emailManager,
+		cacheStore:   cacheStore,
+		logger:       logger,
+	}
+}
+
+// Name returns the tool name
+func (t *CreateFolderTool) Name() string {
+	return "create_folder"
+}
+
+// Description returns the tool description
+func (t *CreateFolderTool) Description() string {
+	return "Create a new mailbox/folder, optionally nested under a parent folder"
+}
+
+// InputSchema returns the JSON schema for tool inputs
+func (t *CreateFolderTool) InputSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"account_name": map[string]interface{}{
+				"type":        "string",
+				"description": "Account to create the folder in",
+			},
+			"name": map[string]interface{}{
+				"type":        "string",
+				"description": "Folder name (without hierarchy delimiter)",
+			},
+			"parent": map[string]interface{}{
+				"type":        "string",
+				"description": "Optional: Parent folder path, top level if omitted",
+			},
+		},
+		"required": []string{"account_name", "name"},
+	}
+}
+
+// Execute executes the tool
+func (t *CreateFolderTool) Execute(params map[string]interface{}) (interface{}, error) {
+	accountName, ok := params["account_name"].(string)
+	if !ok || accountName == "" {
+		return nil, fmt.Errorf("account_name is required")
+	}
+
+	name, ok := params["name"].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	parent, ok := params["parent"].(string)
+	if !ok {
+		parent = ""
+	}
+
+	path, err := t.emailManager.FolderPath(accountName, parent, name)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err = t.emailManager.CreateFolder(accountName, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create folder: %w", err)
+	}
+
+	return map[string]interface{}{
+		"success": true,
+		"path":    path,
+	}, nil
+}
+
+// RenameFolderTool renames or moves a folder
+type RenameFolderTool struct {
+	config       *config.Config
+	emailManager *email.Manager
+	cacheStore   *cache.Store
+	logger       *logrus.Logger
+}
+
+// NewRenameFolderTool creates a new rename folder tool
+func NewRenameFolderTool(cfg *config.Config, emailManager *email.Manager, cacheStore *cache.Store, logger *logrus.Logger) *RenameFolderTool {
+	return &RenameFolderTool{
+		config:       cfg,
+		emailManager: emailManager,
+		cacheStore:   cacheStore,
+		logger:       logger,
+	}
+}
+
+// Name returns the tool name
+func (t *RenameFolderTool) Name() string {
+	return "rename_folder"
+}
+
+// Description returns the tool description
+func (t *RenameFolderTool) Description() string {
+	return "Rename a folder, or move it under another parent; child folders follow"
+}
+
+// InputSchema returns the JSON schema for tool inputs
+func (t *RenameFolderTool) InputSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"account_name": map[string]interface{}{
+				"type":        "string",
+				"description": "Account owning the folder",
+			},
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "Current folder path",
+			},
+			"new_name": map[string]interface{}{
+				"type":        "string",
+				"description": "New folder name (without hierarchy delimiter)",
+			},
+			"new_parent": map[string]interface{}{
+				"type":        "string",
+				"description": "Optional: New parent folder path (empty string for top level), current parent if omitted",
+			},
+		},
+		"required": []string{"account_name", "path", "new_name"},
+	}
+}
+
+// Execute executes the tool
+func (t *RenameFolderTool) Execute(params map[string]interface{}) (interface{}, error) {
+	accountName, ok := params["account_name"].(string)
+	if !ok || accountName == "" {
+		return nil, fmt.Errorf("account_name is required")
+	}
+
+	path, ok := params["path"].(string)
+	if !ok || path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+
+	newName, ok := params["new_name"].(string)
+	if !ok || newName == "" {
+		return nil, fmt.Errorf("new_name is required")
+	}
+
+	// Keep the current parent unless a new one is given
+	parent, ok := params["new_parent"].(string)
+	if !ok {
+		var err error
+		parent, err = t.emailManager.ParentFolder(accountName, path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	newPath, err := t.emailManager.FolderPath(accountName, parent, newName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.emailManager.RenameFolder(accountName, path, newPath); err != nil {
+		return nil, fmt.Errorf("failed to rename folder: %w", err)
+	}
+
+	return map[string]interface{}{
+		"success":  true,
+		"old_path": path,
+		"path":     newPath,
+	}, nil
+}
+
+// DeleteFolderTool deletes a folder
+type DeleteFolderTool struct {
+	config       *config.Config
+	emailManager *email.Manager
+	cacheStore   *cache.Store
+	logger       *logrus.Logger
+}
+
+// NewDeleteFolderTool creates a new delete folder tool
+func NewDeleteFolderTool(cfg *config.Config, emailManager *email.Manager, cacheStore *cache.Store, logger *logrus.Logger) *DeleteFolderTool {
+	return &DeleteFolderTool{
+		config:       cfg,
+		emailManager: emailManager,
+		cacheStore:   cacheStore,
+		logger:       logger,
+	}
+}
+
+// Name returns the tool name
+func (t *DeleteFolderTool) Name() string {
+	return "delete_folder"
+}
+
+// Description returns the tool description
+func (t *DeleteFolderTool) Description() string {
+	return "Delete a folder and all messages in it"
+}
+
+// InputSchema returns the JSON schema for tool inputs
+func (t *DeleteFolderTool) InputSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"account_name": map[string]interface{}{
+				"type":        "string",
+				"description": "Account owning the folder",
+			},
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "Folder path",
+			},
+		},
+		"required": []string{"account_name", "path"},
+	}
+}
+
+// Execute executes the tool
+func (t *DeleteFolderTool) Execute(params map[string]interface{}) (interface{}, error) {
+	accountName, ok := params["account_name"].(string)
+	if !ok || accountName == "" {
+		return nil, fmt.Errorf("account_name is required")
+	}
+
+	path, ok := params["path"].(string)
+	if !ok || path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+
+	if err := t.emailManager.DeleteFolder(accountName, path); err != nil {
+		return nil, fmt.Errorf("failed to delete folder: %w", err)
+	}
+
+	return map[string]interface{}{
+		"success": true,
+		"path":    path,
+	}, nil
+}
+
+// SubscribeFolderTool subscribes to or unsubscribes from a folder
+type SubscribeFolderTool struct {
+	config       *config.Config
+	emailManager *email.Manager
+	cacheStore   *cache.Store
+	logger       *logrus.Logger
+}
+
+// NewSubscribeFolderTool creates a new subscribe folder tool
+func NewSubscribeFolderTool(cfg *config.Config, emailManager *email.Manager, cacheStore *cache.Store, logger *logrus.Logger) *SubscribeFolderTool {
+	return &SubscribeFolderTool{
+		config:       cfg,
+		emailManager: emailManager,
+		cacheStore:   cacheStore,
+		logger:       logger,
+	}
+}
+
+// Name returns the tool name
+func (t *SubscribeFolderTool) Name() string {
+	return "subscribe_folder"
+}
+
+// Description returns the tool description
+func (t *SubscribeFolderTool) Description() string {
+	return "Subscribe to or unsubscribe from a folder"
+}
+
+// InputSchema returns the JSON schema for tool inputs
+func (t *SubscribeFolderTool) InputSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"account_name": map[string]interface{}{
+				"type":        "string",
+				"description": "Account owning the folder",
+			},
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "Folder path",
+			},
+			"subscribed": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Optional: false to unsubscribe (default: true)",
+			},
+		},
+		"required": []string{"account_name", "path"},
+	}
+}
+
+// Execute executes the tool
+func (t *SubscribeFolderTool) Execute(params map[string]interface{}) (interface{}, error) {
+	accountName, ok := params["account_name"].(string)
+	if !ok || accountName == "" {
+		return nil, fmt.Errorf("account_name is required")
+	}
+
+	path, ok := params["path"].(string)
+	if !ok || path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+
+	subscribed := true
+	if s, ok := params["subscribed"].(bool); ok {
+		subscribed = s
+	}
+
+	if err := t.emailManager.SubscribeFolder(accountName, path, subscribed); err != nil {
+		return nil, fmt.Errorf("failed to update subscription: %w", err)
+	}
+
+	return map[string]interface{}{
+		"success":    true,
+		"path":       path,
+		"subscribed": subscribed,
+	}, nil
+}
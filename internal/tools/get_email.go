@@ -53,6 +53,12 @@ func (t *GetEmailTool) InputSchema() map[string]interface{} {
 				"type":        "string",
 				"description": "Optional: Account name if needed",
 			},
+			"headers": map[string]interface{}{
+				"type":  []string{"boolean", "array"},
+				"items": map[string]interface{}{"type": "string"},
+				"description": "Optional: true to include all message headers, or a list of header names " +
+					"(e.g. [\"Received\", \"DKIM-Signature\"]) to include only those",
+			},
 		},
 		"required": []string{"email_id"},
 	}
@@ -74,15 +80,26 @@ func (t *GetEmailTool) Execute(params map[string]interface{}) (interface{}, erro
 		return nil, fmt.Errorf("email_id is required")
 	}
 
+	// Parse headers: true for all, or a list of names
+	allHeaders := false
+	var headerNames []string
+	if v, ok := params["headers"].(bool); ok {
+		allHeaders = v
+	} else {
+		headerNames = parseStringList(params["headers"])
+	}
+	wantHeaders := allHeaders || len(headerNames) > 0
+
 	// Get email from cache
 	cachedEmail, err := t.cacheStore.GetEmail(emailID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get email: %w", err)
 	}
 
-	// If body is empty, try to re-fetch from IMAP
-	if cachedEmail.BodyText == "" && cachedEmail.BodyHTML == "" {
-		t.logger.WithField("email_id", emailID).Info("Email body is empty, re-fetching from IMAP")
+	// If body is empty, or headers are wanted but were cached before they
+	// were stored, try to re-fetch from IMAP
+	if (cachedEmail.BodyText == "" && cachedEmail.BodyHTML == "") || (wantHeaders && len(cachedEmail.Headers) == 0) {
+		t.logger.WithField("email_id", emailID).Info("Email content is missing, re-fetching from IMAP")
 
 		// Get account config
 		account, err := t.config.GetAccountByName(cachedEmail.AccountName)
@@ -97,7 +114,7 @@ func (t *GetEmailTool) Execute(params map[string]interface{}) (interface{}, erro
 				imapClient.SetLogger(t.logger)
 
 				// Fetch the specific email
-				emails, err := imapClient.FetchEmails(cachedEmail.FolderPath, cachedEmail.UID, cachedEmail.UID)
+				emails, err := imapClient.FetchEmailsByUID(cachedEmail.FolderPath, []uint32{cachedEmail.UID})
 				if err != nil {
 					t.logger.WithError(err).Warn("Could not re-fetch email from IMAP")
 				} else if len(emails) > 0 {
@@ -105,6 +122,13 @@ func (t *GetEmailTool) Execute(params map[string]interface{}) (interface{}, erro
 					cachedEmail.BodyText = emails[0].BodyText
 					cachedEmail.BodyHTML = emails[0].BodyHTML
 					cachedEmail.Headers = emails[0].Headers
+					cachedEmail.InReplyTo = emails[0].InReplyTo
+					cachedEmail.References = emails[0].References
+					cachedEmail.ListID = emails[0].ListID
+					cachedEmail.ReplyTo = emails[0].ReplyTo
+					cachedEmail.ReturnPath = emails[0].ReturnPath
+					cachedEmail.XMailer = emails[0].XMailer
+					cachedEmail.BodyEvicted = false
 
 					// Update cache using UpsertEmail
 					if err := t.cacheStore.UpsertEmail(cachedEmail); err != nil {
@@ -125,6 +149,7 @@ func (t *GetEmailTool) Execute(params map[string]interface{}) (interface{}, erro
 		"folder_id":    cachedEmail.FolderID,
 		"folder_path":  cachedEmail.FolderPath,
 		"uid":          cachedEmail.UID,
+		"folders":      cachedEmail.FolderPaths(),
 		"message_id":   cachedEmail.MessageID,
 		"subject":      cachedEmail.Subject,
 		"sender_name":  cachedEmail.SenderName,
@@ -133,10 +158,44 @@ func (t *GetEmailTool) Execute(params map[string]interface{}) (interface{}, erro
 		"date":         cachedEmail.Date.Format(time.RFC3339),
 		"body_text":    cachedEmail.BodyText,
 		"body_html":    cachedEmail.BodyHTML,
-		"headers":      cachedEmail.Headers,
 		"flags":        cachedEmail.Flags,
 		"cached_at":    cachedEmail.CachedAt.Format(time.RFC3339),
 	}
+	if cachedEmail.ThreadID != 0 {
+		result["thread_id"] = cachedEmail.ThreadID
+	}
+	// The body was dropped by cache retention and could not be re-fetched
+	if cachedEmail.BodyEvicted {
+		result["body_evicted"] = true
+	}
+
+	// Indexed headers are always included when present
+	for key, value := range map[string]string{
+		"in_reply_to": cachedEmail.InReplyTo,
+		"list_id":     cachedEmail.ListID,
+		"reply_to":    cachedEmail.ReplyTo,
+		"return_path": cachedEmail.ReturnPath,
+		"x_mailer":    cachedEmail.XMailer,
+	} {
+		if value != "" {
+			result[key] = value
+		}
+	}
+	if len(cachedEmail.References) > 0 {
+		result["references"] = cachedEmail.References
+	}
+
+	if allHeaders {
+		result["headers"] = cachedEmail.Headers
+	} else if len(headerNames) > 0 {
+		headers := make(map[string][]string)
+		for _, name := range headerNames {
+			if values := cachedEmail.HeaderValues(name); values != nil {
+				headers[name] = values
+			}
+		}
+		result["headers"] = headers
+	}
 
 	return result, nil
 }
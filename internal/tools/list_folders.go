@@ -8,6 +8,7 @@ import (
 	"github.com/brandon/mcp-email/internal/cache"
 	"github.com/brandon/mcp-email/internal/config"
 	"github.com/brandon/mcp-email/internal/email"
+	"github.com/brandon/mcp-email/pkg/types"
 )
 
 // ListFoldersTool lists available email folders
@@ -35,7 +36,7 @@ func (t *ListFoldersTool) Name() string {
 
 // Description returns the tool description
 func (t *ListFoldersTool) Description() string {
-	return "List available mailboxes/folders for configured email accounts"
+	return "List available mailboxes/folders with hierarchy, special-use roles and message counts"
 }
 
 // InputSchema returns the JSON schema for tool inputs
@@ -47,49 +48,156 @@ func (t *ListFoldersTool) InputSchema() map[string]interface{} {
 				"type":        "string",
 				"description": "Optional: Specific account name, or all accounts if omitted",
 			},
+			"refresh": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Optional: Re-list folders and counts from the server instead of the cache (default: false)",
+			},
+			"tree": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Optional: Return folders nested under their parents (default: false)",
+			},
 		},
 	}
 }
 
 // Execute executes the tool
 func (t *ListFoldersTool) Execute(params map[string]interface{}) (interface{}, error) {
-	var accountID *int
-
+	accountNames := t.config.AccountNames()
 	if accountName, ok := params["account_name"].(string); ok && accountName != "" {
-		id, err := t.cacheStore.GetAccountID(accountName)
+		if _, err := t.config.GetAccountByName(accountName); err != nil {
+			return nil, err
+		}
+		accountNames = []string{accountName}
+	}
+
+	refresh := false
+	if r, ok := params["refresh"].(bool); ok {
+		refresh = r
+	}
+
+	tree := false
+	if tr, ok := params["tree"].(bool); ok {
+		tree = tr
+	}
+
+	var folders []types.Folder
+	for _, accountName := range accountNames {
+		accountFolders, err := t.accountFolders(accountName, refresh)
 		if err != nil {
-			// Account might not be in cache yet, try to sync
-			if syncErr := t.emailManager.SyncAccount(accountName, ""); syncErr != nil {
-				return nil, fmt.Errorf("failed to sync account: %w", syncErr)
-			}
-			id, err = t.cacheStore.GetAccountID(accountName)
+			return nil, err
+		}
+		folders = append(folders, accountFolders...)
+	}
+
+	if tree {
+		return buildFolderTree(folders), nil
+	}
+
+	// Convert to JSON-serializable format
+	result := make([]map[string]interface{}, len(folders))
+	for i := range folders {
+		result[i] = folderToMap(&folders[i])
+	}
+
+	return result, nil
+}
+
+// accountFolders returns the cached folders of an account, listing them from
+// the server first when refresh is requested or nothing is cached yet
+func (t *ListFoldersTool) accountFolders(accountName string, refresh bool) ([]types.Folder, error) {
+	if !refresh {
+		if accountID, err := t.cacheStore.GetAccountID(accountName); err == nil {
+			folders, err := t.cacheStore.ListFolders(&accountID)
 			if err != nil {
-				return nil, fmt.Errorf("account not found: %s", accountName)
+				return nil, fmt.Errorf("failed to list folders: %w", err)
+			}
+			if len(folders) > 0 {
+				return folders, nil
 			}
 		}
-		accountID = &id
 	}
 
-	folders, err := t.cacheStore.ListFolders(accountID)
+	if _, err := t.emailManager.RefreshFolders(accountName); err != nil {
+		return nil, fmt.Errorf("failed to refresh folders for %s: %w", accountName, err)
+	}
+
+	accountID, err := t.cacheStore.GetAccountID(accountName)
+	if err != nil {
+		return nil, err
+	}
+
+	folders, err := t.cacheStore.ListFolders(&accountID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list folders: %w", err)
 	}
 
-	// Convert to JSON-serializable format
-	result := make([]map[string]interface{}, len(folders))
-	for i, folder := range folders {
-		result[i] = map[string]interface{}{
-			"id":            folder.ID,
-			"account_id":    folder.AccountID,
-			"account_name":  folder.AccountName,
-			"name":          folder.Name,
-			"path":          folder.Path,
-			"message_count": folder.MessageCount,
+	return folders, nil
+}
+
+// folderToMap converts a folder to its JSON-serializable form
+func folderToMap(folder *types.Folder) map[string]interface{} {
+	attributes := folder.Attributes
+	if attributes == nil {
+		attributes = []string{}
+	}
+
+	result := map[string]interface{}{
+		"id":            folder.ID,
+		"account_id":    folder.AccountID,
+		"account_name":  folder.AccountName,
+		"name":          folder.Name,
+		"path":          folder.Path,
+		"parent_path":   folder.ParentPath,
+		"delimiter":     folder.Delimiter,
+		"attributes":    attributes,
+		"special_use":   folder.SpecialUse,
+		"selectable":    folder.Selectable(),
+		"has_children":  folder.HasAttribute("\\HasChildren"),
+		"message_count": folder.MessageCount,
+		"unseen_count":  folder.UnseenCount,
+		"recent_count":  folder.RecentCount,
+	}
+	if folder.LastSynced != nil {
+		result["last_synced"] = folder.LastSynced.Format("2006-01-02T15:04:05Z")
+	}
+
+	return result
+}
+
+// buildFolderTree nests folders under their parents, per account. Folders
+// whose parent is not listed (e.g. hidden namespaces) become roots.
+func buildFolderTree(folders []types.Folder) []map[string]interface{} {
+	index := make(map[string]int, len(folders))
+	for i := range folders {
+		index[folders[i].AccountName+"\x00"+folders[i].Path] = i
+	}
+
+	var roots []int
+	children := make(map[int][]int)
+	for i := range folders {
+		parentIdx, ok := index[folders[i].AccountName+"\x00"+folders[i].ParentPath]
+		if folders[i].ParentPath == "" || !ok {
+			roots = append(roots, i)
+			continue
 		}
-		if folder.LastSynced != nil {
-			result[i]["last_synced"] = folder.LastSynced.Format("2006-01-02T15:04:05Z")
+		children[parentIdx] = append(children[parentIdx], i)
+	}
+
+	var build func(i int) map[string]interface{}
+	build = func(i int) map[string]interface{} {
+		node := folderToMap(&folders[i])
+		nested := make([]map[string]interface{}, 0, len(children[i]))
+		for _, child := range children[i] {
+			nested = append(nested, build(child))
 		}
+		node["children"] = nested
+		return node
 	}
 
-	return result, nil
+	result := make([]map[string]interface{}, 0, len(roots))
+	for _, root := range roots {
+		result = append(result, build(root))
+	}
+
+	return result
 }
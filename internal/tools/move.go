@@ -0,0 +1,236 @@
+package tools
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/internal/cache"
+	"github.com/brandon/mcp-email/internal/config"
+	"github.com/brandon/mcp-email/internal/email"
+)
+
+// emailIDsSchema describes the email_ids argument shared by message tools
+var emailIDsSchema = map[string]interface{}{
+	"type":        "array",
+	"items":       map[string]interface{}{"type": "integer"},
+	"description": "Email IDs (from search results)",
+}
+
+// MoveEmailsTool moves emails to another folder
+type MoveEmailsTool struct {
+	config       *config.Config
+	emailManager *email.Manager
+	cacheStore   *cache.Store
+	logger       *logrus.Logger
+}
+
+// NewMoveEmailsTool creates a new move emails tool
+func NewMoveEmailsTool(cfg *config.Config, emailManager *email.Manager, cacheStore *cache.Store, logger *logrus.Logger) *MoveEmailsTool {
+	return &MoveEmailsTool{
+		config:       cfg,
+		emailManager: emailManager,
+		cacheStore:   cacheStore,
+		logger:       logger,
+	}
+}
+
+// Name returns the tool name
+func (t *MoveEmailsTool) Name() string {
+	return "move_emails"
+}
+
+// Description returns the tool description
+func (t *MoveEmailsTool) Description() string {
+	return "Move emails to another folder of the same account"
+}
+
+// InputSchema returns the JSON schema for tool inputs
+func (t *MoveEmailsTool) InputSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"email_ids": emailIDsSchema,
+			"destination": map[string]interface{}{
+				"type":        "string",
+				"description": "Destination folder path",
+			},
+		},
+		"required": []string{"email_ids", "destination"},
+	}
+}
+
+// Execute executes the tool
+func (t *MoveEmailsTool) Execute(params map[string]interface{}) (interface{}, error) {
+	emailIDs, err := parseEmailIDs(params)
+	if err != nil {
+		return nil, err
+	}
+
+	dest, ok := params["destination"].(string)
+	if !ok || dest == "" {
+		return nil, fmt.Errorf("destination is required")
+	}
+
+	moved, err := t.emailManager.MoveEmails(emailIDs, dest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move emails (%d moved): %w", moved, err)
+	}
+
+	return map[string]interface{}{
+		"success":     true,
+		"moved":       moved,
+		"destination": dest,
+	}, nil
+}
+
+// CopyEmailsTool copies emails to another folder
+type CopyEmailsTool struct {
+	config       *config.Config
+	emailManager *email.Manager
+	cacheStore   *cache.Store
+	logger       *logrus.Logger
+}
+
+// NewCopyEmailsTool creates a new copy emails tool
+func NewCopyEmailsTool(cfg *config.Config, emailManager *email.Manager, cacheStore *cache.Store, logger *logrus.Logger) *CopyEmailsTool {
+	return &CopyEmailsTool{
+		config:       cfg,
+		emailManager: emailManager,
+		cacheStore:   cacheStore,
+		logger:       logger,
+	}
+}
+
+// Name returns the tool name
+func (t *CopyEmailsTool) Name() string {
+	return "copy_emails"
+}
+
+// Description returns the tool description
+func (t *CopyEmailsTool) Description() string {
+	return "Copy emails to another folder of the same account"
+}
+
+// InputSchema returns the JSON schema for tool inputs
+func (t *CopyEmailsTool) InputSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"email_ids": emailIDsSchema,
+			"destination": map[string]interface{}{
+				"type":        "string",
+				"description": "Destination folder path",
+			},
+		},
+		"required": []string{"email_ids", "destination"},
+	}
+}
+
+// Execute executes the tool
+func (t *CopyEmailsTool) Execute(params map[string]interface{}) (interface{}, error) {
+	emailIDs, err := parseEmailIDs(params)
+	if err != nil {
+		return nil, err
+	}
+
+	dest, ok := params["destination"].(string)
+	if !ok || dest == "" {
+		return nil, fmt.Errorf("destination is required")
+	}
+
+	copied, err := t.emailManager.CopyEmails(emailIDs, dest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy emails (%d copied): %w", copied, err)
+	}
+
+	return map[string]interface{}{
+		"success":     true,
+		"copied":      copied,
+		"destination": dest,
+	}, nil
+}
+
+// ArchiveEmailsTool moves emails to the archive folder
+type ArchiveEmailsTool struct {
+	config       *config.Config
+	emailManager *email.Manager
+	cacheStore   *cache.Store
+	logger       *logrus.Logger
+}
+
+// NewArchiveEmailsTool creates a new archive emails tool
+func NewArchiveEmailsTool(cfg *config.Config, emailManager *email.Manager, cacheStore *cache.Store, logger *logrus.Logger) *ArchiveEmailsTool {
+	return &ArchiveEmailsTool{
+		config:       cfg,
+		emailManager: emailManager,
+		cacheStore:   cacheStore,
+		logger:       logger,
+	}
+}
+
+// Name returns the tool name
+func (t *ArchiveEmailsTool) Name() string {
+	return "archive_emails"
+}
+
+// Description returns the tool description
+func (t *ArchiveEmailsTool) Description() string {
+	return "Move emails to the account's archive folder (\\Archive, or \\All on Gmail)"
+}
+
+// InputSchema returns the JSON schema for tool inputs
+func (t *ArchiveEmailsTool) InputSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"email_ids": emailIDsSchema,
+		},
+		"required": []string{"email_ids"},
+	}
+}
+
+// Execute executes the tool
+func (t *ArchiveEmailsTool) Execute(params map[string]interface{}) (interface{}, error) {
+	emailIDs, err := parseEmailIDs(params)
+	if err != nil {
+		return nil, err
+	}
+
+	archived, err := t.emailManager.ArchiveEmails(emailIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to archive emails (%d archived): %w", archived, err)
+	}
+
+	return map[string]interface{}{
+		"success":  true,
+		"archived": archived,
+	}, nil
+}
+
+// parseEmailIDs parses the email_ids argument, accepting numbers or numeric strings
+func parseEmailIDs(params map[string]interface{}) ([]int64, error) {
+	rawIDs, ok := params["email_ids"].([]interface{})
+	if !ok || len(rawIDs) == 0 {
+		return nil, fmt.Errorf("email_ids is required")
+	}
+
+	emailIDs := make([]int64, 0, len(rawIDs))
+	for _, raw := range rawIDs {
+		switch v := raw.(type) {
+		case float64:
+			emailIDs = append(emailIDs, int64(v))
+		case string:
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid email_id %q: %w", v, err)
+			}
+			emailIDs = append(emailIDs, id)
+		default:
+			return nil, fmt.Errorf("invalid email_id: %v", raw)
+		}
+	}
+
+	return emailIDs, nil
+}
@@ -47,8 +47,19 @@ func (r *Registry) registerTools() {
 	toolList := []Tool{
 		NewListFoldersTool(r.config, r.emailManager, r.cacheStore, r.logger),
 		NewSearchEmailsTool(r.config, r.emailManager, r.cacheStore, r.logger),
+		NewMailboxStatsTool(r.config, r.emailManager, r.cacheStore, r.logger),
+		NewCacheStatsTool(r.config, r.emailManager, r.cacheStore, r.logger),
 		NewGetEmailTool(r.config, r.emailManager, r.cacheStore, r.logger),
+		NewGetThreadTool(r.config, r.emailManager, r.cacheStore, r.logger),
 		NewSendEmailTool(r.config, r.emailManager, r.cacheStore, r.logger),
+		NewMoveEmailsTool(r.config, r.emailManager, r.cacheStore, r.logger),
+		NewCopyEmailsTool(r.config, r.emailManager, r.cacheStore, r.logger),
+		NewArchiveEmailsTool(r.config, r.emailManager, r.cacheStore, r.logger),
+		NewDeleteEmailsTool(r.config, r.emailManager, r.cacheStore, r.logger),
+		NewCreateFolderTool(r.config, r.emailManager, r.cacheStore, r.logger),
+		NewRenameFolderTool(r.config, r.emailManager, r.cacheStore, r.logger),
+		NewDeleteFolderTool(r.config, r.emailManager, r.cacheStore, r.logger),
+		NewSubscribeFolderTool(r.config, r.emailManager, r.cacheStore, r.logger),
 	}
 
 	for _, tool := range toolList {
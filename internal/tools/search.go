@@ -12,6 +12,13 @@ import (
 	"github.com/brandon/mcp-email/internal/email"
 )
 
+// Search scopes accepted by search_emails
+const (
+	scopeCache  = "cache"
+	scopeServer = "server"
+	scopeBoth   = "both"
+)
+
 // SearchEmailsTool searches cached emails
 type SearchEmailsTool struct {
 	config       *config.Config
@@ -37,45 +44,29 @@ func (t *SearchEmailsTool) Name() string {
 
 // Description returns the tool description
 func (t *SearchEmailsTool) Description() string {
-	return "Search cached emails with flexible filters (sender, recipient, subject, body, date range)"
+	return "Search cached emails with a Gmail-style query or flexible filters (sender, recipient, subject, body, date range)"
 }
 
 // InputSchema returns the JSON schema for tool inputs
 func (t *SearchEmailsTool) InputSchema() map[string]interface{} {
 	return map[string]interface{}{
 		"type": "object",
-		"properties": map[string]interface{}{
-			"account_name": map[string]interface{}{
-				"type":        "string",
-				"description": "Optional: Filter by specific account",
+		"properties": withSearchFilters(map[string]interface{}{
+			"order_by": map[string]interface{}{
+				"type": "string",
+				"enum": []string{cache.OrderRelevance, cache.OrderDate},
+				"description": "Optional: Result order, relevance (bm25, full-text searches only) or date " +
+					"(default: relevance when searching text, otherwise date)",
 			},
-			"folder": map[string]interface{}{
-				"type":        "string",
-				"description": "Optional: Filter by folder/mailbox",
+			"search_scope": map[string]interface{}{
+				"type": "string",
+				"enum": []string{scopeCache, scopeServer, scopeBoth},
+				"description": "Optional: cache searches cached mail only; server runs IMAP SEARCH in each folder, " +
+					"caches matches and returns only those; both adds server matches to cached results (default: cache)",
 			},
-			"sender": map[string]interface{}{
+			"cursor": map[string]interface{}{
 				"type":        "string",
-				"description": "Optional: Filter by sender email/name",
-			},
-			"recipient": map[string]interface{}{
-				"type":        "string",
-				"description": "Optional: Filter by recipient email",
-			},
-			"subject": map[string]interface{}{
-				"type":        "string",
-				"description": "Optional: Filter by subject (substring match)",
-			},
-			"body": map[string]interface{}{
-				"type":        "string",
-				"description": "Optional: Filter by body content (full-text search)",
-			},
-			"date_from": map[string]interface{}{
-				"type":        "string",
-				"description": "Optional: Start date (ISO 8601 format)",
-			},
-			"date_to": map[string]interface{}{
-				"type":        "string",
-				"description": "Optional: End date (ISO 8601 format)",
+				"description": "Optional: next_cursor from a previous response to fetch the following page (use the same filters). Pages in date order are exact; in relevance order a sync between pages may repeat or skip a result",
 			},
 			"limit": map[string]interface{}{
 				"type":        "integer",
@@ -83,95 +74,28 @@ func (t *SearchEmailsTool) InputSchema() map[string]interface{} {
 				"minimum":     1,
 				"maximum":     1000,
 			},
-		},
+		}),
 	}
 }
 
 // Execute executes the tool
 func (t *SearchEmailsTool) Execute(params map[string]interface{}) (interface{}, error) {
-	opts := cache.SearchOptions{}
-
-	// Parse account_name
-	if accountName, ok := params["account_name"].(string); ok && accountName != "" {
-		accountID, err := t.cacheStore.GetAccountID(accountName)
-		if err != nil {
-			// Account might not be in cache yet, try to sync
-			if syncErr := t.emailManager.SyncAccount(accountName, ""); syncErr != nil {
-				return nil, fmt.Errorf("failed to sync account: %w", syncErr)
-			}
-			accountID, err = t.cacheStore.GetAccountID(accountName)
-			if err != nil {
-				return nil, fmt.Errorf("account not found: %s", accountName)
-			}
-		}
-		opts.AccountID = &accountID
-
-		// Check if account has cached emails, if not, sync
-		hasEmails, err := t.cacheStore.HasEmails(accountID)
-		if err != nil {
-			t.logger.WithError(err).Warn("Failed to check if account has emails")
-		} else if !hasEmails {
-			t.logger.WithField("account", accountName).Info("No cached emails found, syncing account")
-			if err := t.emailManager.SyncAccount(accountName, ""); err != nil {
-				t.logger.WithError(err).WithField("account", accountName).Warn("Failed to sync account for search")
-				// Continue with search even if sync fails
-			}
-		}
-	} else {
-		// No account specified, check if any emails are cached
-		hasAnyEmails, err := t.cacheStore.HasAnyEmails()
-		if err != nil {
-			t.logger.WithError(err).Warn("Failed to check if any emails are cached")
-		} else if !hasAnyEmails {
-			// Sync all accounts
-			t.logger.Info("No cached emails found, syncing all accounts")
-			for _, accountName := range t.config.AccountNames() {
-				if err := t.emailManager.SyncAccount(accountName, ""); err != nil {
-					t.logger.WithError(err).WithField("account", accountName).Warn("Failed to sync account")
-				}
-			}
-		}
-	}
-
-	// Parse folder (would need folder ID lookup, simplified for now)
-	// TODO: Implement folder name to ID lookup
-
-	// Parse sender
-	if sender, ok := params["sender"].(string); ok && sender != "" {
-		opts.Sender = &sender
-	}
-
-	// Parse recipient
-	if recipient, ok := params["recipient"].(string); ok && recipient != "" {
-		opts.Recipient = &recipient
-	}
-
-	// Parse subject
-	if subject, ok := params["subject"].(string); ok && subject != "" {
-		opts.Subject = &subject
-	}
-
-	// Parse body (full-text search)
-	if body, ok := params["body"].(string); ok && body != "" {
-		opts.Body = &body
+	opts, err := parseSearchFilters(params, t.config, t.emailManager, t.cacheStore, t.logger)
+	if err != nil {
+		return nil, err
 	}
 
-	// Parse date_from
-	if dateFromStr, ok := params["date_from"].(string); ok && dateFromStr != "" {
-		dateFrom, err := time.Parse(time.RFC3339, dateFromStr)
-		if err != nil {
-			return nil, fmt.Errorf("invalid date_from format: %w", err)
+	// Parse order_by
+	if orderBy, ok := params["order_by"].(string); ok && orderBy != "" {
+		if orderBy != cache.OrderRelevance && orderBy != cache.OrderDate {
+			return nil, fmt.Errorf("invalid order_by: %s (expected %s or %s)", orderBy, cache.OrderRelevance, cache.OrderDate)
 		}
-		opts.DateFrom = &dateFrom
+		opts.OrderBy = orderBy
 	}
 
-	// Parse date_to
-	if dateToStr, ok := params["date_to"].(string); ok && dateToStr != "" {
-		dateTo, err := time.Parse(time.RFC3339, dateToStr)
-		if err != nil {
-			return nil, fmt.Errorf("invalid date_to format: %w", err)
-		}
-		opts.DateTo = &dateTo
+	// Parse cursor
+	if cursor, ok := params["cursor"].(string); ok && cursor != "" {
+		opts.Cursor = cursor
 	}
 
 	// Parse limit
@@ -186,26 +110,70 @@ func (t *SearchEmailsTool) Execute(params map[string]interface{}) (interface{},
 		opts.Limit = t.config.SearchResultLimit
 	}
 
+	// Parse search_scope and search the server first, so matches are cached
+	scope, ok := params["search_scope"].(string)
+	if !ok || scope == "" {
+		scope = scopeCache
+	}
+	switch scope {
+	case scopeCache:
+	case scopeServer, scopeBoth:
+		accountNames := t.config.AccountNames()
+		if name, ok := params["account_name"].(string); ok && name != "" {
+			accountNames = []string{name}
+		}
+
+		ids, err := t.emailManager.SearchServer(accountNames, opts, opts.Limit)
+		if err != nil {
+			return nil, err
+		}
+		if scope == scopeServer {
+			opts.EmailIDs = append([]int64{}, ids...)
+		}
+	default:
+		return nil, fmt.Errorf("invalid search_scope: %s (expected %s, %s or %s)", scope, scopeCache, scopeServer, scopeBoth)
+	}
+
 	// Perform search
-	results, err := t.cacheStore.Search(opts)
+	result, err := t.cacheStore.Search(opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search emails: %w", err)
 	}
 
 	// Convert to JSON-serializable format
-	emailList := make([]map[string]interface{}, len(results))
-	for i, email := range results {
+	emailList := make([]map[string]interface{}, len(result.Emails))
+	for i, email := range result.Emails {
 		emailList[i] = map[string]interface{}{
 			"id":           email.ID,
 			"account_name": email.AccountName,
 			"folder_path":  email.FolderPath,
+			"folders":      email.Folders,
 			"subject":      email.Subject,
 			"sender_name":  email.SenderName,
 			"sender_email": email.SenderEmail,
 			"date":         email.Date.Format(time.RFC3339),
 			"snippet":      email.Snippet,
 		}
+		if email.ThreadID != 0 {
+			emailList[i]["thread_id"] = email.ThreadID
+		}
+		if email.HighlightedSubject != "" {
+			emailList[i]["highlighted_subject"] = email.HighlightedSubject
+		}
+		if email.Score != 0 {
+			emailList[i]["score"] = email.Score
+		}
+	}
+
+	response := map[string]interface{}{
+		"emails":      emailList,
+		"count":       len(emailList),
+		"total":       result.Total,
+		"total_exact": result.TotalExact,
+	}
+	if result.NextCursor != "" {
+		response["next_cursor"] = result.NextCursor
 	}
 
-	return emailList, nil
+	return response, nil
 }
@@ -36,7 +36,7 @@ func (t *SendEmailTool) Name() string {
 
 // Description returns the tool description
 func (t *SendEmailTool) Description() string {
-	return "Send a new email with support for text, HTML, attachments, CC, BCC"
+	return "Send a new email with support for text, HTML, attachments, CC, BCC, and report which recipients the server accepted"
 }
 
 // InputSchema returns the JSON schema for tool inputs
@@ -48,6 +48,10 @@ func (t *SendEmailTool) InputSchema() map[string]interface{} {
 				"type":        "string",
 				"description": "Account to send from",
 			},
+			"from": map[string]interface{}{
+				"type":        "string",
+				"description": "Optional: Identity to send as, one of the account's identity addresses, optionally with a display name (\"Ann Lee <ann@example.com>\"); default is the account's first identity",
+			},
 			"to": map[string]interface{}{
 				"type":        "string",
 				"description": "Recipient email address(es) (comma-separated)",
@@ -85,6 +89,28 @@ func (t *SendEmailTool) InputSchema() map[string]interface{} {
 				"type":        "string",
 				"description": "Optional: In-Reply-To header (for replies)",
 			},
+			"signature": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Optional: Append the identity's signature (default: true)",
+			},
+			"partial_delivery": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{config.PartialDeliveryReject, config.PartialDeliveryAccept},
+				"description": "Optional: When the server rejects some recipients, reject sends to nobody and accept sends to the rest (default: the account's setting)",
+			},
+			"dsn_notify": map[string]interface{}{
+				"type":        "string",
+				"description": "Optional: Request delivery status notifications on success, failure and/or delay (comma-separated), or never. Ignored with a warning if the server does not support DSN",
+			},
+			"dsn_return": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{config.DSNReturnFull, config.DSNReturnHeaders},
+				"description": "Optional: Whether a failure notice includes the full message or only its headers",
+			},
+			"dsn_envelope_id": map[string]interface{}{
+				"type":        "string",
+				"description": "Optional: ID quoted in delivery status notifications; generated if omitted",
+			},
 		},
 		"required": []string{"account_name", "to", "subject"},
 	}
@@ -163,13 +189,61 @@ func (t *SendEmailTool) Execute(params map[string]interface{}) (interface{}, err
 		msg.InReplyTo = inReplyTo
 	}
 
+	// Parse from (optional); the account checks it against its identities
+	if from, ok := params["from"].(string); ok {
+		msg.From = strings.TrimSpace(from)
+	}
+
+	// Parse signature (optional)
+	if signature, ok := params["signature"].(bool); ok {
+		msg.OmitSignature = !signature
+	}
+
+	// Parse partial_delivery (optional)
+	if partial, ok := params["partial_delivery"].(string); ok && partial != "" {
+		msg.PartialDelivery = strings.ToLower(partial)
+	}
+
+	// Parse dsn_* (optional); any of them replaces the account's DSN default
+	dsn := &email.DSNRequest{}
+	if notify, ok := params["dsn_notify"].(string); ok && notify != "" {
+		dsn.Notify = config.SplitList(strings.ToLower(notify))
+	}
+	if ret, ok := params["dsn_return"].(string); ok {
+		dsn.Return = strings.ToLower(ret)
+	}
+	if envelopeID, ok := params["dsn_envelope_id"].(string); ok {
+		dsn.EnvelopeID = envelopeID
+	}
+	if len(dsn.Notify) > 0 || dsn.Return != "" || dsn.EnvelopeID != "" {
+		if len(dsn.Notify) == 0 {
+			return nil, fmt.Errorf("dsn_notify is required with dsn_return or dsn_envelope_id")
+		}
+		msg.DSN = dsn
+	}
+
 	// Send email
-	if err := t.emailManager.SendEmail(accountName, msg); err != nil {
-		return nil, fmt.Errorf("failed to send email: %w", err)
+	result, err := t.emailManager.SendEmail(accountName, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	total, rejected := len(result.Recipients), len(result.Rejected())
+	var message string
+	switch {
+	case result.Sent && rejected == 0:
+		message = "Email sent successfully"
+	case result.Sent:
+		message = fmt.Sprintf("Email sent to %d of %d recipients; the others were rejected", total-rejected, total)
+	case rejected < total:
+		message = fmt.Sprintf("Email not sent: %d of %d recipients were rejected (partial_delivery accept sends to the rest)", rejected, total)
+	default:
+		message = "Email not sent: all recipients were rejected"
 	}
 
 	return map[string]interface{}{
-		"success": true,
-		"message": "Email sent successfully",
+		"success":  result.Sent,
+		"message":  message,
+		"delivery": result,
 	}, nil
 }
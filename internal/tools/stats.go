@@ -0,0 +1,88 @@
+package tools
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/internal/cache"
+	"github.com/brandon/mcp-email/internal/config"
+	"github.com/brandon/mcp-email/internal/email"
+)
+
+// MailboxStatsTool aggregates cached emails into facets and histograms
+type MailboxStatsTool struct {
+	config       *config.Config
+	emailManager *email.Manager
+	cacheStore   *cache.Store
+	logger       *logrus.Logger
+}
+
+// NewMailboxStatsTool creates a new mailbox stats tool
+func NewMailboxStatsTool(cfg *config.Config, emailManager *email.Manager, cacheStore *cache.Store, logger *logrus.Logger) *MailboxStatsTool {
+	return &MailboxStatsTool{
+		config:       cfg,
+		emailManager: emailManager,
+		cacheStore:   cacheStore,
+		logger:       logger,
+	}
+}
+
+// Name returns the tool name
+func (t *MailboxStatsTool) Name() string {
+	return "mailbox_stats"
+}
+
+// Description returns the tool description
+func (t *MailboxStatsTool) Description() string {
+	return "Summarize cached emails matching any search_emails filter: totals, unread and attachment counts, " +
+		"top senders and domains, per-folder counts and a per-day/week/month/year histogram"
+}
+
+// InputSchema returns the JSON schema for tool inputs
+func (t *MailboxStatsTool) InputSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": withSearchFilters(map[string]interface{}{
+			"interval": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{cache.IntervalDay, cache.IntervalWeek, cache.IntervalMonth, cache.IntervalYear},
+				"description": "Optional: Histogram bucket size (default: month)",
+			},
+			"top": map[string]interface{}{
+				"type":        "integer",
+				"description": "Optional: Number of top senders and domains to return (default: 10, max: 100)",
+				"minimum":     1,
+				"maximum":     100,
+			},
+		}),
+	}
+}
+
+// Execute executes the tool
+func (t *MailboxStatsTool) Execute(params map[string]interface{}) (interface{}, error) {
+	opts, err := parseSearchFilters(params, t.config, t.emailManager, t.cacheStore, t.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := cache.IntervalMonth
+	if i, ok := params["interval"].(string); ok && i != "" {
+		interval = i
+	}
+
+	top := 10
+	if n, ok := params["top"].(float64); ok && n > 0 {
+		top = int(n)
+	}
+	if top > 100 {
+		top = 100
+	}
+
+	stats, err := t.cacheStore.MailboxStats(opts, interval, top)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute mailbox stats: %w", err)
+	}
+
+	return stats, nil
+}
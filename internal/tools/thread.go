@@ -0,0 +1,187 @@
+package tools
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/brandon/mcp-email/internal/cache"
+	"github.com/brandon/mcp-email/internal/config"
+	"github.com/brandon/mcp-email/internal/email"
+)
+
+// GetThreadTool retrieves the conversation an email belongs to
+type GetThreadTool struct {
+	config       *config.Config
+	emailManager *email.Manager
+	cacheStore   *cache.Store
+	logger       *logrus.Logger
+}
+
+// NewGetThreadTool creates a new get thread tool
+func NewGetThreadTool(cfg *config.Config, emailManager *email.Manager, cacheStore *cache.Store, logger *logrus.Logger) *GetThreadTool {
+	return &GetThreadTool{
+		config:       cfg,
+		emailManager: emailManager,
+		cacheStore:   cacheStore,
+		logger:       logger,
+	}
+}
+
+// Name returns the tool name
+func (t *GetThreadTool) Name() string {
+	return "get_thread"
+}
+
+// Description returns the tool description
+func (t *GetThreadTool) Description() string {
+	return "Retrieve a conversation, oldest message first, across all folders of its account " +
+		"(sent replies included). Quoted history is stripped from message bodies by default"
+}
+
+// InputSchema returns the JSON schema for tool inputs
+func (t *GetThreadTool) InputSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"thread_id": map[string]interface{}{
+				"type":        "integer",
+				"description": "Thread ID (from search results or get_email); either thread_id or email_id is required",
+			},
+			"email_id": map[string]interface{}{
+				"type":        "integer",
+				"description": "Optional: ID of any email in the thread",
+			},
+			"include_quoted": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Optional: Keep quoted history in message bodies (default: false)",
+			},
+		},
+	}
+}
+
+// Execute executes the tool
+func (t *GetThreadTool) Execute(params map[string]interface{}) (interface{}, error) {
+	threadID, err := parseIDParam(params, "thread_id")
+	if err != nil {
+		return nil, err
+	}
+
+	if threadID == 0 {
+		emailID, err := parseIDParam(params, "email_id")
+		if err != nil {
+			return nil, err
+		}
+		if emailID == 0 {
+			return nil, fmt.Errorf("thread_id or email_id is required")
+		}
+		if threadID, err = t.emailThreadID(emailID); err != nil {
+			return nil, err
+		}
+	}
+
+	includeQuoted := false
+	if v, ok := params["include_quoted"].(bool); ok {
+		includeQuoted = v
+	}
+
+	thread, err := t.cacheStore.GetThread(threadID)
+	if err != nil {
+		return nil, err
+	}
+
+	emails, err := t.cacheStore.ThreadEmails(threadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread: %w", err)
+	}
+
+	messages := make([]map[string]interface{}, 0, len(emails))
+	var participants []string
+	seenParticipants := make(map[string]bool)
+
+	for _, e := range emails {
+		body := e.BodyText
+		if !includeQuoted {
+			body = email.StripQuoted(body)
+		}
+
+		message := map[string]interface{}{
+			"id":           e.ID,
+			"message_id":   e.MessageID,
+			"folder_path":  e.FolderPath,
+			"folders":      e.FolderPaths(),
+			"subject":      e.Subject,
+			"sender_name":  e.SenderName,
+			"sender_email": e.SenderEmail,
+			"recipients":   e.Recipients,
+			"date":         e.Date.Format(time.RFC3339),
+			"body_text":    body,
+			"flags":        e.Flags,
+		}
+		if e.InReplyTo != "" {
+			message["in_reply_to"] = e.InReplyTo
+		}
+		messages = append(messages, message)
+
+		if e.SenderEmail != "" && !seenParticipants[e.SenderEmail] {
+			seenParticipants[e.SenderEmail] = true
+			participants = append(participants, e.SenderEmail)
+		}
+	}
+
+	return map[string]interface{}{
+		"thread_id":     thread.ID,
+		"account_name":  thread.AccountName,
+		"subject":       thread.Subject,
+		"message_count": len(messages),
+		"first_date":    thread.FirstDate.Format(time.RFC3339),
+		"last_date":     thread.LastDate.Format(time.RFC3339),
+		"participants":  participants,
+		"messages":      messages,
+	}, nil
+}
+
+// emailThreadID returns the thread of a cached email, threading its account
+// first if the email has not been assigned one yet
+func (t *GetThreadTool) emailThreadID(emailID int64) (int64, error) {
+	cached, err := t.cacheStore.GetEmail(emailID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get email: %w", err)
+	}
+	if cached.ThreadID != 0 {
+		return cached.ThreadID, nil
+	}
+
+	if err := t.cacheStore.RebuildThreads(cached.AccountID); err != nil {
+		return 0, fmt.Errorf("failed to thread emails: %w", err)
+	}
+	if cached, err = t.cacheStore.GetEmail(emailID); err != nil {
+		return 0, fmt.Errorf("failed to get email: %w", err)
+	}
+	if cached.ThreadID == 0 {
+		return 0, fmt.Errorf("email %d has no thread", emailID)
+	}
+
+	return cached.ThreadID, nil
+}
+
+// parseIDParam parses an optional integer ID given as a number or string;
+// zero means absent
+func parseIDParam(params map[string]interface{}, key string) (int64, error) {
+	switch v := params[key].(type) {
+	case float64:
+		return int64(v), nil
+	case string:
+		if v == "" {
+			return 0, nil
+		}
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		return id, nil
+	}
+	return 0, nil
+}
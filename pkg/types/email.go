@@ -1,40 +1,120 @@
 package types
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
-// Email represents an email message
+// Email represents an email message. A message kept in several folders is
+// one Email with a location per folder; FolderID, FolderPath and UID are
+// those of its primary location.
 type Email struct {
-	ID          int64             `json:"id"`
-	AccountID   int               `json:"account_id"`
-	AccountName string            `json:"account_name"`
-	FolderID    int               `json:"folder_id"`
-	FolderPath  string            `json:"folder_path"`
-	UID         uint32            `json:"uid"`
-	MessageID   string            `json:"message_id"`
-	Subject     string            `json:"subject"`
-	SenderName  string            `json:"sender_name"`
-	SenderEmail string            `json:"sender_email"`
-	Recipients  []string          `json:"recipients"`
-	Date        time.Time         `json:"date"`
-	BodyText    string            `json:"body_text,omitempty"`
-	BodyHTML    string            `json:"body_html,omitempty"`
-	Headers     map[string]string `json:"headers,omitempty"`
-	Flags       []string          `json:"flags,omitempty"`
-	CachedAt    time.Time         `json:"cached_at"`
+	ID              int64               `json:"id"`
+	AccountID       int                 `json:"account_id"`
+	AccountName     string              `json:"account_name"`
+	FolderID        int                 `json:"folder_id"`
+	FolderPath      string              `json:"folder_path"`
+	UID             uint32              `json:"uid"`
+	MessageID       string              `json:"message_id"`
+	Subject         string              `json:"subject"`
+	SenderName      string              `json:"sender_name"`
+	SenderEmail     string              `json:"sender_email"`
+	Recipients      []string            `json:"recipients"`
+	Date            time.Time           `json:"date"`
+	BodyText        string              `json:"body_text,omitempty"`
+	BodyHTML        string              `json:"body_html,omitempty"`
+	Headers         map[string][]string `json:"headers,omitempty"`
+	Flags           []string            `json:"flags,omitempty"`
+	AttachmentCount int                 `json:"attachment_count"`
+	InReplyTo       string              `json:"in_reply_to,omitempty"`
+	References      []string            `json:"references,omitempty"`
+	ListID          string              `json:"list_id,omitempty"`
+	ReplyTo         string              `json:"reply_to,omitempty"`
+	ReturnPath      string              `json:"return_path,omitempty"`
+	XMailer         string              `json:"x_mailer,omitempty"`
+	ThreadID        int64               `json:"thread_id,omitempty"`
+	// BodyEvicted is set when retention dropped the cached body and headers
+	BodyEvicted bool `json:"body_evicted,omitempty"`
+	// Locations lists every folder holding the message, primary first
+	Locations []EmailLocation `json:"locations,omitempty"`
+	CachedAt  time.Time       `json:"cached_at"`
+}
+
+// EmailLocation is a folder holding a message and the message's UID there
+type EmailLocation struct {
+	FolderID   int    `json:"folder_id"`
+	FolderPath string `json:"folder_path"`
+	UID        uint32 `json:"uid"`
+}
+
+// FolderPaths returns the paths of the folders holding the email, primary
+// first
+func (e *Email) FolderPaths() []string {
+	if len(e.Locations) == 0 {
+		return []string{e.FolderPath}
+	}
+	paths := make([]string, len(e.Locations))
+	for i, loc := range e.Locations {
+		paths[i] = loc.FolderPath
+	}
+	return paths
 }
 
 // EmailSummary represents a summary of an email (for search results)
 type EmailSummary struct {
-	ID          int64     `json:"id"`
-	AccountName string    `json:"account_name"`
-	FolderPath  string    `json:"folder_path"`
+	ID          int64  `json:"id"`
+	AccountName string `json:"account_name"`
+	FolderPath  string `json:"folder_path"`
+	// Folders lists every folder holding the message, primary first
+	Folders     []string  `json:"folders,omitempty"`
 	Subject     string    `json:"subject"`
 	SenderName  string    `json:"sender_name"`
 	SenderEmail string    `json:"sender_email"`
 	Date        time.Time `json:"date"`
 	Snippet     string    `json:"snippet"`
+	ThreadID    int64     `json:"thread_id,omitempty"`
+	// HighlightedSubject marks full-text matches in the subject, if any
+	HighlightedSubject string `json:"highlighted_subject,omitempty"`
+	// Score is the relevance score of a full-text match; higher is better
+	Score float64 `json:"score,omitempty"`
+}
+
+// Thread represents a conversation reconstructed from message references
+type Thread struct {
+	ID           int64     `json:"id"`
+	AccountID    int       `json:"account_id"`
+	AccountName  string    `json:"account_name"`
+	Subject      string    `json:"subject"`
+	MessageCount int       `json:"message_count"`
+	FirstDate    time.Time `json:"first_date"`
+	LastDate     time.Time `json:"last_date"`
+}
+
+// HeaderValues returns the values of a header, matching its name without
+// regard to case
+func (e *Email) HeaderValues(name string) []string {
+	if values, ok := e.Headers[name]; ok {
+		return values
+	}
+	for key, values := range e.Headers {
+		if strings.EqualFold(key, name) {
+			return values
+		}
+	}
+	return nil
 }
 
+// Folder roles derived from SPECIAL-USE attributes (RFC 6154)
+const (
+	FolderRoleInbox   = "inbox"
+	FolderRoleSent    = "sent"
+	FolderRoleDrafts  = "drafts"
+	FolderRoleTrash   = "trash"
+	FolderRoleJunk    = "junk"
+	FolderRoleArchive = "archive"
+	FolderRoleAll     = "all"
+)
+
 // Folder represents an email folder/mailbox
 type Folder struct {
 	ID           int        `json:"id"`
@@ -42,6 +122,38 @@ type Folder struct {
 	AccountName  string     `json:"account_name"`
 	Name         string     `json:"name"`
 	Path         string     `json:"path"`
+	ParentPath   string     `json:"parent_path,omitempty"`
+	Delimiter    string     `json:"delimiter,omitempty"`
+	Attributes   []string   `json:"attributes,omitempty"`
+	SpecialUse   string     `json:"special_use,omitempty"`
 	MessageCount int        `json:"message_count"`
+	UnseenCount  int        `json:"unseen_count"`
+	RecentCount  int        `json:"recent_count"`
 	LastSynced   *time.Time `json:"last_synced,omitempty"`
 }
+
+// HasAttribute reports whether the folder carries a mailbox attribute
+func (f *Folder) HasAttribute(attr string) bool {
+	for _, a := range f.Attributes {
+		if strings.EqualFold(a, attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Selectable reports whether the folder can hold messages
+func (f *Folder) Selectable() bool {
+	return !f.HasAttribute("\\Noselect") && !f.HasAttribute("\\NonExistent")
+}
+
+// SplitFolderPath splits a mailbox path into its parent path and leaf name
+func SplitFolderPath(path, delimiter string) (parent, name string) {
+	if delimiter == "" {
+		return "", path
+	}
+	if i := strings.LastIndex(path, delimiter); i >= 0 {
+		return path[:i], path[i+len(delimiter):]
+	}
+	return "", path
+}